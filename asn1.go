@@ -66,10 +66,17 @@ func (a *AsnItem) headersize() int {
 }
 
 func (a *AsnItem) size() int {
+	if a.raw != nil {
+		return len(a.raw)
+	}
 	return a.headersize() + a.sz
 }
 
 func (a *AsnItem) write(out []byte) int {
+	if a.raw != nil {
+		copy(out, a.raw)
+		return len(a.raw)
+	}
 	hsz := a.headersize()
 	if hsz < 0 {
 		return -1
@@ -119,16 +126,17 @@ func AsnNull() *AsnItem {
 }
 
 func AsnInteger(i int) *AsnItem {
-	var data []byte = nil
 	if (i < 0) {
 		panic("unsupported")
 	}
-	if (i < 255) {
-		data = []byte{ byte(i) }
-	} else if i < 65535 {
-		data = []byte{ byte(i >> 8), byte(i & 0xff) }
-	} else {
-		panic("unsupported")
+	// big-endian in as few bytes as it takes, with a leading zero byte if
+	// the high bit is set, as an INTEGER is signed
+	data := []byte{ byte(i) }
+	for i >>= 8; i > 0; i >>= 8 {
+		data = append([]byte{ byte(i) }, data...)
+	}
+	if data[0] & 0x80 != 0 {
+		data = append([]byte{ 0 }, data...)
 	}
 	return &AsnItem{ tag: TagInteger, sz: len(data), content: data }
 }
@@ -149,6 +157,12 @@ func AsnRaw(_tag int, _data []byte) *AsnItem {
 	return &AsnItem{ tag: _tag, sz: len(_data), content: _data }
 }
 
+// AsnEncoded returns a sealed item for data that is already DER encoded,
+// which is written verbatim.
+func AsnEncoded(der []byte) *AsnItem {
+	return &AsnItem{ raw: der }
+}
+
 func AsnOID(oid []byte) *AsnItem {
 	return &AsnItem{ tag: TagOID, sz: len(oid), content: oid }
 }
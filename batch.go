@@ -0,0 +1,126 @@
+package pkcs12
+
+import "bytes"
+
+// ReEncryptBatch decrypts each of files with password and encrypts it again
+// with the algorithms set by opts, keeping the password. See
+// Encoder.ReEncryptBatch.
+func ReEncryptBatch(files [][]byte, password string, opts ...EncodeOption) ([][]byte, error) {
+	return NewEncoder(opts...).ReEncryptBatch(files, password)
+}
+
+// ReEncryptBatch is ReEncrypt over each of files, all decrypted with
+// password, for re-encrypting many keystores at once. By itself it is no
+// faster than calling ReEncrypt for each file, as every file gets salts of
+// its own: the speedup requires WithBatchSalts.
+//
+// With WithBatchSalts, all files share the same salts, and the keys derived
+// for the encryption and the MAC of the first file are reused for the
+// others rather than derived again; they are zeroed before ReEncryptBatch
+// returns. The derivations of the decryption are not shared. Sharing salts
+// gives every file the same keys, which trades the protection salts give for
+// the speed: the files can be linked to one another, and a single
+// precomputation attacks all of them. With the PBES1 algorithms, whose IV is
+// derived along with the key, identical plaintext prefixes also encrypt
+// identically. Only use it where the files are not exposed to such attacks.
+//
+// If a file fails, ReEncryptBatch returns a *BatchError for it.
+func (enc *Encoder) ReEncryptBatch(files [][]byte, password string) ([][]byte, error) {
+	batch := *enc
+	if enc.fixedBatchSalts {
+		// salts are random otherwise, so no derivation could be reused
+		batch.batchSalts = new(batchSalts)
+		batch.keys = new(derivedKeys)
+		defer batch.keys.wipe()
+	}
+
+	reencrypted := make([][]byte, len(files))
+	for i, pfxData := range files {
+		if batch.batchSalts != nil {
+			batch.batchSalts.next = 0
+		}
+		var err error
+		if reencrypted[i], err = batch.ReEncrypt(pfxData, password); err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+	}
+	return reencrypted, nil
+}
+
+// WithBatchSalts makes ReEncryptBatch give every file the same salts, the
+// n-th salt of each file being the n-th of the first, so that the keys
+// derived for the first file are reused for the others, in controlled
+// environments where that is acceptable; see Encoder.ReEncryptBatch. The
+// salts of a single file still differ. It has no effect on one-off encodings.
+func WithBatchSalts() EncodeOption {
+	return func(enc *Encoder) { enc.fixedBatchSalts = true }
+}
+
+// batchSalts hands out the salts of the first file of a ReEncryptBatch again
+// for the others.
+type batchSalts struct {
+	salts [][]byte
+	next  int
+}
+
+// salt returns the next salt of length, obtained from generate the first
+// time it is asked for.
+func (s *batchSalts) salt(length int, generate func(length int) ([]byte, error)) ([]byte, error) {
+	defer func() { s.next++ }()
+	if s.next < len(s.salts) && len(s.salts[s.next]) == length {
+		return s.salts[s.next], nil
+	}
+	salt, err := generate(length)
+	if err != nil {
+		return nil, err
+	}
+	if s.next == len(s.salts) {
+		s.salts = append(s.salts, salt)
+	}
+	return salt, nil
+}
+
+// derivedKeys memoizes the keys, IVs and MAC keys derived by the encodings of
+// a ReEncryptBatch. A nil *derivedKeys derives every key anew.
+type derivedKeys struct {
+	entries []derivedKey
+}
+
+type derivedKey struct {
+	kind       string
+	salt       []byte
+	password   []byte
+	iterations int
+	key        []byte
+}
+
+// derive returns the key of kind derived from salt, password and iterations,
+// calling derive the first time it is asked for. The key must not be
+// modified.
+func (d *derivedKeys) derive(kind string, salt, password []byte, iterations int, derive func() []byte) []byte {
+	if d == nil {
+		return derive()
+	}
+	for _, e := range d.entries {
+		if e.kind == kind && e.iterations == iterations && bytes.Equal(e.salt, salt) && bytes.Equal(e.password, password) {
+			return e.key
+		}
+	}
+	key := derive()
+	d.entries = append(d.entries, derivedKey{
+		kind:       kind,
+		salt:       append([]byte(nil), salt...),
+		password:   append([]byte(nil), password...),
+		iterations: iterations,
+		key:        key,
+	})
+	return key
+}
+
+// wipe zeroes the passwords and keys held by d.
+func (d *derivedKeys) wipe() {
+	for _, e := range d.entries {
+		wipe(e.password, [][]byte{e.key})
+	}
+	d.entries = nil
+}
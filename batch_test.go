@@ -0,0 +1,178 @@
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// batchTestFiles returns n copies of the test identity encoded with
+// password, each with its own salts.
+func batchTestFiles(tb testing.TB, n int, password string) [][]byte {
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+	key, cert, err := Decode(p12, []byte(""))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	files := make([][]byte, n)
+	for i := range files {
+		if files[i], err = Encode(key, cert, nil, password); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return files
+}
+
+// batchSaltsOf returns the MAC salt of pfxData and the salts of its
+// encrypted parts.
+func batchSaltsOf(t *testing.T, pfxData []byte) [][]byte {
+	pfx, _, err := parsePfx(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salts := [][]byte{pfx.MacData.MacSalt}
+	parts, err := EncryptedParts(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range parts {
+		params := part.Algorithm.Parameters.FullBytes
+		if part.Algorithm.Algorithm.Equal(oidPBES2) {
+			var pbes2 pbes2Params
+			if _, err := asn1.Unmarshal(params, &pbes2); err != nil {
+				t.Fatal(err)
+			}
+			params = pbes2.KeyDerivationFunc.Parameters.FullBytes
+		}
+		// pbeParams and pbkdf2Params both start with the salt
+		var salt pbeParams
+		if _, err := asn1.Unmarshal(params, &salt); err != nil {
+			t.Fatal(err)
+		}
+		salts = append(salts, salt.Salt)
+	}
+	return salts
+}
+
+func TestReEncryptBatch(t *testing.T) {
+	files := batchTestFiles(t, 3, "batch")
+
+	for _, fixed := range []bool{false, true} {
+		opts := []EncodeOption{WithKeyAlgorithm(PBES2AES256CBC), WithMacAlgorithm(SHA256)}
+		if fixed {
+			opts = append(opts, WithBatchSalts())
+		}
+		reencrypted, err := ReEncryptBatch(files, "batch", opts...)
+		if err != nil {
+			t.Fatalf("batch salts %t: %v", fixed, err)
+		}
+		if len(reencrypted) != len(files) {
+			t.Fatalf("batch salts %t: expected %d files, found %d", fixed, len(files), len(reencrypted))
+		}
+		first := batchSaltsOf(t, reencrypted[0])
+		if bytes.Equal(first[0], first[1]) {
+			t.Errorf("batch salts %t: expected the salts of a file to differ", fixed)
+		}
+		for i, p12 := range reencrypted {
+			key, cert, _, err := DecodeChain(p12, "batch")
+			if err != nil {
+				t.Fatalf("batch salts %t: file %d: %v", fixed, i, err)
+			}
+			if key == nil || cert == nil {
+				t.Errorf("batch salts %t: file %d: expected a key and a certificate", fixed, i)
+			}
+			if i == 0 {
+				continue
+			}
+			for j, salt := range batchSaltsOf(t, p12) {
+				if same := bytes.Equal(salt, first[j]); same != fixed {
+					t.Errorf("batch salts %t: file %d: salt %d shared with the first file: %t", fixed, i, j, same)
+				}
+			}
+		}
+	}
+}
+
+func TestReEncryptBatchError(t *testing.T) {
+	files := batchTestFiles(t, 2, "batch")
+	files = append(files, batchTestFiles(t, 1, "other")...)
+
+	_, err := ReEncryptBatch(files, "batch", WithBatchSalts())
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected a *BatchError, found %v", err)
+	}
+	if batchErr.Index != 2 {
+		t.Errorf("expected file 2 to fail, found file %d", batchErr.Index)
+	}
+	if !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("expected ErrIncorrectPassword, found %v", err)
+	}
+}
+
+func TestDerivedKeys(t *testing.T) {
+	calls := 0
+	derive := func() []byte {
+		calls++
+		return []byte{byte(calls)}
+	}
+	salt, password := []byte("salt"), []byte("password")
+
+	var keys *derivedKeys
+	keys.derive("MAC", salt, password, 1, derive)
+	keys.derive("MAC", salt, password, 1, derive)
+	if calls != 2 {
+		t.Errorf("expected a nil *derivedKeys to derive each time, derived %d times", calls)
+	}
+
+	calls = 0
+	keys = new(derivedKeys)
+	first := keys.derive("MAC", salt, password, 1, derive)
+	if again := keys.derive("MAC", salt, password, 1, derive); !bytes.Equal(again, first) {
+		t.Errorf("expected the key derived before, found %x", again)
+	}
+	keys.derive("key", salt, password, 1, derive)
+	keys.derive("MAC", []byte("other"), password, 1, derive)
+	keys.derive("MAC", salt, []byte("other"), 1, derive)
+	keys.derive("MAC", salt, password, 2, derive)
+	if calls != 5 {
+		t.Errorf("expected 5 derivations, found %d", calls)
+	}
+
+	keys.wipe()
+	if first[0] != 0 || password[0] == 0 {
+		t.Error("expected wipe to zero the keys, and the copies of the passwords only")
+	}
+}
+
+// BenchmarkReEncryptBatch compares re-encrypting files one by one with
+// ReEncrypt and with ReEncryptBatch and WithBatchSalts, which shares the
+// salts across files to derive the keys of the encryption and MAC once for
+// all of them.
+func BenchmarkReEncryptBatch(b *testing.B) {
+	const password = "batch"
+	files := batchTestFiles(b, 10, password)
+	opts := []EncodeOption{WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES256CBC), WithMacAlgorithm(SHA256)}
+
+	b.Run(fmt.Sprintf("ReEncrypt/%d", len(files)), func(b *testing.B) {
+		enc := NewEncoder(opts...)
+		for i := 0; i < b.N; i++ {
+			for _, p12 := range files {
+				if _, err := enc.ReEncrypt(p12, password); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run(fmt.Sprintf("ReEncryptBatchWithBatchSalts/%d", len(files)), func(b *testing.B) {
+		enc := NewEncoder(append(opts, WithBatchSalts())...)
+		for i := 0; i < b.N; i++ {
+			if _, err := enc.ReEncryptBatch(files, password); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -2,6 +2,7 @@ package pkcs12
 
 import (
 	"errors"
+	"strings"
 	"unicode/utf16"
 	"unicode/utf8"
 )
@@ -10,38 +11,67 @@ func bmpString(utf8String []byte) ([]byte, error) {
 	// References:
 	// https://tools.ietf.org/html/rfc7292#appendix-B.1
 	// http://en.wikipedia.org/wiki/Plane_(Unicode)#Basic_Multilingual_Plane
-	//  - non-BMP characters are encoded in UTF 16 by using a surrogate pair of 16-bit codes
-	//	  EncodeRune returns 0xfffd if the rune does not need special encoding
+	//  - non-BMP characters are encoded in UTF 16 by using a surrogate pair of 16-bit codes,
+	//    which UCS-2 lacks, so they cannot be encoded
+	//  - invalid UTF-8 decodes to, and is encoded as, U+FFFD
 	//  - the above RFC provides the info that BMPStrings are NULL terminated.
 
-	rv := make([]byte, 0, 2*len(utf8String)+2)
+	// every character of the BMP is a single UTF-16 code, so the length is
+	// known up front and the result is allocated once
+	rv := make([]byte, 2*utf8.RuneCount(utf8String)+2)
 
-	start := 0
-	for start < len(utf8String) {
+	i := 0
+	for start := 0; start < len(utf8String); i += 2 {
+		if b := utf8String[start]; b < utf8.RuneSelf {
+			rv[i+1] = b
+			start++
+			continue
+		}
 		c, size := utf8.DecodeRune(utf8String[start:])
 		start += size
-		if t, _ := utf16.EncodeRune(c); t != 0xfffd {
+		if c > 0xffff {
+			wipe(rv, nil)
 			return nil, errors.New("string contains characters that cannot be encoded in UCS-2")
 		}
-		rv = append(rv, byte(c/256), byte(c%256))
+		rv[i], rv[i+1] = byte(c>>8), byte(c)
 	}
-	rv = append(rv, 0, 0)
+	// the last two bytes are left zero as the NULL terminator
 	return rv, nil
 }
 
+// nullTerminated reports whether the BMP string ends with a NULL terminator.
+func nullTerminated(bmpString []byte) bool {
+	n := len(bmpString)
+	return n >= 2 && bmpString[n-2] == 0 && bmpString[n-1] == 0
+}
+
+// decodeBMPName decodes a BMPString name, such as a friendlyName, up to its
+// first NULL, as Windows displays it. Some Windows files write a NULL, or a
+// NULL and stale characters, inside the string.
+func decodeBMPName(bmpString []byte) (string, error) {
+	s, err := decodeBMPString(bmpString)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return s, err
+}
+
 func decodeBMPString(bmpString []byte) (string, error) {
 	if len(bmpString)%2 != 0 {
 		return "", errors.New("expected BMP byte string to be an even length")
 	}
 
 	// strip terminator if present
-	if terminator := bmpString[len(bmpString)-2:]; terminator[0] == terminator[1] && terminator[1] == 0 {
+	if len(bmpString) < 2 {
+		return "", nil
+	}
+	if nullTerminated(bmpString) {
 		bmpString = bmpString[:len(bmpString)-2]
 	}
 
 	s := make([]uint16, 0, len(bmpString)/2)
 	for len(bmpString) > 0 {
-		s = append(s, uint16(bmpString[0])*265+uint16(bmpString[1]))
+		s = append(s, uint16(bmpString[0])*256+uint16(bmpString[1]))
 		bmpString = bmpString[2:]
 	}
 
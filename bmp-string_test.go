@@ -40,3 +40,88 @@ func TestBMPString(t *testing.T) {
 		t.Errorf("expected '%s' to throw error because the first character is not in the BMP", tst)
 	}
 }
+
+func TestDecodeBMPName(t *testing.T) {
+	for _, test := range []struct {
+		bmp      []byte
+		expected string
+	}{
+		{[]byte{0, 'a', 0, 'b'}, "ab"},
+		{[]byte{0, 'a', 0, 'b', 0, 0}, "ab"},
+		{[]byte{0, 'a', 0, 'b', 0, 0, 0, 0}, "ab"},
+		{[]byte{0, 'a', 0, 0, 0, 'b'}, "a"},
+		{[]byte{0, 0, 0, 'b'}, ""},
+	} {
+		if name, err := decodeBMPName(test.bmp); err != nil || name != test.expected {
+			t.Errorf("decodeBMPName(% x) = %q, %v, want %q", test.bmp, name, err, test.expected)
+		}
+	}
+}
+
+func TestBMPStringEncoding(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		expected []byte
+	}{
+		{"a\u00e9\u20ac", []byte{0x00, 0x61, 0x00, 0xe9, 0x20, 0xac, 0x00, 0x00}},
+		// the highest character of the BMP, just below the surrogates
+		{"\uffff\ud7ff", []byte{0xff, 0xff, 0xd7, 0xff, 0x00, 0x00}},
+		// invalid UTF-8 is encoded as the replacement character
+		{"\xff", []byte{0xff, 0xfd, 0x00, 0x00}},
+	} {
+		str, err := bmpString([]byte(test.in))
+		if err != nil {
+			t.Errorf("%+q: %v", test.in, err)
+		} else if !bytes.Equal(str, test.expected) {
+			t.Errorf("%+q: expected % x, found % x", test.in, test.expected, str)
+		} else if cap(str) != len(str) {
+			t.Errorf("%+q: expected a single allocation of %d bytes, found a capacity of %d", test.in, len(str), cap(str))
+		}
+	}
+
+	// characters outside the BMP would need a surrogate pair, which UCS-2
+	// does not have
+	for _, tst := range []string{"\U00010000", "a\U0010ffff"} {
+		if _, err := bmpString([]byte(tst)); err == nil {
+			t.Errorf("expected %+q to throw error because it is not in the BMP", tst)
+		}
+	}
+}
+
+func BenchmarkBMPString(b *testing.B) {
+	for name, password := range map[string]string{
+		"ASCII":    "correct horse battery staple",
+		"NonASCII": "\u00e7a \u20ac \u2115 d\u00e9j\u00e0",
+	} {
+		b.Run(name, func(b *testing.B) {
+			utf8Password := []byte(password)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bmpString(utf8Password); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBMPStringNonASCII(t *testing.T) {
+	// a friendlyName with characters whose high byte is not zero, each held
+	// by a big-endian pair of bytes
+	name := "clé € ℕ"
+	bmp, err := bmpString([]byte(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeBMPString(bmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != name {
+		t.Errorf("expected %q, found %q", name, decoded)
+	}
+
+	if decoded, err = decodeBMPString(nil); err != nil || decoded != "" {
+		t.Errorf("expected an empty BMPString to decode to an empty string, found %q, err: %v", decoded, err)
+	}
+}
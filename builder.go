@@ -0,0 +1,395 @@
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Attribute is a PKCS#9 attribute of a safe bag. Value holds the DER
+// encoding of the SET OF attribute values.
+type Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// NewLocalKeyIDAttribute returns a localKeyId attribute holding id.
+func NewLocalKeyIDAttribute(id []byte) Attribute {
+	return newAttribute(oidLocalKeyID, id)
+}
+
+// NewFriendlyNameAttribute returns a friendlyName attribute holding name.
+func NewFriendlyNameAttribute(name string) (Attribute, error) {
+	return newBMPStringAttribute(oidFriendlyName, name)
+}
+
+// OIDKeyProviderName identifies the Microsoft keyProviderName attribute of a
+// key bag, which names the CSP or KSP that Windows imports the key into.
+var OIDKeyProviderName = oidMicrosoftCSPName
+
+// NewKeyProviderNameAttribute returns a keyProviderName attribute holding
+// name, such as "Microsoft Software Key Storage Provider".
+func NewKeyProviderNameAttribute(name string) (Attribute, error) {
+	return newBMPStringAttribute(oidMicrosoftCSPName, name)
+}
+
+// OIDTrustedKeyUsage identifies the Oracle trustedKeyUsage attribute of a
+// cert bag, which Java keytool reads as a trustedCertEntry.
+var OIDTrustedKeyUsage = oidTrustedKeyUsage
+
+// NewTrustedKeyUsageAttribute returns a trustedKeyUsage attribute, marking a
+// certificate as a trust anchor for usages. With no usages, the certificate
+// is trusted for anyExtendedKeyUsage, as keytool does.
+func NewTrustedKeyUsageAttribute(usages ...asn1.ObjectIdentifier) Attribute {
+	if len(usages) == 0 {
+		usages = []asn1.ObjectIdentifier{oidAnyExtendedKeyUsage}
+	}
+	values := make([]interface{}, len(usages))
+	for i, usage := range usages {
+		values[i] = usage
+	}
+	return newAttribute(oidTrustedKeyUsage, values...)
+}
+
+func newBMPStringAttribute(id asn1.ObjectIdentifier, value string) (Attribute, error) {
+	s, err := bmpString([]byte(value))
+	if err != nil {
+		return Attribute{}, err
+	}
+	// attribute values are not NULL terminated
+	return newAttribute(id, asn1.RawValue{Tag: asn1.TagBMPString, Bytes: s[:len(s)-2]}), nil
+}
+
+func newAttribute(id asn1.ObjectIdentifier, values ...interface{}) Attribute {
+	set := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+	for _, v := range values {
+		der, err := asn1.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		set.Bytes = append(set.Bytes, der...)
+	}
+	set.FullBytes, _ = asn1.Marshal(set)
+	return Attribute{ID: id, Value: set}
+}
+
+func (a Attribute) item() (*AsnItem, error) {
+	value := a.Value.FullBytes
+	if value == nil {
+		var err error
+		if value, err = asn1.Marshal(a.Value); err != nil {
+			return nil, err
+		}
+	}
+	w := AsnSequence()
+	w.append(asnObjectIdentifier(a.ID))
+	w.append(AsnEncoded(value))
+	return w, nil
+}
+
+func asnObjectIdentifier(oid asn1.ObjectIdentifier) *AsnItem {
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		panic(err)
+	}
+	return AsnEncoded(der)
+}
+
+// ContentInfoBuilder assembles a SafeContents, and the data or encryptedData
+// ContentInfo that carries it in the authenticated safe of a PFX.
+type ContentInfoBuilder struct {
+	algorithm  EncryptionAlgorithm
+	salt       []byte
+	iterations int
+	bags       []func(password []byte) (*AsnItem, error)
+
+	// rand is where IVs and missing salts are read from, crypto/rand if nil
+	rand io.Reader
+
+	// keys holds the keys derived by earlier builds, if set
+	keys *derivedKeys
+}
+
+// NewContentInfoBuilder returns a builder for a ContentInfo of type data,
+// whose SafeContents is not encrypted.
+func NewContentInfoBuilder() *ContentInfoBuilder {
+	return &ContentInfoBuilder{}
+}
+
+// NewEncryptedContentInfoBuilder returns a builder for a ContentInfo of type
+// encryptedData, whose SafeContents is encrypted with the password-based
+// encryption algorithm. If salt is nil, a random salt is generated when the
+// PFX is built.
+func NewEncryptedContentInfoBuilder(algorithm EncryptionAlgorithm, salt []byte, iterations int) *ContentInfoBuilder {
+	return &ContentInfoBuilder{algorithm: algorithm, salt: salt, iterations: iterations}
+}
+
+// AddBag adds a safe bag of type id to the SafeContents. value is the DER
+// encoding of the bag's value.
+func (b *ContentInfoBuilder) AddBag(id asn1.ObjectIdentifier, value []byte, attributes ...Attribute) {
+	b.bags = append(b.bags, func([]byte) (*AsnItem, error) {
+		return safeBagItem(asnObjectIdentifier(id), AsnEncoded(value), attributes)
+	})
+}
+
+// addRawBag adds the DER encoded safe bag as it is.
+func (b *ContentInfoBuilder) addRawBag(der []byte) {
+	b.bags = append(b.bags, func([]byte) (*AsnItem, error) {
+		return AsnEncoded(der), nil
+	})
+}
+
+// AddCertificate adds a certBag holding the DER encoded X.509 certificate.
+func (b *ContentInfoBuilder) AddCertificate(certificate []byte, attributes ...Attribute) {
+	b.bags = append(b.bags, func([]byte) (*AsnItem, error) {
+		value := AsnSequence()
+		value.append(AsnOID(oid_pkcs9_x509cert))
+		value.append(AsnCC(0)).append(AsnOctetString(certificate))
+		return safeBagItem(AsnOID(oid_pkcs12_certbag), value, attributes)
+	})
+}
+
+// AddKey adds a keyBag holding the PKCS#8 private key unencrypted. It should
+// only be added to a ContentInfo of type encryptedData.
+func (b *ContentInfoBuilder) AddKey(privateKey []byte, attributes ...Attribute) {
+	b.bags = append(b.bags, func([]byte) (*AsnItem, error) {
+		return safeBagItem(asnObjectIdentifier(oidKeyBagType), AsnEncoded(privateKey), attributes)
+	})
+}
+
+// AddShroudedKey adds a pkcs8ShroudedKeyBag holding the PKCS#8 private key,
+// encrypted with the password-based encryption algorithm. If salt is nil, a
+// random salt is generated when the PFX is built.
+func (b *ContentInfoBuilder) AddShroudedKey(privateKey []byte, algorithm EncryptionAlgorithm, salt []byte, iterations int, attributes ...Attribute) {
+	b.bags = append(b.bags, func(password []byte) (*AsnItem, error) {
+		algorithmItem, encrypted, err := encryptWith(algorithm, salt, iterations, privateKey, password, b.rand, b.keys)
+		if err != nil {
+			return nil, err
+		}
+		value := AsnSequence()
+		value.append(algorithmItem)
+		value.append(AsnOctetString(encrypted))
+		return safeBagItem(AsnOID(oid_pkcs12_shrouded_keybag), value, attributes)
+	})
+}
+
+func safeBagItem(id, value *AsnItem, attributes []Attribute) (*AsnItem, error) {
+	w := AsnSequence()
+	w.append(id)
+	w.append(AsnCC(0)).append(value)
+	if len(attributes) > 0 {
+		set := w.append(AsnSet())
+		for _, attribute := range attributes {
+			a, err := attribute.item()
+			if err != nil {
+				return nil, err
+			}
+			set.append(a)
+		}
+	}
+	return w, nil
+}
+
+// encryptWith encrypts message and returns it along with the
+// AlgorithmIdentifier describing how it was encrypted. Any randomness needed
+// is read from random, and keys derived before are taken from keys, which
+// may be nil.
+func encryptWith(algorithm EncryptionAlgorithm, salt []byte, iterations int, message, password []byte, random io.Reader, keys *derivedKeys) (*AsnItem, []byte, error) {
+	name := string(algorithm)
+	_, isPBES2 := pbes2SchemeByAlg[name]
+	oid, hasOID := oidByAlg[name]
+	if _, isBlock := blockcodeByAlg[name]; !isPBES2 && (!hasOID || !isBlock) {
+		return nil, nil, NotImplementedError("algorithm " + name + " is not supported for encryption")
+	}
+	if salt == nil {
+		var err error
+		if salt, err = readRandomBytes(random, defaultSaltLength); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if isPBES2 {
+		if len(salt) == 0 {
+			return nil, nil, errors.New("pkcs12: refusing to encrypt with an empty salt")
+		}
+		if iterations < 1 {
+			return nil, nil, fmt.Errorf("pkcs12: refusing to encrypt with an iteration count of %d", iterations)
+		}
+		return pbes2Encrypt(name, message, salt, password, iterations, random, keys)
+	}
+
+	encrypted, err := pbEncrypt(name, message, salt, password, iterations, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a := AsnSequence()
+	a.append(asnObjectIdentifier(oid))
+	params := a.append(AsnSequence())
+	params.append(AsnOctetString(salt))
+	params.append(AsnInteger(iterations))
+	return a, encrypted, nil
+}
+
+func (b *ContentInfoBuilder) build(password []byte) (*AsnItem, error) {
+	safeContents := AsnSequence()
+	for _, bag := range b.bags {
+		item, err := bag(password)
+		if err != nil {
+			return nil, err
+		}
+		safeContents.append(item)
+	}
+
+	ci := AsnSequence()
+	if b.algorithm == "" {
+		ci.append(AsnOID(oid_pkcs7_data))
+		ci.append(AsnCC(0)).append(AsnOctetStringContainer()).append(safeContents)
+		return ci, nil
+	}
+
+	plain := make([]byte, safeContents.size())
+	if safeContents.write(plain) < 0 {
+		return nil, errors.New("pkcs12: SafeContents is too large to encode")
+	}
+
+	algorithmItem, encrypted, err := encryptWith(b.algorithm, b.salt, b.iterations, plain, password, b.rand, b.keys)
+	for i := range plain {
+		plain[i] = 0
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ci.append(AsnOID(oid_pkcs7_encrypted))
+	ed := ci.append(AsnCC(0)).append(AsnSequence())
+	ed.append(AsnInteger(0))
+	eci := ed.append(AsnSequence())
+	eci.append(AsnOID(oid_pkcs7_data))
+	eci.append(algorithmItem)
+	eci.append(AsnCCRaw(0, encrypted))
+	return ci, nil
+}
+
+// PFXBuilder assembles a PFX from ContentInfos built with ContentInfoBuilder
+// and protects it with a MAC, SHA-1 unless set otherwise.
+type PFXBuilder struct {
+	macAlgorithm  MacAlgorithm
+	pbmac1Hash    MacAlgorithm
+	macSalt       []byte
+	macIterations int
+	contentInfos  []*ContentInfoBuilder
+
+	// keys holds the keys derived by earlier builds, if set
+	keys *derivedKeys
+}
+
+// NewPFXBuilder returns a builder for a PFX whose MAC is derived with
+// macSalt and macIterations. If macSalt is nil, a random salt is generated
+// when the PFX is built.
+func NewPFXBuilder(macSalt []byte, macIterations int) *PFXBuilder {
+	return &PFXBuilder{macAlgorithm: SHA1, pbmac1Hash: SHA256, macSalt: macSalt, macIterations: macIterations}
+}
+
+// SetMacAlgorithm sets the digest algorithm the MAC is computed with.
+func (b *PFXBuilder) SetMacAlgorithm(algorithm MacAlgorithm) {
+	b.macAlgorithm = algorithm
+}
+
+// SetPBMAC1Hash sets the hash of the HMAC used both to derive the key and to
+// compute the MAC when the MAC algorithm is PBMAC1. It defaults to SHA256.
+func (b *PFXBuilder) SetPBMAC1Hash(hash MacAlgorithm) {
+	b.pbmac1Hash = hash
+}
+
+// Add appends a ContentInfo to the authenticated safe.
+func (b *PFXBuilder) Add(contentInfo *ContentInfoBuilder) {
+	b.contentInfos = append(b.contentInfos, contentInfo)
+}
+
+// Build encrypts the contents with utf8Password and returns the DER encoded
+// PFX, with a MAC computed with the same password.
+func (b *PFXBuilder) Build(utf8Password []byte) ([]byte, error) {
+	password, err := bmpString(utf8Password)
+	defer func() { // clear out BMP version of the password before we return
+		for i := 0; i < len(password); i++ {
+			password[i] = 0
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return b.build(password)
+}
+
+// build is Build with the BMP password.
+func (b *PFXBuilder) build(password []byte) ([]byte, error) {
+	if b.macIterations < 1 {
+		return nil, fmt.Errorf("pkcs12: refusing to compute the MAC with an iteration count of %d", b.macIterations)
+	}
+	if _, ok := hashIDByName[string(b.macAlgorithm)]; !ok && b.macAlgorithm != PBMAC1 {
+		return nil, NotImplementedError("MAC algorithm " + string(b.macAlgorithm) + " is not supported")
+	}
+
+	authSafe := AsnSequence()
+	for _, contentInfo := range b.contentInfos {
+		ci, err := contentInfo.build(password)
+		if err != nil {
+			return nil, err
+		}
+		authSafe.append(ci)
+	}
+
+	authSafeData := make([]byte, authSafe.size())
+	if authSafe.write(authSafeData) < 0 {
+		return nil, errors.New("pkcs12: authenticated safe is too large to encode")
+	}
+
+	var err error
+	macSalt := b.macSalt
+	if macSalt == nil {
+		if macSalt, err = getRandomBytes(defaultSaltLength); err != nil {
+			return nil, err
+		}
+	}
+	var macAlgorithm *AsnItem
+	var mac []byte
+	if b.macAlgorithm == PBMAC1 {
+		macAlgorithm, mac, err = generatePBMAC1(string(b.pbmac1Hash), authSafeData, macSalt, password, b.macIterations, b.keys)
+	} else {
+		macAlgorithm = AsnSequence()
+		macAlgorithm.append(asnObjectIdentifier(hashIDByName[string(b.macAlgorithm)]))
+		macAlgorithm.append(AsnNull())
+		mac, err = generateMac(string(b.macAlgorithm), authSafeData, macSalt, password, b.macIterations, b.keys)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p12 := AsnSequence()
+	p12.append(AsnInteger(3))
+	a := p12.append(AsnSequence())
+	a.append(AsnOID(oid_pkcs7_data))
+	a = a.append(AsnCC(0))
+	a.append(AsnOctetString(authSafeData))
+
+	a = p12.append(AsnSequence())
+	d := a.append(AsnSequence())
+	d.append(macAlgorithm)
+	d.append(AsnOctetString(mac))
+	if b.macAlgorithm == PBMAC1 {
+		// the salt and iterations are carried in the PBKDF2 parameters
+		a.append(AsnOctetString(pbmac1NotUsed))
+		a.append(AsnInteger(1))
+	} else {
+		a.append(AsnOctetString(macSalt))
+		a.append(AsnInteger(b.macIterations))
+	}
+
+	data := make([]byte, p12.size())
+	if p12.write(data) < 0 {
+		return nil, errors.New("pkcs12: PFX is too large to encode")
+	}
+	return data, nil
+}
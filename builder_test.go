@@ -0,0 +1,135 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestPFXBuilder(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := NewFriendlyNameAttribute("builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(cert.Raw, name, NewLocalKeyIDAttribute([]byte{1}))
+	keys := NewEncryptedContentInfoBuilder(PBEWithSHAAnd40BitRC2CBC, nil, 1000)
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, name, NewLocalKeyIDAttribute([]byte{1}))
+
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("builder"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := ConvertToPEM(p12, []byte("builder"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 PEM blocks, but found %d", len(blocks))
+	}
+	for _, b := range blocks {
+		if b.Headers["friendlyName"] != "builder" {
+			t.Errorf("expected friendlyName 'builder' on %s, but found '%s'", b.Type, b.Headers["friendlyName"])
+		}
+		if b.Headers["localKeyId"] != "01" {
+			t.Errorf("expected localKeyId '01' on %s, but found '%s'", b.Type, b.Headers["localKeyId"])
+		}
+	}
+
+	if _, c, err := Decode(p12, []byte("builder")); err != nil {
+		t.Error(err)
+	} else if c.Subject.CommonName != cert.Subject.CommonName {
+		t.Errorf("unexpected common name '%s'", c.Subject.CommonName)
+	}
+}
+
+func TestAsnInteger(t *testing.T) {
+	for _, i := range []int{0, 1, 127, 128, 200, 254, 255, 256, 32767, 32768, 50000, 65534, 65535, 65536, 100000, 1 << 24, 1<<31 - 1} {
+		item := AsnInteger(i)
+		der := make([]byte, item.size())
+		item.write(der)
+		if expected, _ := asn1.Marshal(i); !bytes.Equal(der, expected) {
+			t.Errorf("%d: expected % x, found % x", i, expected, der)
+		}
+	}
+}
+
+func TestPFXBuilderIterationCounts(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// counts whose encoding takes a leading zero byte, and one above 65535
+	for _, iterations := range []int{200, 50000, 100000} {
+		certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, iterations)
+		certs.AddCertificate(cert.Raw)
+		keys := NewContentInfoBuilder()
+		keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, iterations)
+		pfx := NewPFXBuilder(nil, iterations)
+		pfx.Add(certs)
+		pfx.Add(keys)
+		p12, err := pfx.Build([]byte("builder"))
+		if err != nil {
+			t.Fatalf("%d iterations: %v", iterations, err)
+		}
+		if _, _, err = Decode(p12, []byte("builder")); err != nil {
+			t.Errorf("%d iterations: %v", iterations, err)
+		}
+	}
+
+	if _, err = NewPFXBuilder(nil, 0).Build([]byte("builder")); err == nil {
+		t.Error("expected a MAC iteration count of 0 to be refused")
+	}
+}
+
+func TestPFXBuilderSafeContentsTooLarge(t *testing.T) {
+	_, cert := testIdentity(t)
+
+	// a trust bundle whose SafeContents exceeds the 64 KiB an AsnItem can
+	// encode
+	certs := NewEncryptedContentInfoBuilder(PBES2AES256CBC, nil, 1000)
+	for n := 0; n <= 65536/len(cert.Raw); n++ {
+		certs.AddCertificate(cert.Raw)
+	}
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	if _, err := pfx.Build([]byte("builder")); err == nil || err.Error() != "pkcs12: SafeContents is too large to encode" {
+		t.Errorf("expected the SafeContents to be too large, got err: %v", err)
+	}
+}
+
+func TestEncodeUnsupportedMacAlgorithm(t *testing.T) {
+	key, cert := testIdentity(t)
+	if _, err := Encode(key, cert, nil, "builder", WithMacAlgorithm("MD5")); err == nil {
+		t.Errorf("expected an MD5 MAC to be refused")
+	} else if _, ok := err.(NotImplementedError); !ok {
+		t.Errorf("expected not implemented error, got: %T %s", err, err)
+	}
+}
+
+func TestPFXBuilderUnsupportedAlgorithm(t *testing.T) {
+	_, cert := testIdentity(t)
+
+	certs := NewEncryptedContentInfoBuilder(pbeWithSHAAnd128BitRC4, nil, 1000)
+	certs.AddCertificate(cert.Raw)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	if _, err := pfx.Build([]byte("builder")); err == nil {
+		t.Errorf("expected encrypting with RC4 to be refused")
+	} else if _, ok := err.(NotImplementedError); !ok {
+		t.Errorf("expected not implemented error, got: %T %s", err, err)
+	}
+}
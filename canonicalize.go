@@ -0,0 +1,116 @@
+package pkcs12
+
+import "crypto/sha1"
+
+// Canonicalize decodes pfxData with password and encodes its contents again
+// in the layout and with the algorithms of OpenSSL 3: the certificates and
+// keys encrypted with PBES2 and AES-256-CBC, and a SHA-256 MAC, unless opts
+// set otherwise. See Encoder.Canonicalize.
+func Canonicalize(pfxData []byte, password string, opts ...EncodeOption) ([]byte, error) {
+	defaults := []EncodeOption{WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES256CBC), WithMacAlgorithm(SHA256)}
+	return NewEncoder(append(defaults, opts...)...).Canonicalize(pfxData, password)
+}
+
+// Canonicalize decodes the entries of pfxData with password and encodes them
+// again with the algorithms of enc, in the layout of Encode: all certificates
+// in one encryptedData ContentInfo, followed by the shrouded private keys in
+// a data ContentInfo, however many ContentInfos pfxData had. Each private key
+// and its certificate carry a localKeyId of the SHA-1 hash of the
+// certificate. Friendly names, key provider names and trust anchor marks are
+// kept; all other bag attributes, including those this package does not
+// know, are dropped, as are bags other than private keys and X.509
+// certificates, such as CRLs and secrets. The legacy DSA keys that decoding
+// supports are kept.
+func (enc *Encoder) Canonicalize(pfxData []byte, password string) ([]byte, error) {
+	entries, err := DecodeAll(pfxData, password)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := enc.newEncryptedContentInfoBuilder(enc.certAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	keys := enc.newContentInfoBuilder()
+	var secrets [][]byte
+	defer func() { // clear out the unencrypted private keys before we return
+		wipe(nil, secrets)
+	}()
+
+	var caCerts []Entry
+	for _, e := range entries {
+		if e.PrivateKey == nil {
+			caCerts = append(caCerts, e)
+			continue
+		}
+
+		var attributes []Attribute
+		switch {
+		case e.Certificate != nil:
+			keyID := sha1.Sum(e.Certificate.Raw)
+			attributes = append(attributes, NewLocalKeyIDAttribute(keyID[:]))
+		case len(e.LocalKeyID) > 0:
+			attributes = append(attributes, NewLocalKeyIDAttribute(e.LocalKeyID))
+		}
+		if e.Certificate != nil {
+			certAttributes := append([]Attribute(nil), attributes...)
+			if e.CertFriendlyName != "" {
+				name, err := NewFriendlyNameAttribute(e.CertFriendlyName)
+				if err != nil {
+					return nil, err
+				}
+				certAttributes = append(certAttributes, name)
+			}
+			if e.TrustAnchor {
+				certAttributes = append(certAttributes, NewTrustedKeyUsageAttribute(e.TrustedKeyUsage...))
+			}
+			certs.AddCertificate(e.Certificate.Raw, certAttributes...)
+		}
+		if e.FriendlyName != "" {
+			name, err := NewFriendlyNameAttribute(e.FriendlyName)
+			if err != nil {
+				return nil, err
+			}
+			attributes = append(attributes, name)
+		}
+
+		if e.KeyProviderName != "" {
+			provider, err := NewKeyProviderNameAttribute(e.KeyProviderName)
+			if err != nil {
+				return nil, err
+			}
+			attributes = append(attributes, provider)
+		}
+		pkcs8, err := marshalPKCS8PrivateKey(e.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, pkcs8)
+		if err = enc.addShroudedKey(keys, pkcs8, attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range caCerts {
+		var attributes []Attribute
+		if e.CertFriendlyName != "" {
+			name, err := NewFriendlyNameAttribute(e.CertFriendlyName)
+			if err != nil {
+				return nil, err
+			}
+			attributes = append(attributes, name)
+		}
+		if e.TrustAnchor {
+			attributes = append(attributes, NewTrustedKeyUsageAttribute(e.TrustedKeyUsage...))
+		}
+		certs.AddCertificate(e.Certificate.Raw, attributes...)
+	}
+
+	pfx, err := enc.newPFXBuilder()
+	if err != nil {
+		return nil, err
+	}
+	pfx.Add(certs)
+	pfx.Add(keys)
+	return pfx.Build([]byte(password))
+}
@@ -0,0 +1,164 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	leaf := newTestCert(t, "leaf", root)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key comes first, in an encrypted ContentInfo, and is paired by an
+	// arbitrary localKeyId
+	id := NewLocalKeyIDAttribute([]byte("some id"))
+	name, err := NewFriendlyNameAttribute("identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certName, err := NewFriendlyNameAttribute("leaf certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, id, name)
+	certs := NewContentInfoBuilder()
+	certs.AddCertificate(root.cert.Raw, NewTrustedKeyUsageAttribute())
+	serverAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	certs.AddCertificate(leaf.cert.Raw, id, certName, NewTrustedKeyUsageAttribute(serverAuth))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(keys)
+	pfx.Add(certs)
+	p12, err := pfx.Build([]byte("canonical"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canonical, err := Canonicalize(p12, "canonical")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pdu pfxPdu
+	if _, err = asn1.Unmarshal(canonical, &pdu); err != nil {
+		t.Fatal(err)
+	}
+	if !pdu.MacData.Mac.Algorithm.Algorithm.Equal(oidSha256Algorithm) {
+		t.Errorf("expected a SHA-256 MAC, found %v", pdu.MacData.Mac.Algorithm.Algorithm)
+	}
+	authSafe, err := AuthenticatedSafeBytes(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contentInfos []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &contentInfos); err != nil {
+		t.Fatal(err)
+	}
+	if len(contentInfos) != 2 || !contentInfos[0].ContentType.Equal(oidEncryptedDataContentType) || !contentInfos[1].ContentType.Equal(oidDataContentType) {
+		t.Fatal("expected the certificates to be encrypted, followed by the keys")
+	}
+	parts, err := EncryptedParts(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("expected the certificates and the key to be encrypted, found %d encrypted parts", len(parts))
+	}
+	for _, part := range parts {
+		if !part.Algorithm.Algorithm.Equal(oidPBES2) {
+			t.Errorf("expected PBES2 by default, found %s", part.Algorithm.Algorithm)
+		}
+	}
+
+	entries, err := DecodeAll(canonical, "canonical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, found %d", len(entries))
+	}
+	keyID := sha1.Sum(leaf.cert.Raw)
+	if e := entries[0]; e.PrivateKey == nil || !e.Certificate.Equal(leaf.cert) || e.FriendlyName != "identity" || e.CertFriendlyName != "leaf certificate" || !bytes.Equal(e.LocalKeyID, keyID[:]) {
+		t.Errorf("expected the leaf identity with its names and a localKeyId of its hash, found %+v", e)
+	} else if !e.TrustAnchor || len(e.TrustedKeyUsage) != 1 || !e.TrustedKeyUsage[0].Equal(serverAuth) {
+		t.Errorf("expected the leaf to remain a trust anchor for serverAuth, found %v", e.TrustedKeyUsage)
+	}
+	if e := entries[1]; !e.Certificate.Equal(root.cert) || !e.TrustAnchor {
+		t.Errorf("expected the root to remain a trust anchor")
+	}
+}
+
+func TestCanonicalizeLayouts(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCert(t, "other", nil)
+
+	// the certificates split between two ContentInfos, and the key in a third
+	encrypted := NewEncryptedContentInfoBuilder(PBEWithSHAAnd40BitRC2CBC, nil, 1000)
+	encrypted.AddCertificate(cert.Raw, NewLocalKeyIDAttribute([]byte{1}))
+	plain := NewContentInfoBuilder()
+	plain.AddCertificate(other.cert.Raw)
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, NewLocalKeyIDAttribute([]byte{1}))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(keys)
+	pfx.Add(encrypted)
+	pfx.Add(plain)
+	split, err := pfx.Build([]byte("canonical"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsaP12, _ := base64.StdEncoding.DecodeString(dsaTestData)
+
+	for name, test := range map[string]struct {
+		data     []byte
+		password string
+		entries  int
+	}{
+		"3 ContentInfos": {split, "canonical", 2},
+		"DSA":            {dsaP12, "dsa", 1},
+	} {
+		canonical, err := Canonicalize(test.data, test.password)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		authSafe, err := AuthenticatedSafeBytes(canonical)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contentInfos []contentInfo
+		if _, err = asn1.Unmarshal(authSafe, &contentInfos); err != nil {
+			t.Fatal(err)
+		}
+		if len(contentInfos) != 2 {
+			t.Errorf("%s: expected 2 ContentInfos, found %d", name, len(contentInfos))
+		}
+
+		before, err := DecodeAll(test.data, test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := DecodeAll(canonical, test.password)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(after) != test.entries || len(before) != test.entries {
+			t.Fatalf("%s: expected %d entries, found %d and %d", name, test.entries, len(before), len(after))
+		}
+		if !reflect.DeepEqual(after[0].PrivateKey, before[0].PrivateKey) || !after[0].Certificate.Equal(before[0].Certificate) {
+			t.Errorf("%s: expected the identity to be kept", name)
+		}
+	}
+}
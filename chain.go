@@ -0,0 +1,100 @@
+package pkcs12
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// DecodeChain extracts a private key, its certificate, and the remaining CA
+// certificates from pfxData. This function assumes that there is only one
+// private key in pfxData. The certificate is the one holding the public key
+// of the private key, wherever it is stored, and an error is returned if
+// there is none. caCerts are returned in the order they appear, which Encode
+// keeps, so that a chain round trips in its order; OrderChain sorts them.
+// PFX data holding a single certificate and no private key, as produced by
+// EncodeCertificate, is returned as that certificate with a nil private key.
+func DecodeChain(pfxData []byte, password string) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.DecodeChain(pfxData)
+}
+
+// DecodeChain is like the package-level DecodeChain, but obtains the password
+// from opts.Password.
+func (opts *DecodeOptions) DecodeChain(pfxData []byte) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	entries, err := opts.DecodeAll(pfxData)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	privateKey, certificate, caCerts, err = chainFromEntries(entries)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err = opts.checkValidity(certificate); err != nil {
+		return nil, nil, nil, err
+	}
+	return privateKey, certificate, caCerts, nil
+}
+
+func chainFromEntries(entries []Entry) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	for _, e := range entries {
+		if e.PrivateKey == nil {
+			caCerts = append(caCerts, e.Certificate)
+			continue
+		}
+		if privateKey != nil {
+			return nil, nil, nil, errors.New("pkcs12: expected exactly one private key, use DecodeAll instead")
+		}
+		privateKey = e.PrivateKey
+		certificate = e.Certificate
+	}
+
+	if privateKey == nil {
+		if len(caCerts) == 1 {
+			return nil, caCerts[0], nil, nil
+		}
+		return nil, nil, nil, errors.New("private key missing")
+	}
+	if certificate == nil {
+		// DecodeAll pairs the key with the certificate of its public key
+		// wherever it is stored, so none holds it, unless the key cannot be
+		// compared, in which case assume the first certificate is the one
+		// that goes with it
+		if _, ok := comparablePublicKey(privateKey); ok {
+			return nil, nil, nil, errors.New("pkcs12: no certificate holds the public key of the private key")
+		}
+		if len(caCerts) == 0 {
+			return nil, nil, nil, errors.New("certificate missing")
+		}
+		certificate, caCerts = caCerts[0], caCerts[1:]
+	}
+	return privateKey, certificate, caCerts, nil
+}
+
+// OrderChain returns the issuers of leaf found in caCerts, from the closest
+// to the farthest, followed by the rest of caCerts in their order, for
+// servers that present certificates in the order they are stored. Nothing
+// else reorders caCerts.
+func OrderChain(leaf *x509.Certificate, caCerts []*x509.Certificate) []*x509.Certificate {
+	return orderChain(leaf, caCerts)[1:]
+}
+
+// VerifyChain decodes the certificate of the private key in pfxData and
+// verifies it against roots, using the other certificates in pfxData as
+// intermediates. Any extended key usage is accepted. VerifyChain returns the
+// verified chains, or the verification error.
+func VerifyChain(pfxData []byte, password string, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	_, certificate, caCerts, err := DecodeChain(pfxData, password)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range caCerts {
+		intermediates.AddCert(c)
+	}
+	return certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+}
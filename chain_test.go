@@ -0,0 +1,204 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	key  crypto.Signer
+	cert *x509.Certificate
+}
+
+// newTestCert returns an ECDSA certificate for name, issued by issuer or
+// self-signed if issuer is nil.
+func newTestCert(t *testing.T, name string, issuer *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  name != "leaf",
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	parent, signer := template, crypto.Signer(key)
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{key: key, cert: cert}
+}
+
+// buildChainPFX returns a PFX holding the key of leaf and the certificates
+// of leaf and caCerts, in that order.
+func buildChainPFX(t *testing.T, password string, leaf *testCert, caCerts ...*testCert) []byte {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := NewLocalKeyIDAttribute([]byte("leaf"))
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(leaf.cert.Raw, id)
+	for _, c := range caCerts {
+		certs.AddCertificate(c.cert.Raw)
+	}
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, id)
+
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p12
+}
+
+func TestDecodeChain(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+
+	p12 := buildChainPFX(t, "chain", leaf, intermediate, root)
+	key, cert, caCerts, err := DecodeChain(p12, "chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.(*ecdsa.PrivateKey).Equal(leaf.key) {
+		t.Errorf("unexpected private key")
+	}
+	if !cert.Equal(leaf.cert) {
+		t.Errorf("expected leaf certificate, but found '%s'", cert.Subject.CommonName)
+	}
+	if len(caCerts) != 2 || !caCerts[0].Equal(intermediate.cert) || !caCerts[1].Equal(root.cert) {
+		t.Errorf("expected intermediate and root CA certificates, in that order")
+	}
+}
+
+func TestChainOrderRoundTrip(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	other := newTestCert(t, "other", nil)
+
+	// stored out of chain order, which must survive as it is
+	p12 := buildChainPFX(t, "order", leaf, root, other, intermediate)
+	key, cert, caCerts, err := DecodeChain(p12, "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p12, err = Encode(key, cert, caCerts, "order"); err != nil {
+		t.Fatal(err)
+	}
+	if p12, err = ReEncrypt(p12, "order", WithCertAlgorithm(PBES2AES256CBC)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, caCerts, err = DecodeChain(p12, "order"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*x509.Certificate{root.cert, other.cert, intermediate.cert}
+	if len(caCerts) != len(expected) {
+		t.Fatalf("expected %d CA certificates, found %d", len(expected), len(caCerts))
+	}
+	for i, c := range caCerts {
+		if !c.Equal(expected[i]) {
+			t.Errorf("expected CA certificate %d to be %q, found %q", i, expected[i].Subject.CommonName, c.Subject.CommonName)
+		}
+	}
+
+	ordered := OrderChain(cert, caCerts)
+	expected = []*x509.Certificate{intermediate.cert, root.cert, other.cert}
+	for i, c := range ordered {
+		if !c.Equal(expected[i]) {
+			t.Errorf("expected ordered certificate %d to be %q, found %q", i, expected[i].Subject.CommonName, c.Subject.CommonName)
+		}
+	}
+	if !caCerts[0].Equal(root.cert) {
+		t.Error("expected OrderChain to leave its argument as it was")
+	}
+}
+
+func TestDecodeChainLeafByPublicKey(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no localKeyIds, with the leaf stored after its issuers or not at all
+	build := func(certs ...*testCert) []byte {
+		certBags := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+		for _, c := range certs {
+			certBags.AddCertificate(c.cert.Raw)
+		}
+		keys := NewContentInfoBuilder()
+		keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+		pfx := NewPFXBuilder(nil, 1000)
+		pfx.Add(certBags)
+		pfx.Add(keys)
+		p12, err := pfx.Build([]byte("order"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p12
+	}
+
+	_, cert, caCerts, err := DecodeChain(build(root, intermediate, leaf), "order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(leaf.cert) {
+		t.Errorf("expected leaf certificate, but found '%s'", cert.Subject.CommonName)
+	}
+	if len(caCerts) != 2 || !caCerts[0].Equal(root.cert) || !caCerts[1].Equal(intermediate.cert) {
+		t.Errorf("expected root and intermediate CA certificates, in that order")
+	}
+
+	if _, cert, _, err = DecodeChain(build(root, intermediate), "order"); err == nil {
+		t.Errorf("expected an error when no certificate holds the key, found '%s'", cert.Subject.CommonName)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	p12 := buildChainPFX(t, "chain", leaf, intermediate)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	chains, err := VerifyChain(p12, "chain", roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 1 || len(chains[0]) != 3 {
+		t.Fatalf("expected a single chain of 3 certificates, but found %v", chains)
+	}
+
+	if _, err = VerifyChain(p12, "chain", x509.NewCertPool()); err == nil {
+		t.Errorf("expected verification against an empty pool to fail")
+	}
+}
@@ -6,52 +6,143 @@ import (
 	"bytes"
 	"crypto/cipher"
 	"crypto/des"
+	"crypto/rc4"
+	"crypto/subtle"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"errors"
+	"fmt"
 
 	"github.com/binlab/azure-go-pkcs12/internal/rc2"
 )
 
 const (
+	pbeWithSHAAnd128BitRC4        = "pbeWithSHAAnd128BitRC4"
+	pbeWithSHAAnd40BitRC4         = "pbeWithSHAAnd40BitRC4"
 	pbeWithSHAAnd3KeyTripleDESCBC = "pbeWithSHAAnd3-KeyTripleDES-CBC"
 	pbewithSHAAnd40BitRC2CBC      = "pbewithSHAAnd40BitRC2-CBC"
 )
 
 var (
+	oidPbeWithSHAAnd128BitRC4        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 1}
+	oidPbeWithSHAAnd40BitRC4         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 2}
 	oidPbeWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
 	oidPbewithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
 )
 
 var algByOID = map[string]string{
+	oidPbeWithSHAAnd128BitRC4.String():        pbeWithSHAAnd128BitRC4,
+	oidPbeWithSHAAnd40BitRC4.String():         pbeWithSHAAnd40BitRC4,
 	oidPbeWithSHAAnd3KeyTripleDESCBC.String(): pbeWithSHAAnd3KeyTripleDESCBC,
 	oidPbewithSHAAnd40BitRC2CBC.String():      pbewithSHAAnd40BitRC2CBC,
 }
 
+var oidByAlg = map[string]asn1.ObjectIdentifier{
+	pbeWithSHAAnd128BitRC4:        oidPbeWithSHAAnd128BitRC4,
+	pbeWithSHAAnd40BitRC4:         oidPbeWithSHAAnd40BitRC4,
+	pbeWithSHAAnd3KeyTripleDESCBC: oidPbeWithSHAAnd3KeyTripleDESCBC,
+	pbewithSHAAnd40BitRC2CBC:      oidPbewithSHAAnd40BitRC2CBC,
+}
+
 var blockcodeByAlg = map[string]func(key []byte) (cipher.Block, error){
-	pbeWithSHAAnd3KeyTripleDESCBC: des.NewTripleDESCipher,
+	pbeWithSHAAnd3KeyTripleDESCBC: func(key []byte) (cipher.Block, error) {
+		// three independent DES keys, which the standard library would
+		// otherwise refuse with only "crypto/des: invalid key size"
+		if err := checkKeyLength(pbeWithSHAAnd3KeyTripleDESCBC, key, 3*des.BlockSize); err != nil {
+			return nil, err
+		}
+		return des.NewTripleDESCipher(key)
+	},
 	pbewithSHAAnd40BitRC2CBC: func(key []byte) (cipher.Block, error) {
 		return rc2.New(key, len(key)*8)
 	},
 }
 
+// streamcodeByAlg holds the algorithms that use a stream cipher, which have
+// no IV and no padding.
+var streamcodeByAlg = map[string]func(key []byte) (cipher.Stream, error){
+	pbeWithSHAAnd128BitRC4: func(key []byte) (cipher.Stream, error) {
+		return rc4.NewCipher(key)
+	},
+	pbeWithSHAAnd40BitRC4: func(key []byte) (cipher.Stream, error) {
+		return rc4.NewCipher(key)
+	},
+}
+
+// checkKeyLength returns an error naming algorithm if key is not length bytes
+// long, as the derivation of its key should have made it.
+func checkKeyLength(algorithm string, key []byte, length int) error {
+	if len(key) != length {
+		return fmt.Errorf("pkcs12: algorithm %s needs a key of %d bytes, but one of %d bytes was derived", algorithm, length, len(key))
+	}
+	return nil
+}
+
 type pbeParams struct {
 	Salt       []byte
 	Iterations int
 }
 
-func pbDecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.BlockMode, error) {
+func pbeParamsFor(algorithm pkix.AlgorithmIdentifier) (algorithmName string, params pbeParams, err error) {
 	algorithmName, supported := algByOID[algorithm.Algorithm.String()]
 	if !supported {
-		return nil, NotImplementedError("algorithm " + algorithm.Algorithm.String() + " is not supported")
+		return "", params, NotImplementedError("algorithm " + algorithm.Algorithm.String() + " is not supported")
+	}
+
+	if !hasParameters(algorithm) {
+		return "", params, errors.New("pkcs12: algorithm " + algorithmName + " is missing its salt and iteration count")
+	}
+	if _, err = asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return "", params, err
+	}
+	if len(params.Salt) == 0 {
+		return "", params, errors.New("pkcs12: algorithm " + algorithmName + " has an empty salt")
 	}
+	return algorithmName, params, nil
+}
+
+// iterationCount returns the iteration count to derive the key of
+// algorithmName with. A count below 1 leaves the key derivation degenerate,
+// so it is refused, unless lenient is set, in which case it is taken as 1, as
+// a few tools that write it mean.
+func iterationCount(algorithmName string, iterations int, lenient bool) (int, error) {
+	if iterations >= 1 {
+		return iterations, nil
+	}
+	if lenient {
+		return 1, nil
+	}
+	return 0, fmt.Errorf("pkcs12: algorithm %s has an iteration count of %d", algorithmName, iterations)
+}
 
-	var params pbeParams
-	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+// hasParameters reports whether algorithm carries parameters, as opposed to
+// leaving them out or encoding them as NULL. Both forms are found for
+// algorithms without parameters, such as the PBKDF2 pseudorandom functions.
+func hasParameters(algorithm pkix.AlgorithmIdentifier) bool {
+	return len(algorithm.Parameters.FullBytes) > 0 && !bytes.Equal(algorithm.Parameters.FullBytes, asn1.NullBytes)
+}
+
+// pbDecrypterFor returns the decrypter of algorithm for password. An
+// iteration count below 1 is refused, unless lenient is set.
+func pbDecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte, lenient bool) (cipher.BlockMode, error) {
+	if algorithm.Algorithm.Equal(oidPBES2) {
+		return pbes2DecrypterFor(algorithm, password, lenient)
+	}
+
+	algorithmName, params, err := pbeParamsFor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if _, isStream := streamcodeByAlg[algorithmName]; isStream {
+		return nil, errors.New("pkcs12: algorithm " + algorithmName + " is not a block cipher")
+	}
+	iterations, err := iterationCount(algorithmName, params.Iterations, lenient)
+	if err != nil {
 		return nil, err
 	}
 
-	k := deriveKeyByAlg[algorithmName](params.Salt, password, params.Iterations)
-	iv := deriveIVByAlg[algorithmName](params.Salt, password, params.Iterations)
+	k := deriveKeyByAlg[algorithmName](params.Salt, password, iterations)
+	iv := deriveIVByAlg[algorithmName](params.Salt, password, iterations)
 	password = nil
 
 	code, err := blockcodeByAlg[algorithmName](k)
@@ -63,9 +154,31 @@ func pbDecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher
 	return cbc, nil
 }
 
-func pbEncrypterFor(name string, password, salt []byte, iterations int) (cipher.BlockMode, error) {
-	k := deriveKeyByAlg[name](salt, password, iterations)
-	iv := deriveIVByAlg[name](salt, password, iterations)
+func pbStreamDecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte, lenient bool) (cipher.Stream, error) {
+	algorithmName, params, err := pbeParamsFor(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := iterationCount(algorithmName, params.Iterations, lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	k := deriveKeyByAlg[algorithmName](params.Salt, password, iterations)
+	password = nil
+
+	return streamcodeByAlg[algorithmName](k)
+}
+
+// pbEncrypterFor returns the encrypter of the named algorithm for password,
+// taking the key and IV from keys if they were derived before.
+func pbEncrypterFor(name string, password, salt []byte, iterations int, keys *derivedKeys) (cipher.BlockMode, error) {
+	k := keys.derive(name+" key", salt, password, iterations, func() []byte {
+		return deriveKeyByAlg[name](salt, password, iterations)
+	})
+	iv := keys.derive(name+" IV", salt, password, iterations, func() []byte {
+		return deriveIVByAlg[name](salt, password, iterations)
+	})
 	password = nil
 
 	code, err := blockcodeByAlg[name](k)
@@ -78,39 +191,129 @@ func pbEncrypterFor(name string, password, salt []byte, iterations int) (cipher.
 }
 
 func pbDecrypt(info decryptable, password []byte) (decrypted []byte, err error) {
-	cbc, err := pbDecrypterFor(info.GetAlgorithm(), password)
+	return pbDecryptPadding(info, password, false)
+}
+
+// pbDecryptPadding is pbDecrypt, but if lenient is set, takes an iteration
+// count below 1 as 1, and if the padding does not validate, falls back to
+// treating the plaintext as unpadded when it is a single complete ASN.1
+// value, as written by encoders that leave out the block of padding for
+// block-aligned plaintext.
+func pbDecryptPadding(info decryptable, password []byte, lenient bool) (decrypted []byte, err error) {
+	if _, isStream := streamcodeByAlg[algByOID[info.GetAlgorithm().Algorithm.String()]]; isStream {
+		return pbStreamDecrypt(info, password, lenient)
+	}
+
+	cbc, err := pbDecrypterFor(info.GetAlgorithm(), password, lenient)
 	password = nil
 	if err != nil {
 		return nil, err
 	}
 
 	encrypted := info.GetData()
+	if len(encrypted) == 0 || len(encrypted)%cbc.BlockSize() != 0 {
+		return nil, ErrDecryption
+	}
 
 	decrypted = make([]byte, len(encrypted))
 	cbc.CryptBlocks(decrypted, encrypted)
 
-	if psLen := int(decrypted[len(decrypted)-1]); psLen > 0 && psLen <= cbc.BlockSize() {
-		m := decrypted[:len(decrypted)-psLen]
-		ps := decrypted[len(decrypted)-psLen:]
-		if bytes.Compare(ps, bytes.Repeat([]byte{byte(psLen)}, psLen)) != 0 {
-			return nil, ErrDecryption
+	unpadded, err := unpad(decrypted, cbc.BlockSize())
+	if err != nil {
+		if lenient && isASN1Value(decrypted) {
+			return decrypted, nil
 		}
-		decrypted = m
-	} else {
+		return nil, err
+	}
+	return unpadded, nil
+}
+
+// isASN1Value reports whether data is exactly one DER or BER value.
+func isASN1Value(data []byte) bool {
+	var value asn1.RawValue
+	rest, err := asn1.Unmarshal(data, &value)
+	return err == nil && len(rest) == 0
+}
+
+// unpad strips the PKCS#7 padding from decrypted, whose length is a non-zero
+// multiple of blockSize. The padding is checked in constant time, so that
+// timing does not reveal how it is wrong.
+func unpad(decrypted []byte, blockSize int) ([]byte, error) {
+	n := len(decrypted)
+	psLen := int(decrypted[n-1])
+	good := subtle.ConstantTimeLessOrEq(1, psLen) & subtle.ConstantTimeLessOrEq(psLen, blockSize)
+	for i := 1; i <= blockSize; i++ {
+		// each of the last psLen bytes must equal psLen, the others are ignored
+		inPadding := subtle.ConstantTimeLessOrEq(i, psLen)
+		good &= subtle.ConstantTimeByteEq(decrypted[n-i], byte(psLen)) | (inPadding ^ 1)
+	}
+	if good != 1 {
 		return nil, ErrDecryption
 	}
+	return decrypted[:n-psLen], nil
+}
+
+// pbStreamDecrypt decrypts info with a stream cipher. Stream ciphers do not
+// pad their input, so the PKCS#7 padding check of pbDecrypt does not apply.
+func pbStreamDecrypt(info decryptable, password []byte, lenient bool) (decrypted []byte, err error) {
+	stream, err := pbStreamDecrypterFor(info.GetAlgorithm(), password, lenient)
+	password = nil
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := info.GetData()
+
+	decrypted = make([]byte, len(encrypted))
+	stream.XORKeyStream(decrypted, encrypted)
 	return
 }
 
-func pbEncrypt(name string, message, salt, password []byte, iterations int) ([]byte, error) {
+// pbDecrypt is like the package-level pbDecrypt, but refuses algorithms
+// that are not in opts.AllowedAlgorithms, and accepts missing padding and an
+// iteration count below 1 if opts.Lenient is set.
+func (opts *DecodeOptions) pbDecrypt(info decryptable, password []byte) ([]byte, error) {
+	if err := opts.checkAlgorithm(info.GetAlgorithm().Algorithm); err != nil {
+		return nil, err
+	}
+	return pbDecryptPadding(info, password, opts.Lenient)
+}
+
+func (opts *DecodeOptions) checkAlgorithm(algorithm asn1.ObjectIdentifier) error {
+	if opts.AllowedAlgorithms == nil {
+		return nil
+	}
+	for _, allowed := range opts.AllowedAlgorithms {
+		if allowed.Equal(algorithm) {
+			return nil
+		}
+	}
+	name, ok := algByOID[algorithm.String()]
+	if !ok {
+		name = algorithm.String()
+	}
+	return errors.New("pkcs12: algorithm " + name + " is not allowed")
+}
+
+func pbEncrypt(name string, message, salt, password []byte, iterations int, keys *derivedKeys) ([]byte, error) {
 	//name := pbewithSHAAnd40BitRC2CBC
 	//name := pbeWithSHAAnd3KeyTripleDESCBC
-	cbc, err := pbEncrypterFor(name, password, salt, iterations)
+	if len(salt) == 0 {
+		return nil, errors.New("pkcs12: refusing to encrypt with an empty salt")
+	}
+	if iterations < 1 {
+		return nil, fmt.Errorf("pkcs12: refusing to encrypt with an iteration count of %d", iterations)
+	}
+	cbc, err := pbEncrypterFor(name, password, salt, iterations, keys)
 	password = nil
 	if err != nil {
 		return nil, err
 	}
 
+	return cbcEncrypt(cbc, message), nil
+}
+
+func cbcEncrypt(cbc cipher.BlockMode, message []byte) []byte {
 	// There must be at least one padding byte at the end, which may mean
 	// an entire block of padding is added.
 	// Padding bytes are all set to the count of padding bytes.
@@ -126,7 +329,7 @@ func pbEncrypt(name string, message, salt, password []byte, iterations int) ([]b
 	for i := range padded {
 		padded[i] = 0
 	}
-	return encrypted, nil
+	return encrypted
 }
 
 type decryptable interface {
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -21,13 +23,13 @@ func TestPbDecrypterFor(t *testing.T) {
 
 	pass, _ := bmpString([]byte("Sesame open"))
 
-	_, err := pbDecrypterFor(alg, pass)
+	_, err := pbDecrypterFor(alg, pass, false)
 	if _, ok := err.(NotImplementedError); !ok {
 		t.Errorf("expected not implemented error, got: %T %s", err, err)
 	}
 
 	alg.Algorithm = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3})
-	cbc, err := pbDecrypterFor(alg, pass)
+	cbc, err := pbDecrypterFor(alg, pass, false)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -48,12 +50,16 @@ func TestPbDecrypt(t *testing.T) {
 		[]byte("\x33\x73\xf3\x9f\xda\x49\xae\xfc\x96\x24\x2f\x71\x7e\x32\x3f\xe7"), // 8 padding bytes
 		[]byte("\x35\x0c\xc0\x8d\xab\xa9\x5d\x30\x7f\x9a\xec\x6a\xd8\x9b\x9c\xd9"), // 9 padding bytes, incorrect
 		[]byte("\xb2\xf9\x6e\x06\x60\xae\x20\xcf\x08\xa0\x7b\xd9\x6b\x20\xef\x41"), // incorrect padding bytes: [ ... 0x04 0x02 ]
+		[]byte("\x33\x73\xf3\x9f\xda\x49\xae\xfc\xa0\x9a\xdf\x5a"),                 // not a multiple of the block size
+		{}, // empty
 	}
 	expected := []interface{}{
 		[]byte("A secret!"),
 		[]byte("A secret"),
 		ErrDecryption,
 		ErrDecryption,
+		ErrDecryption,
+		ErrDecryption,
 	}
 
 	for i, c := range tests {
@@ -87,6 +93,73 @@ func TestPbDecrypt(t *testing.T) {
 	}
 }
 
+func TestPbDecryptLenient(t *testing.T) {
+	salt := []byte("\xf1\xf2\xf3\xf4\xf5\xf6\xf7\xf8")
+	p, _ := bmpString([]byte("sesame"))
+	encryptUnpadded := func(plaintext []byte) testDecryptable {
+		cbc, err := pbEncrypterFor(pbeWithSHAAnd3KeyTripleDESCBC, p, salt, 4096, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted := make([]byte, len(plaintext))
+		cbc.CryptBlocks(encrypted, plaintext)
+		return testDecryptable{
+			data: encrypted,
+			algorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3}), // SHA1/3TDES
+				Parameters: pbeParams{Salt: salt, Iterations: 4096}.RawASN1(),
+			},
+		}
+	}
+
+	// an OCTET STRING of 14 bytes is exactly two blocks, ending in a byte
+	// that is not valid padding
+	plaintext, _ := asn1.Marshal([]byte("A secret key\x00\x00"))
+	td := encryptUnpadded(plaintext)
+	if _, err := (&DecodeOptions{}).pbDecrypt(td, p); err != ErrDecryption {
+		t.Errorf("expected a decryption error without Lenient, got err: %v", err)
+	}
+	m, err := (&DecodeOptions{Lenient: true}).pbDecrypt(td, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m, plaintext) {
+		t.Errorf("expected M=%x, but found %x", plaintext, m)
+	}
+
+	td = encryptUnpadded([]byte("not ASN.1 at all"))
+	if _, err = (&DecodeOptions{Lenient: true}).pbDecrypt(td, p); err != ErrDecryption {
+		t.Errorf("expected a decryption error for unpadded data that is not ASN.1, got err: %v", err)
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		out []byte
+	}{
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 1}, []byte{1, 2, 3, 4, 5, 6, 7}},
+		{[]byte{1, 2, 3, 4, 5, 3, 3, 3}, []byte{1, 2, 3, 4, 5}},
+		{[]byte{8, 8, 8, 8, 8, 8, 8, 8}, []byte{}},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 0}, nil},
+		{[]byte{9, 9, 9, 9, 9, 9, 9, 9}, nil},
+		{[]byte{1, 2, 3, 4, 5, 3, 2, 3}, nil},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 2}, nil},
+	}
+	for _, test := range tests {
+		out, err := unpad(test.in, 8)
+		if test.out == nil {
+			if err != ErrDecryption {
+				t.Errorf("expected %x to have incorrect padding, got %x, %v", test.in, out, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(out, test.out) {
+			t.Errorf("expected %x to be unpadded to %x, got %x, %v", test.in, test.out, out, err)
+		}
+	}
+}
+
 type testDecryptable struct {
 	data      []byte
 	algorithm pkix.AlgorithmIdentifier
@@ -106,3 +179,201 @@ func (params pbeParams) RawASN1() (raw asn1.RawValue) {
 	}
 	return
 }
+
+func TestPbEmptySalt(t *testing.T) {
+	alg := pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3}),
+		Parameters: pbeParams{
+			Salt:       []byte{},
+			Iterations: 2048,
+		}.RawASN1(),
+	}
+	pass, _ := bmpString([]byte("Sesame open"))
+
+	if _, err := pbDecrypterFor(alg, pass, false); err == nil {
+		t.Errorf("expected decrypter for empty salt to fail")
+	}
+
+	if _, err := pbEncrypt(pbeWithSHAAnd3KeyTripleDESCBC, []byte("A secret"), nil, pass, 2048, nil); err == nil {
+		t.Errorf("expected encryption with empty salt to fail")
+	}
+}
+
+func TestPbZeroIterations(t *testing.T) {
+	pass, _ := bmpString([]byte("Sesame open"))
+	algWith := func(iterations int) pkix.AlgorithmIdentifier {
+		return pkix.AlgorithmIdentifier{
+			Algorithm: asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3}),
+			Parameters: pbeParams{
+				Salt:       []byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Iterations: iterations,
+			}.RawASN1(),
+		}
+	}
+
+	_, err := pbDecrypterFor(algWith(0), pass, false)
+	if err == nil || !strings.Contains(err.Error(), "iteration count of 0") {
+		t.Errorf("expected decrypter for zero iterations to fail, got: %v", err)
+	}
+
+	// leniently, zero iterations decrypt as one
+	lenient, err := pbDecrypterFor(algWith(0), pass, true)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	one, err := pbDecrypterFor(algWith(1), pass, false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	got, want := []byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	lenient.CryptBlocks(got, got)
+	one.CryptBlocks(want, want)
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected zero iterations to decrypt as one when lenient")
+	}
+
+	if _, err := pbEncrypt(pbeWithSHAAnd3KeyTripleDESCBC, []byte("A secret"), []byte{1, 2, 3, 4, 5, 6, 7, 8}, pass, 0, nil); err == nil {
+		t.Errorf("expected encryption with zero iterations to fail")
+	}
+	if _, _, err := encryptWith(PBES2AES256CBC, []byte("saltsalt"), 0, []byte("A secret"), pass, nil, nil); err == nil {
+		t.Errorf("expected PBES2 encryption with zero iterations to fail")
+	}
+}
+
+func TestTripleDESKeyLength(t *testing.T) {
+	newCipher := blockcodeByAlg[pbeWithSHAAnd3KeyTripleDESCBC]
+	if _, err := newCipher(make([]byte, 24)); err != nil {
+		t.Errorf("expected a 24-byte key to be accepted, got err: %v", err)
+	}
+	for _, length := range []int{0, 16, 20, 32} {
+		_, err := newCipher(make([]byte, length))
+		if err == nil {
+			t.Errorf("expected a %d-byte key to be refused", length)
+		} else if msg := err.Error(); !strings.Contains(msg, pbeWithSHAAnd3KeyTripleDESCBC) || !strings.Contains(msg, "24 bytes") || !strings.Contains(msg, fmt.Sprintf("%d bytes was derived", length)) {
+			t.Errorf("expected the error for a %d-byte key to name the algorithm and lengths, got: %v", length, err)
+		}
+	}
+}
+
+func TestPBES2Password(t *testing.T) {
+	for _, bmp := range [][]byte{
+		nil,
+		{0, 0},
+		{0, 'a', 0, 'b', 0, 0},
+		{0, 'a', 0, 'b'},
+		{0x20, 0xac, 0x00, 0xe9, 0x21, 0x15, 0, 0},
+		{0xd8, 0x3c, 0xdc, 0x00, 0, 0},               // a surrogate pair
+		{0xd8, 0x3c, 0, 'a', 0xdc, 0x00, 0xd8, 0x3c}, // lone surrogates
+	} {
+		s, _ := decodeBMPString(bmp)
+		if p := pbes2Password(bmp); string(p) != s {
+			t.Errorf("% x: expected %q, found %q", bmp, s, p)
+		}
+	}
+
+	// the result is a copy, which can be wiped without touching the input
+	bmp := []byte{0, 'a', 0, 0}
+	wipe(pbes2Password(bmp), nil)
+	if bmp[1] != 'a' {
+		t.Error("expected the BMP password to be left intact")
+	}
+}
+
+func TestPBES2KeyLength(t *testing.T) {
+	pass, _ := bmpString([]byte("Sesame open"))
+	iv, _ := asn1.Marshal(make([]byte, 16))
+
+	for keyLength, ok := range map[int]bool{0: true, 32: true, 16: false} {
+		kdfParams, err := asn1.Marshal(pbkdf2Params{Salt: []byte("saltsalt"), Iterations: 2048, KeyLength: keyLength})
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+			EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: iv}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		alg := pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+
+		if _, err = pbDecrypterFor(alg, pass, false); ok && err != nil {
+			t.Errorf("key length %d: %v", keyLength, err)
+		} else if !ok && err == nil {
+			t.Errorf("expected key length %d to be refused for AES-256", keyLength)
+		}
+	}
+
+	// the key length is written along with the other PBKDF2 parameters
+	for name, scheme := range pbes2SchemeByAlg {
+		item, _, err := pbes2Encrypt(name, []byte("A secret"), []byte("saltsalt"), pass, 2048, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der := make([]byte, item.size())
+		item.write(der)
+		var alg pkix.AlgorithmIdentifier
+		if _, err = asn1.Unmarshal(der, &alg); err != nil {
+			t.Fatal(err)
+		}
+		var params pbes2Params
+		if _, err = asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			t.Fatal(err)
+		}
+		var kdfParams pbkdf2Params
+		if _, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+			t.Fatal(err)
+		}
+		if kdfParams.KeyLength != scheme.keySize {
+			t.Errorf("%s: expected a key length of %d, found %d", name, scheme.keySize, kdfParams.KeyLength)
+		}
+	}
+}
+
+func TestPbDecrypterForParameterEncodings(t *testing.T) {
+	pass, _ := bmpString([]byte("Sesame open"))
+
+	// PBKDF2 pseudorandom functions, which have no parameters, are found
+	// both with NULL parameters and without any
+	iv, _ := asn1.Marshal(make([]byte, 16))
+	for name, prfParams := range map[string]asn1.RawValue{"NULL": asn1.NullRawValue, "absent": {}} {
+		kdfParams, err := asn1.Marshal(pbkdf2Params{
+			Salt:       []byte("saltsalt"),
+			Iterations: 2048,
+			Prf:        pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSHA256, Parameters: prfParams},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+			EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: iv}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		alg := pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+		if _, err = pbDecrypterFor(alg, pass, false); err != nil {
+			t.Errorf("%s PRF parameters: %v", name, err)
+		}
+	}
+
+	// the PBE schemes cannot do without theirs, which must be reported
+	// clearly rather than as an ASN.1 error
+	for _, oid := range []asn1.ObjectIdentifier{oidPbeWithSHAAnd3KeyTripleDESCBC, oidPBES2} {
+		for name, params := range map[string]asn1.RawValue{"NULL": asn1.NullRawValue, "absent": {}} {
+			alg := pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: params}
+			der, err := asn1.Marshal(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = asn1.Unmarshal(der, &alg); err != nil {
+				t.Fatal(err)
+			}
+			_, err = pbDecrypterFor(alg, pass, false)
+			if err == nil || !strings.Contains(err.Error(), "missing") {
+				t.Errorf("%v with %s parameters: expected missing parameters, got err: %v", oid, name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package pkcs12
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+// errDecoderClosed is returned when an entry is accessed after its Decoder
+// was closed.
+var errDecoderClosed = errors.New("pkcs12: decoder is closed")
+
+// Decoder gives access to the entries of PFX data without decrypting the
+// private keys up front. The authenticated safe is decrypted to enumerate the
+// bags, but each private key is only decrypted, and each certificate only
+// parsed, when it is first accessed. The password is retained for this until
+// Close is called.
+type Decoder struct {
+	opts      *DecodeOptions
+	password  []byte
+	decrypted [][]byte
+	entries   []*LazyEntry
+	closed    bool
+}
+
+// LazyEntry is an entry of a Decoder. It is laid out like Entry, but its
+// private key and certificate are decoded on first access.
+type LazyEntry struct {
+	FriendlyName     string
+	CertFriendlyName string
+	LocalKeyID       []byte
+	KeyProviderName  string
+	TrustAnchor      bool
+	TrustedKeyUsage  []asn1.ObjectIdentifier
+	Attributes       []Attribute
+
+	decoder *Decoder
+	keyBag  *safeBag
+	certBag *safeBag
+
+	// certIndex is the index of the certificate among those concatenated
+	// in certBag, which only DecodeOptions.Lenient accepts.
+	certIndex int
+
+	keyDone     bool
+	privateKey  interface{}
+	keyErr      error
+	certDone    bool
+	certificate *x509.Certificate
+	certErr     error
+}
+
+// NewDecoder verifies the MAC of pfxData with password and returns a Decoder
+// for its entries. The caller should Close the Decoder when done with it.
+func NewDecoder(pfxData []byte, password string) (*Decoder, error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.NewDecoder(pfxData)
+}
+
+// NewDecoder is like the package-level NewDecoder, but obtains the password
+// from opts.Password.
+func (opts *DecodeOptions) NewDecoder(pfxData []byte) (*Decoder, error) {
+	bags, decrypted, p, err := opts.getSafeContents(pfxData)
+	if err != nil {
+		wipe(p, decrypted)
+		return nil, err
+	}
+	d, err := opts.newDecoder(bags, decrypted, p)
+	if err != nil {
+		wipe(p, decrypted)
+		return nil, err
+	}
+	return d, nil
+}
+
+// newDecoder pairs the key and certificate bags into entries the way
+// DecodeAll does, without decoding them. Cert bags that do not hold an X.509
+// certificate are skipped. With opts.Lenient, each certificate after the
+// first of a cert bag holding several concatenated ones is an entry of its
+// own, with no attributes.
+func (opts *DecodeOptions) newDecoder(bags []safeBag, decrypted [][]byte, password []byte) (*Decoder, error) {
+	d := &Decoder{opts: opts, password: password, decrypted: decrypted}
+
+	var keys, certs []*LazyEntry
+	for i := range bags {
+		bag := &bags[i]
+		entry := &LazyEntry{decoder: d}
+		switch {
+		case bag.ID.Equal(oidCertBagType):
+			certType, err := certBagType(bag.Value.Bytes)
+			if err != nil {
+				return nil, bag.wrapError(err)
+			}
+			if !certType.Equal(oidCertTypeX509Certificate) {
+				// such as sdsiCertificate, which has no use here
+				continue
+			}
+			entry.certBag = bag
+		case isKeyBag(bag.ID):
+			entry.keyBag = bag
+		default:
+			continue
+		}
+
+		attributes, err := decodeBagAttributes(bag.Attributes)
+		if err != nil {
+			return nil, bag.wrapError(err)
+		}
+		entry.FriendlyName = attributes.friendlyName
+		entry.LocalKeyID = attributes.localKeyID
+		entry.KeyProviderName = attributes.keyProviderName
+		entry.TrustAnchor = attributes.trusted
+		entry.TrustedKeyUsage = attributes.trustedKeyUsage
+		entry.Attributes = attributes.other
+		if entry.keyBag != nil {
+			keys = append(keys, entry)
+			continue
+		}
+		entry.CertFriendlyName = attributes.friendlyName
+		certs = append(certs, entry)
+		if opts.Lenient {
+			if certsData, err := decodeCertBag(bag.Value.Bytes); err == nil {
+				ders, _ := splitCertificates(certsData)
+				for i := 1; i < len(ders); i++ {
+					certs = append(certs, &LazyEntry{decoder: d, certBag: bag, certIndex: i})
+				}
+			}
+		}
+	}
+
+	d.entries = keys
+	for _, cert := range certs {
+		if i := findLocalKeyID(keys, cert.LocalKeyID); i >= 0 && d.entries[i].certBag == nil {
+			d.entries[i].certBag = cert.certBag
+			d.entries[i].CertFriendlyName = cert.CertFriendlyName
+			if d.entries[i].FriendlyName == "" {
+				d.entries[i].FriendlyName = cert.FriendlyName
+			}
+			if !d.entries[i].TrustAnchor {
+				d.entries[i].TrustAnchor, d.entries[i].TrustedKeyUsage = cert.TrustAnchor, cert.TrustedKeyUsage
+			}
+			d.entries[i].Attributes = append(d.entries[i].Attributes, cert.Attributes...)
+			continue
+		}
+		d.entries = append(d.entries, cert)
+	}
+	return d, nil
+}
+
+// Entries returns the entries of d, ordered like those of DecodeAll.
+func (d *Decoder) Entries() []*LazyEntry {
+	return d.entries
+}
+
+// Close wipes the password and decrypted contents retained by d. Entries
+// that were not accessed before can no longer be decoded.
+func (d *Decoder) Close() error {
+	wipe(d.password, d.decrypted)
+	d.password, d.decrypted = nil, nil
+	d.closed = true
+	return nil
+}
+
+// HasPrivateKey reports whether e holds a private key.
+func (e *LazyEntry) HasPrivateKey() bool {
+	return e.keyBag != nil
+}
+
+// PrivateKey decrypts and returns the private key of e, or nil if e holds
+// only a certificate.
+func (e *LazyEntry) PrivateKey() (interface{}, error) {
+	if e.keyBag == nil || e.keyDone {
+		return e.privateKey, e.keyErr
+	}
+	if e.decoder.closed {
+		return nil, errDecoderClosed
+	}
+	e.privateKey, e.keyErr = e.decoder.opts.decodeKeyBag(e.keyBag, e.decoder.password)
+	e.keyErr = e.keyBag.wrapError(e.keyErr)
+	e.keyDone = true
+	return e.privateKey, e.keyErr
+}
+
+// Certificate parses and returns the certificate of e, or nil if e holds a
+// private key that was not paired with a certificate.
+func (e *LazyEntry) Certificate() (*x509.Certificate, error) {
+	if e.certBag == nil || e.certDone {
+		return e.certificate, e.certErr
+	}
+	if e.decoder.closed {
+		return nil, errDecoderClosed
+	}
+	var certsData []byte
+	if certsData, e.certErr = decodeCertBag(e.certBag.Value.Bytes); e.certErr == nil {
+		if e.decoder.opts.Lenient {
+			if ders, err := splitCertificates(certsData); err == nil && e.certIndex < len(ders) {
+				certsData = ders[e.certIndex]
+			}
+		}
+		e.certificate, e.certErr = x509.ParseCertificate(certsData)
+	}
+	if e.certErr == nil {
+		if e.certErr = e.decoder.opts.checkCertificate(e.certificate); e.certErr != nil {
+			e.certificate = nil
+		}
+	}
+	e.certErr = e.certBag.wrapError(e.certErr)
+	e.certDone = true
+	return e.certificate, e.certErr
+}
+
+// wipe zeroes password and the decrypted buffers.
+func wipe(password []byte, decrypted [][]byte) {
+	for i := 0; i < len(password); i++ {
+		password[i] = 0
+	}
+	for _, d := range decrypted {
+		for i := 0; i < len(d); i++ {
+			d[i] = 0
+		}
+	}
+}
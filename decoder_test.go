@@ -0,0 +1,53 @@
+package pkcs12
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	key, _ := testIdentity(t)
+
+	d, err := NewDecoder(buildTestPFX(t, "lazy"), "lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := d.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, found %d", len(entries))
+	}
+	if !entries[0].HasPrivateKey() || !entries[1].HasPrivateKey() || entries[2].HasPrivateKey() {
+		t.Errorf("expected the two key entries to come first")
+	}
+	if entries[1].FriendlyName != "2" || !bytes.Equal(entries[1].LocalKeyID, []byte{2}) {
+		t.Errorf("unexpected attributes %q and %x", entries[1].FriendlyName, entries[1].LocalKeyID)
+	}
+
+	k, err := entries[1].PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(k) {
+		t.Errorf("unexpected private key")
+	}
+	if entries[0].keyDone {
+		t.Errorf("expected the other private key not to be decrypted")
+	}
+
+	password := d.password
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(password, make([]byte, len(password))) {
+		t.Errorf("expected the password to be wiped on Close")
+	}
+	if _, err = entries[0].PrivateKey(); err != errDecoderClosed {
+		t.Errorf("expected decoder closed error, got err: %v", err)
+	}
+	if k, err = entries[1].PrivateKey(); err != nil || !key.Equal(k) {
+		t.Errorf("expected the decoded private key to remain available, got err: %v", err)
+	}
+	if c, err := entries[2].Certificate(); c != nil || err != errDecoderClosed {
+		t.Errorf("expected decoder closed error, got err: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package pkcs12
+
+import (
+	"crypto/dsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+var oidPublicKeyDSA = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+
+// pkcs8 is the PKCS#8 PrivateKeyInfo of https://tools.ietf.org/html/rfc5208#section-5
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+type dsaAlgorithmParameters struct {
+	P, Q, G *big.Int
+}
+
+// parsePKCS8PrivateKey parses a PKCS#8 private key like
+// x509.ParsePKCS8PrivateKey, and additionally supports the DSA keys found in
+// legacy keystores, which it returns as a *dsa.PrivateKey.
+func parsePKCS8PrivateKey(der []byte) (interface{}, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err == nil {
+		return key, nil
+	}
+
+	var privKey pkcs8
+	if _, perr := asn1.Unmarshal(der, &privKey); perr != nil || !privKey.Algo.Algorithm.Equal(oidPublicKeyDSA) {
+		return nil, err
+	}
+	return parseDSAPrivateKey(&privKey)
+}
+
+// marshalPKCS8PrivateKey returns the PKCS#8 DER encoding of privateKey like
+// x509.MarshalPKCS8PrivateKey, and additionally supports the DSA keys that
+// parsePKCS8PrivateKey returns.
+func marshalPKCS8PrivateKey(privateKey interface{}) ([]byte, error) {
+	key, ok := privateKey.(*dsa.PrivateKey)
+	if !ok {
+		return x509.MarshalPKCS8PrivateKey(privateKey)
+	}
+	params, err := asn1.Marshal(dsaAlgorithmParameters{P: key.P, Q: key.Q, G: key.G})
+	if err != nil {
+		return nil, err
+	}
+	x, err := asn1.Marshal(key.X)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyDSA, Parameters: asn1.RawValue{FullBytes: params}},
+		PrivateKey: x,
+	})
+}
+
+func parseDSAPrivateKey(privKey *pkcs8) (*dsa.PrivateKey, error) {
+	var params dsaAlgorithmParameters
+	if rest, err := asn1.Unmarshal(privKey.Algo.Parameters.FullBytes, &params); err != nil || len(rest) != 0 {
+		return nil, NotImplementedError("DSA private key without domain parameters is not supported")
+	}
+	if params.P.Sign() <= 0 || params.Q.Sign() <= 0 || params.G.Sign() <= 0 {
+		return nil, errors.New("pkcs12: invalid DSA domain parameters")
+	}
+
+	x := new(big.Int)
+	if rest, err := asn1.Unmarshal(privKey.PrivateKey, &x); err != nil || len(rest) != 0 {
+		return nil, errors.New("pkcs12: invalid DSA private key")
+	}
+	if x.Sign() <= 0 || x.Cmp(params.Q) >= 0 {
+		return nil, errors.New("pkcs12: invalid DSA private key")
+	}
+
+	key := &dsa.PrivateKey{
+		PublicKey: dsa.PublicKey{
+			Parameters: dsa.Parameters{P: params.P, Q: params.Q, G: params.G},
+		},
+		X: x,
+	}
+	// the public value is not stored in PKCS#8, recompute it as g^x mod p
+	key.Y = new(big.Int).Exp(params.G, x, params.P)
+	return key, nil
+}
@@ -0,0 +1,794 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EncryptionAlgorithm is a password-based encryption scheme that private
+// keys and certificates can be protected with.
+type EncryptionAlgorithm string
+
+// Encryption algorithms supported for encoding.
+const (
+	PBEWithSHAAnd3KeyTripleDESCBC EncryptionAlgorithm = pbeWithSHAAnd3KeyTripleDESCBC
+	PBEWithSHAAnd40BitRC2CBC      EncryptionAlgorithm = pbewithSHAAnd40BitRC2CBC
+
+	// The PBES2 algorithms derive their key with PBKDF2 and HMAC-SHA256, as
+	// OpenSSL 3 does by default.
+	PBES2AES128CBC EncryptionAlgorithm = pbes2AES128CBC
+	PBES2AES192CBC EncryptionAlgorithm = pbes2AES192CBC
+	PBES2AES256CBC EncryptionAlgorithm = pbes2AES256CBC
+)
+
+// Default iteration counts of the key derivations, which an Encoder picks
+// for the algorithms it uses unless WithIterations or WithMacIterations set
+// others.
+const (
+	// DefaultPBES1Iterations is for the PKCS#12 PBES1 algorithms and the
+	// SHA-1 MAC, as openssl pkcs12 -export uses.
+	DefaultPBES1Iterations = 2048
+
+	// DefaultPBES2Iterations is for the PBES2 algorithms, and
+	// DefaultSHA256MacIterations for the SHA-256 MAC and PBMAC1. They are
+	// above the 2048 that OpenSSL 3 uses with them, and match Java keytool,
+	// which has used PBES2 and the SHA-256 MAC with 10000 iterations since
+	// JDK 18 and 11.0.12.
+	DefaultPBES2Iterations     = 10000
+	DefaultSHA256MacIterations = 10000
+)
+
+// Profile is the arrangement of the ContentInfos and bags of encoded PFX
+// data, after the tool whose output it mimics for importers that expect it.
+type Profile int
+
+// Profiles supported for encoding.
+const (
+	// ProfileOpenSSL stores the certificates, in the order given, followed
+	// by the private key, as openssl pkcs12 -export does.
+	ProfileOpenSSL Profile = iota
+
+	// ProfileWindows stores the private key followed by the certificates,
+	// as the Windows certificate export does.
+	ProfileWindows
+
+	// ProfileJava stores the certificates followed by the private key, as
+	// keytool does, with the chain of the certificate ordered from it up to
+	// the root, and any other certificates after that.
+	ProfileJava
+)
+
+// KeyProtection is how private keys are protected in encoded PFX data.
+type KeyProtection int
+
+// Key protections supported for encoding.
+const (
+	// ShroudedKeyBag stores each private key encrypted, as a PKCS#8
+	// EncryptedPrivateKeyInfo in a pkcs8ShroudedKeyBag, within a data
+	// ContentInfo. This is what OpenSSL, Windows and Java write, and the
+	// only form that Java keytool reads.
+	ShroudedKeyBag KeyProtection = iota
+
+	// EncryptedKeyBag stores each private key as a plain PKCS#8
+	// PrivateKeyInfo in a keyBag, within an encryptedData ContentInfo of
+	// its own. This is the other arrangement RFC 7292 allows; OpenSSL
+	// reads it, Java keytool does not.
+	EncryptedKeyBag
+
+	// EncryptedShroudedKeyBag stores each private key shrouded as with
+	// ShroudedKeyBag, but within an encryptedData ContentInfo of its own, so
+	// that it is encrypted twice, for importers that expect every
+	// ContentInfo to be encrypted. OpenSSL reads it.
+	EncryptedShroudedKeyBag
+)
+
+// LocalKeyIDScheme is how the localKeyId pairing a private key with its
+// certificate is derived.
+type LocalKeyIDScheme struct {
+	publicKey   bool
+	hasExplicit bool
+	explicit    []byte
+}
+
+// LocalKeyId schemes supported for encoding.
+var (
+	// CertSHA1 derives the localKeyId as the SHA-1 hash of the DER
+	// encoding of the certificate, as OpenSSL does.
+	CertSHA1 = LocalKeyIDScheme{}
+
+	// PubKeySHA1 derives the localKeyId as the SHA-1 hash of the
+	// subjectPublicKey of the certificate, as for a subject key identifier.
+	PubKeySHA1 = LocalKeyIDScheme{publicKey: true}
+)
+
+// Explicit returns a LocalKeyIDScheme that uses id as it is, such as a GUID
+// as Windows assigns. Encoding fails if id is empty.
+func Explicit(id []byte) LocalKeyIDScheme {
+	return LocalKeyIDScheme{hasExplicit: true, explicit: append([]byte(nil), id...)}
+}
+
+// localKeyID derives the localKeyId of certificate with s.
+func (s LocalKeyIDScheme) localKeyID(certificate *x509.Certificate) ([]byte, error) {
+	switch {
+	case s.hasExplicit && len(s.explicit) == 0:
+		return nil, errors.New("pkcs12: explicit localKeyId is empty")
+	case s.hasExplicit:
+		return s.explicit, nil
+	case s.publicKey:
+		var spki struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		if _, err := asn1.Unmarshal(certificate.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return nil, errors.New("pkcs12: error decoding public key of certificate: " + err.Error())
+		}
+		keyID := sha1.Sum(spki.PublicKey.Bytes)
+		return keyID[:], nil
+	}
+	keyID := sha1.Sum(certificate.Raw)
+	return keyID[:], nil
+}
+
+// Encoder encodes private keys and certificates into PFX data. Create one
+// with NewEncoder; the zero value is not usable.
+type Encoder struct {
+	keyAlgorithm  EncryptionAlgorithm
+	certAlgorithm EncryptionAlgorithm
+	macAlgorithm  MacAlgorithm
+	pbmac1Hash    MacAlgorithm
+	keyProvider   string
+	keyName       string
+	certName      string
+	keyIDScheme   LocalKeyIDScheme
+	pemPassphrase string
+	keyAttrs      []Attribute
+	certAttrs     []Attribute
+	trustAnchors  []*x509.Certificate
+	trustUsages   [][]asn1.ObjectIdentifier
+	iterations    int
+	macIterations int
+	macSaltLength int
+	rand          io.Reader
+	generateSalt  func(length int) ([]byte, error)
+	layout        Profile
+	keyProtection KeyProtection
+
+	anyEncryptedKeyAlgorithm bool
+	verifyAfterEncode        bool
+	sortChain                bool
+	onIncompleteChain        func(*IncompleteChainError)
+	fixedBatchSalts          bool
+
+	// keys and batchSalts are set by ReEncryptBatch with WithBatchSalts
+	// for the files it re-encrypts
+	keys       *derivedKeys
+	batchSalts *batchSalts
+}
+
+// EncodeOption configures an Encoder.
+type EncodeOption func(*Encoder)
+
+// NewEncoder returns an Encoder with opts applied. Unless configured
+// otherwise, it produces the same layout and algorithms as Create: the
+// private key is shrouded with pbeWithSHAAnd3-KeyTripleDES-CBC, certificates
+// are encrypted with pbewithSHAAnd40BitRC2-CBC, and the file is protected
+// with a SHA-1 MAC, all with 2048 iterations. The iteration counts otherwise
+// default to those named by DefaultPBES1Iterations and the like for the
+// algorithms chosen.
+func NewEncoder(opts ...EncodeOption) *Encoder {
+	enc := &Encoder{
+		keyAlgorithm:  PBEWithSHAAnd3KeyTripleDESCBC,
+		certAlgorithm: PBEWithSHAAnd40BitRC2CBC,
+		macAlgorithm:  SHA1,
+		pbmac1Hash:    SHA256,
+	}
+	for _, opt := range opts {
+		opt(enc)
+	}
+	return enc
+}
+
+// WithKeyAlgorithm sets the algorithm private keys are shrouded with.
+func WithKeyAlgorithm(algorithm EncryptionAlgorithm) EncodeOption {
+	return func(enc *Encoder) { enc.keyAlgorithm = algorithm }
+}
+
+// WithCertAlgorithm sets the algorithm certificates are encrypted with.
+func WithCertAlgorithm(algorithm EncryptionAlgorithm) EncodeOption {
+	return func(enc *Encoder) { enc.certAlgorithm = algorithm }
+}
+
+// WithMacAlgorithm sets the digest algorithm the integrity MAC is computed
+// with.
+func WithMacAlgorithm(algorithm MacAlgorithm) EncodeOption {
+	return func(enc *Encoder) { enc.macAlgorithm = algorithm }
+}
+
+// WithPBMAC1Hash sets the hash of the HMAC used to derive the key and compute
+// the MAC when the MAC algorithm is PBMAC1. It defaults to SHA256.
+func WithPBMAC1Hash(hash MacAlgorithm) EncodeOption {
+	return func(enc *Encoder) { enc.pbmac1Hash = hash }
+}
+
+// WithIterations sets the iteration count of the key derivation of the key
+// and certificate algorithms, rather than the default for each.
+func WithIterations(iterations int) EncodeOption {
+	return func(enc *Encoder) { enc.iterations = iterations }
+}
+
+// WithMacIterations sets the iteration count of the MAC key derivation,
+// rather than the default for the MAC algorithm.
+func WithMacIterations(iterations int) EncodeOption {
+	return func(enc *Encoder) { enc.macIterations = iterations }
+}
+
+// WithMacSaltLength sets the length of the MAC salt. By default it is the
+// output size of the MAC digest, 20 bytes for SHA-1 and 32 for SHA-256, as
+// some importers expect.
+func WithMacSaltLength(length int) EncodeOption {
+	return func(enc *Encoder) { enc.macSaltLength = length }
+}
+
+// WithKeyProviderName sets the Microsoft keyProviderName attribute of the
+// private key, naming the CSP or KSP Windows imports it into.
+func WithKeyProviderName(name string) EncodeOption {
+	return func(enc *Encoder) { enc.keyProvider = name }
+}
+
+// WithFriendlyName sets the friendlyName attribute of the private key, and of
+// its certificate unless WithCertFriendlyName sets another.
+func WithFriendlyName(name string) EncodeOption {
+	return func(enc *Encoder) { enc.keyName = name }
+}
+
+// WithCertFriendlyName sets the friendlyName attribute of the certificate of
+// the private key, for files where it differs from that of the key.
+func WithCertFriendlyName(name string) EncodeOption {
+	return func(enc *Encoder) { enc.certName = name }
+}
+
+// WithLocalKeyIDScheme sets how Encode derives the localKeyId of the private
+// key and its certificate, CertSHA1 by default.
+func WithLocalKeyIDScheme(scheme LocalKeyIDScheme) EncodeOption {
+	return func(enc *Encoder) { enc.keyIDScheme = scheme }
+}
+
+// WithPEMPassphrase sets the passphrase EncodeFromPEM decrypts an encrypted
+// private key with, which need not be the password of the PFX.
+func WithPEMPassphrase(passphrase string) EncodeOption {
+	return func(enc *Encoder) { enc.pemPassphrase = passphrase }
+}
+
+// WithKeyAttributes adds attributes, written verbatim, to the bag of the
+// private key.
+func WithKeyAttributes(attributes ...Attribute) EncodeOption {
+	return func(enc *Encoder) { enc.keyAttrs = append(enc.keyAttrs, attributes...) }
+}
+
+// WithCertAttributes adds attributes, written verbatim, to the bag of the
+// certificate of the private key.
+func WithCertAttributes(attributes ...Attribute) EncodeOption {
+	return func(enc *Encoder) { enc.certAttrs = append(enc.certAttrs, attributes...) }
+}
+
+// WithTrustAnchors marks certificates as trust anchors with the Oracle
+// trustedKeyUsage attribute, so that Java keytool imports them as
+// trustedCertEntry alongside the identity. Anchors that are among the caCerts
+// passed to Encode are marked in place, the others are added after them. They
+// are trusted for anyExtendedKeyUsage.
+func WithTrustAnchors(anchors ...*x509.Certificate) EncodeOption {
+	return func(enc *Encoder) {
+		for _, anchor := range anchors {
+			enc.trustAnchors = append(enc.trustAnchors, anchor)
+			enc.trustUsages = append(enc.trustUsages, nil)
+		}
+	}
+}
+
+// WithTrustAnchorUsage marks anchor as a trust anchor like WithTrustAnchors,
+// but for the extended key usages listed, such as those of the
+// TrustedKeyUsage of a decoded Entry, or as keytool writes, those of the
+// extendedKeyUsage extension of anchor.
+func WithTrustAnchorUsage(anchor *x509.Certificate, usages ...asn1.ObjectIdentifier) EncodeOption {
+	return func(enc *Encoder) {
+		enc.trustAnchors = append(enc.trustAnchors, anchor)
+		enc.trustUsages = append(enc.trustUsages, usages)
+	}
+}
+
+// WithKeyProtection sets how private keys are protected, ShroudedKeyBag by
+// default. With EncryptedKeyBag and EncryptedShroudedKeyBag, the key
+// algorithm encrypts the ContentInfo holding the keys.
+func WithKeyProtection(protection KeyProtection) EncodeOption {
+	return func(enc *Encoder) { enc.keyProtection = protection }
+}
+
+// WithAnyEncryptedKeyAlgorithm makes Encode store an EncryptedPKCS8Key
+// whatever the algorithm it is encrypted with, rather than only one encrypted
+// with the key algorithm.
+func WithAnyEncryptedKeyAlgorithm() EncodeOption {
+	return func(enc *Encoder) { enc.anyEncryptedKeyAlgorithm = true }
+}
+
+// WithVerifyAfterEncode makes Encode and EncodeCertificate decode the PFX
+// data they produce with the same password, and fail unless it holds the
+// private key and certificates they were given, as a safety net in tests and
+// with nonstandard options. It doubles the cost of encoding.
+func WithVerifyAfterEncode() EncodeOption {
+	return func(enc *Encoder) { enc.verifyAfterEncode = true }
+}
+
+// WithSortChain makes Encode store the caCerts in the order of the chain of
+// the certificate, from its issuer up to the root, matching the issuer of
+// each certificate with the subject of the next, for importers that take the
+// certificates in the order they are stored. The certificates that are not
+// in the chain follow it, in the order given. If the chain does not reach a
+// self-signed root, or some certificates are not in it, Encode still returns
+// the PFX data so ordered, and reports it to the handler set by
+// WithIncompleteChainHandler, if any. Without it, the caCerts are stored in
+// the order given, unless WithLayout(ProfileJava) orders them the same way.
+func WithSortChain() EncodeOption {
+	return func(enc *Encoder) { enc.sortChain = true }
+}
+
+// WithIncompleteChainHandler sets a function that Encode calls with
+// WithSortChain, before returning, when the chain of the certificate could
+// only be partly ordered, as a warning that does not fail the encoding.
+func WithIncompleteChainHandler(handle func(*IncompleteChainError)) EncodeOption {
+	return func(enc *Encoder) { enc.onIncompleteChain = handle }
+}
+
+// WithLayout sets the arrangement of the ContentInfos and bags, ProfileOpenSSL
+// by default.
+func WithLayout(profile Profile) EncodeOption {
+	return func(enc *Encoder) { enc.layout = profile }
+}
+
+// WithRand sets the source of the random salts and IVs, crypto/rand.Reader
+// by default.
+func WithRand(random io.Reader) EncodeOption {
+	return func(enc *Encoder) { enc.rand = random }
+}
+
+// WithGenerateSalt sets the function the salts of the key, certificate and
+// MAC encryption are obtained from, for sources of randomness that are not
+// an io.Reader. By default salts are read from the reader set by WithRand.
+func WithGenerateSalt(generateSalt func(length int) ([]byte, error)) EncodeOption {
+	return func(enc *Encoder) { enc.generateSalt = generateSalt }
+}
+
+// Encode produces PFX data holding privateKey, its certificate and the
+// caCerts, protected with password. See Encoder.Encode.
+func Encode(privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, password string, opts ...EncodeOption) ([]byte, error) {
+	return NewEncoder(opts...).Encode(privateKey, certificate, caCerts, password)
+}
+
+// Encode produces PFX data holding privateKey, its certificate and the
+// caCerts, protected with password. The certificates are stored together in
+// an encryptedData ContentInfo, followed by the shrouded private key in a
+// data ContentInfo, unless WithLayout arranges them otherwise. The key and
+// its certificate carry a localKeyId of the SHA-1 hash of the certificate,
+// as with OpenSSL, unless WithLocalKeyIDScheme derives it otherwise. The
+// certificates, the key and the MAC are each given a salt of their own.
+//
+// privateKey may be an EncryptedPKCS8Key, such as an HSM exports, which is
+// stored as it is in the pkcs8ShroudedKeyBag, without being decrypted. It
+// must be encrypted with password and, unless WithAnyEncryptedKeyAlgorithm is
+// set, with the key algorithm. As it cannot be checked against the public key
+// of certificate, the caller is trusted to pair them.
+//
+// An empty password is used as the two zero bytes of an empty BMPString with
+// its NULL terminator, which OpenSSL, Java and Windows read as no password.
+// The absent password of zero bytes that some implementations use instead is
+// accepted when decoding, but is never produced.
+func (enc *Encoder) Encode(privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) ([]byte, error) {
+	if certificate == nil {
+		return nil, errors.New("pkcs12: certificate missing")
+	}
+	var pkcs8 []byte
+	encryptedKey, preEncrypted := privateKey.(EncryptedPKCS8Key)
+	if preEncrypted {
+		if err := enc.checkEncryptedKey(encryptedKey); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if pkcs8, err = x509.MarshalPKCS8PrivateKey(privateKey); err != nil {
+			return nil, err
+		}
+		defer func() { // clear out the unencrypted private key before we return
+			for i := 0; i < len(pkcs8); i++ {
+				pkcs8[i] = 0
+			}
+		}()
+	}
+
+	keyID, err := enc.keyIDScheme.localKeyID(certificate)
+	if err != nil {
+		return nil, err
+	}
+	id := NewLocalKeyIDAttribute(keyID)
+
+	certs, err := enc.newEncryptedContentInfoBuilder(enc.certAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	certName := enc.certName
+	if certName == "" {
+		certName = enc.keyName
+	}
+	certAttributes := []Attribute{id}
+	if certName != "" {
+		name, err := NewFriendlyNameAttribute(certName)
+		if err != nil {
+			return nil, err
+		}
+		certAttributes = append(certAttributes, name)
+	}
+	certs.AddCertificate(certificate.Raw, append(certAttributes, enc.certAttrs...)...)
+	var chainErr *IncompleteChainError
+	if enc.sortChain || enc.layout == ProfileJava {
+		chain, rest := splitChain(certificate, caCerts)
+		caCerts = append(chain[1:], rest...)
+		if last := chain[len(chain)-1]; enc.sortChain && (!bytes.Equal(last.RawIssuer, last.RawSubject) || len(rest) > 0) {
+			chainErr = &IncompleteChainError{Last: last, Unchained: rest}
+		}
+	}
+	for _, c := range caCerts {
+		if i := indexCertificate(enc.trustAnchors, c); i >= 0 {
+			certs.AddCertificate(c.Raw, NewTrustedKeyUsageAttribute(enc.trustUsages[i]...))
+		} else {
+			certs.AddCertificate(c.Raw)
+		}
+	}
+	for i, c := range enc.trustAnchors {
+		if !containsCertificate(caCerts, c) {
+			certs.AddCertificate(c.Raw, NewTrustedKeyUsageAttribute(enc.trustUsages[i]...))
+		}
+	}
+
+	keyAttributes := []Attribute{id}
+	if enc.keyName != "" {
+		name, err := NewFriendlyNameAttribute(enc.keyName)
+		if err != nil {
+			return nil, err
+		}
+		keyAttributes = append(keyAttributes, name)
+	}
+	if enc.keyProvider != "" {
+		provider, err := NewKeyProviderNameAttribute(enc.keyProvider)
+		if err != nil {
+			return nil, err
+		}
+		keyAttributes = append(keyAttributes, provider)
+	}
+	keyAttributes = append(keyAttributes, enc.keyAttrs...)
+	var keys *ContentInfoBuilder
+	if enc.keyProtection == ShroudedKeyBag {
+		keys = enc.newContentInfoBuilder()
+	} else if keys, err = enc.newEncryptedContentInfoBuilder(enc.keyAlgorithm); err != nil {
+		return nil, err
+	}
+	switch {
+	case preEncrypted:
+		keys.AddBag(oidPkcs8ShroudedKeyBagType, encryptedKey, keyAttributes...)
+	case enc.keyProtection == EncryptedKeyBag:
+		keys.AddKey(pkcs8, keyAttributes...)
+	default:
+		if err = enc.addShroudedKey(keys, pkcs8, keyAttributes); err != nil {
+			return nil, err
+		}
+	}
+
+	pfx, err := enc.newPFXBuilder()
+	if err != nil {
+		return nil, err
+	}
+	if enc.layout == ProfileWindows {
+		pfx.Add(keys)
+		pfx.Add(certs)
+	} else {
+		pfx.Add(certs)
+		pfx.Add(keys)
+	}
+	pfxData, err := pfx.Build([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+	if enc.verifyAfterEncode {
+		others := append(append([]*x509.Certificate(nil), caCerts...), enc.trustAnchors...)
+		if err = verifyEncoded(pfxData, password, privateKey, certificate, others); err != nil {
+			return nil, err
+		}
+	}
+	if chainErr != nil && enc.onIncompleteChain != nil {
+		enc.onIncompleteChain(chainErr)
+	}
+	return pfxData, nil
+}
+
+// checkEncryptedKey checks that encryptedKey can be stored by enc as it is.
+func (enc *Encoder) checkEncryptedKey(encryptedKey EncryptedPKCS8Key) error {
+	if enc.keyProtection == EncryptedKeyBag {
+		return errors.New("pkcs12: an encrypted PKCS#8 key is only stored in a pkcs8ShroudedKeyBag")
+	}
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(encryptedKey, &info); err != nil {
+		return errors.New("pkcs12: error decoding encrypted private key: " + err.Error())
+	} else if len(rest) != 0 {
+		return errors.New("pkcs12: trailing data after encrypted private key")
+	}
+	if enc.anyEncryptedKeyAlgorithm {
+		return nil
+	}
+	if algorithm, ok := encryptionAlgorithmOf(info.AlgorithmIdentifier); !ok || algorithm != enc.keyAlgorithm {
+		return fmt.Errorf("pkcs12: encrypted private key is encrypted with %v rather than the key algorithm %s", info.AlgorithmIdentifier.Algorithm, enc.keyAlgorithm)
+	}
+	return nil
+}
+
+// EncodeCertificate produces PFX data holding only certificate, protected
+// with password. See Encoder.EncodeCertificate.
+func EncodeCertificate(certificate *x509.Certificate, password string, opts ...EncodeOption) ([]byte, error) {
+	return NewEncoder(opts...).EncodeCertificate(certificate, password)
+}
+
+// EncodeCertificate produces PFX data holding certificate and no private
+// key, protected with password, for distributing a certificate to systems
+// that only import PKCS#12. The certificate is stored alone in an
+// encryptedData ContentInfo, as openssl pkcs12 -export -nokeys does, with a
+// friendlyName of the name set by WithCertFriendlyName or WithFriendlyName,
+// or else of the common name of its subject, and the attributes set by
+// WithCertAttributes. DecodeChain returns it as the certificate of a nil
+// private key.
+func (enc *Encoder) EncodeCertificate(certificate *x509.Certificate, password string) ([]byte, error) {
+	if certificate == nil {
+		return nil, errors.New("pkcs12: certificate missing")
+	}
+
+	certs, err := enc.newEncryptedContentInfoBuilder(enc.certAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	certName := enc.certName
+	if certName == "" {
+		certName = enc.keyName
+	}
+	if certName == "" {
+		certName = certificate.Subject.CommonName
+	}
+	var certAttributes []Attribute
+	if certName != "" {
+		name, err := NewFriendlyNameAttribute(certName)
+		if err != nil {
+			return nil, err
+		}
+		certAttributes = append(certAttributes, name)
+	}
+	certs.AddCertificate(certificate.Raw, append(certAttributes, enc.certAttrs...)...)
+
+	pfx, err := enc.newPFXBuilder()
+	if err != nil {
+		return nil, err
+	}
+	pfx.Add(certs)
+	pfxData, err := pfx.Build([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+	if enc.verifyAfterEncode {
+		if err = verifyEncoded(pfxData, password, nil, certificate, nil); err != nil {
+			return nil, err
+		}
+	}
+	return pfxData, nil
+}
+
+// verifyEncoded decodes pfxData with password and checks that it holds
+// certificate, paired with privateKey unless it is nil, and caCerts. A
+// private key that cannot be compared, such as a DSA key or an
+// EncryptedPKCS8Key, is only checked to be paired with certificate.
+func verifyEncoded(pfxData []byte, password string, privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate) error {
+	entries, err := DecodeAll(pfxData, password)
+	if err != nil {
+		return errors.New("pkcs12: encoded PFX data does not decode: " + err.Error())
+	}
+
+	found := false
+	var certs []*x509.Certificate
+	for _, e := range entries {
+		if e.Certificate != nil {
+			certs = append(certs, e.Certificate)
+		}
+		if e.PrivateKey == nil || privateKey == nil {
+			continue
+		}
+		if key, ok := privateKey.(interface{ Equal(crypto.PrivateKey) bool }); ok && !key.Equal(e.PrivateKey) {
+			continue
+		}
+		if e.Certificate != nil && e.Certificate.Equal(certificate) {
+			found = true
+		}
+	}
+	if privateKey != nil && !found {
+		return errors.New("pkcs12: encoded PFX data does not hold the private key with its certificate")
+	}
+	for _, c := range append([]*x509.Certificate{certificate}, caCerts...) {
+		if !containsCertificate(certs, c) {
+			return fmt.Errorf("pkcs12: encoded PFX data does not hold certificate %q", c.Subject)
+		}
+	}
+	return nil
+}
+
+// EncodePlan describes the algorithms and parameters an Encoder protects the
+// PFX data it produces with.
+type EncodePlan struct {
+	// KeyAlgorithm protects the private keys, as set out by KeyProtection,
+	// with Iterations, and CertAlgorithm encrypts the certificates with
+	// CertIterations. Both are used with a salt of SaltLength bytes.
+	KeyAlgorithm   EncryptionAlgorithm
+	KeyProtection  KeyProtection
+	CertAlgorithm  EncryptionAlgorithm
+	Iterations     int
+	CertIterations int
+	SaltLength     int
+
+	// MacAlgorithm is the MAC, and MacDigest the digest it is computed
+	// with, which for PBMAC1 is the hash of the HMAC and of PBKDF2. The MAC
+	// key is derived with MacIterations and a salt of MacSaltLength bytes.
+	MacAlgorithm  MacAlgorithm
+	MacDigest     MacAlgorithm
+	MacIterations int
+	MacSaltLength int
+}
+
+// Plan returns the algorithms and parameters Encode and the other encoding
+// methods of enc would use, without encoding anything, so that they can be
+// checked against a policy beforehand.
+func (enc *Encoder) Plan() EncodePlan {
+	plan := EncodePlan{
+		KeyAlgorithm:   enc.keyAlgorithm,
+		KeyProtection:  enc.keyProtection,
+		CertAlgorithm:  enc.certAlgorithm,
+		Iterations:     enc.iterationsFor(enc.keyAlgorithm),
+		CertIterations: enc.iterationsFor(enc.certAlgorithm),
+		SaltLength:     defaultSaltLength,
+		MacAlgorithm:   enc.macAlgorithm,
+		MacDigest:      enc.macAlgorithm,
+		MacIterations:  enc.macIterationCount(),
+		MacSaltLength:  enc.macSaltLen(),
+	}
+	if enc.macAlgorithm == PBMAC1 {
+		plan.MacDigest = enc.pbmac1Hash
+	}
+	return plan
+}
+
+// newContentInfoBuilder returns a builder for a data ContentInfo that reads
+// its randomness from enc.
+func (enc *Encoder) newContentInfoBuilder() *ContentInfoBuilder {
+	b := NewContentInfoBuilder()
+	b.rand = enc.rand
+	b.keys = enc.keys
+	return b
+}
+
+// newEncryptedContentInfoBuilder returns a builder for an encryptedData
+// ContentInfo encrypted with algorithm and the settings of enc.
+func (enc *Encoder) newEncryptedContentInfoBuilder(algorithm EncryptionAlgorithm) (*ContentInfoBuilder, error) {
+	salt, err := enc.salt(defaultSaltLength)
+	if err != nil {
+		return nil, err
+	}
+	b := NewEncryptedContentInfoBuilder(algorithm, salt, enc.iterationsFor(algorithm))
+	b.rand = enc.rand
+	b.keys = enc.keys
+	return b, nil
+}
+
+// addShroudedKey adds the PKCS#8 private key to b, shrouded with the key
+// algorithm of enc.
+func (enc *Encoder) addShroudedKey(b *ContentInfoBuilder, privateKey []byte, attributes []Attribute) error {
+	salt, err := enc.salt(defaultSaltLength)
+	if err != nil {
+		return err
+	}
+	b.AddShroudedKey(privateKey, enc.keyAlgorithm, salt, enc.iterationsFor(enc.keyAlgorithm), attributes...)
+	return nil
+}
+
+// newPFXBuilder returns a PFXBuilder with the MAC settings of enc.
+func (enc *Encoder) newPFXBuilder() (*PFXBuilder, error) {
+	salt, err := enc.salt(enc.macSaltLen())
+	if err != nil {
+		return nil, err
+	}
+	pfx := NewPFXBuilder(salt, enc.macIterationCount())
+	pfx.SetMacAlgorithm(enc.macAlgorithm)
+	pfx.SetPBMAC1Hash(enc.pbmac1Hash)
+	pfx.keys = enc.keys
+	return pfx, nil
+}
+
+// iterationsFor returns the iteration count enc encrypts with algorithm.
+func (enc *Encoder) iterationsFor(algorithm EncryptionAlgorithm) int {
+	if enc.iterations != 0 {
+		return enc.iterations
+	}
+	if _, isPBES2 := pbes2SchemeByAlg[string(algorithm)]; isPBES2 {
+		return DefaultPBES2Iterations
+	}
+	return DefaultPBES1Iterations
+}
+
+// macIterationCount returns the iteration count of the MAC key derivation
+// of enc.
+func (enc *Encoder) macIterationCount() int {
+	if enc.macIterations != 0 {
+		return enc.macIterations
+	}
+	if enc.macAlgorithm == SHA1 {
+		return DefaultPBES1Iterations
+	}
+	return DefaultSHA256MacIterations
+}
+
+// macSaltLen returns the length of the MAC salt of enc.
+func (enc *Encoder) macSaltLen() int {
+	if enc.macSaltLength > 0 {
+		return enc.macSaltLength
+	}
+	digest := enc.macAlgorithm
+	if digest == PBMAC1 {
+		digest = enc.pbmac1Hash
+	}
+	if newHash, ok := hashByName[string(digest)]; ok {
+		return newHash().Size()
+	}
+	return defaultSaltLength
+}
+
+// salt returns a new salt of length from the salt generator of enc, or the
+// salt of the first file of a ReEncryptBatch with WithBatchSalts.
+func (enc *Encoder) salt(length int) ([]byte, error) {
+	if enc.batchSalts != nil {
+		return enc.batchSalts.salt(length, enc.newSalt)
+	}
+	return enc.newSalt(length)
+}
+
+// newSalt returns a new salt of length from the salt generator of enc.
+func (enc *Encoder) newSalt(length int) ([]byte, error) {
+	if enc.generateSalt == nil {
+		return readRandomBytes(enc.rand, length)
+	}
+	salt, err := enc.generateSalt(length)
+	if err != nil {
+		return nil, err
+	}
+	if len(salt) != length {
+		return nil, fmt.Errorf("pkcs12: salt generator returned %d bytes, want %d", len(salt), length)
+	}
+	return salt, nil
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	return indexCertificate(certs, cert) >= 0
+}
+
+func indexCertificate(certs []*x509.Certificate, cert *x509.Certificate) int {
+	for i, c := range certs {
+		if c.Equal(cert) {
+			return i
+		}
+	}
+	return -1
+}
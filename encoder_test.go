@@ -0,0 +1,952 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"testing"
+)
+
+func TestDecodePBES2(t *testing.T) {
+	// created with: openssl pkcs12 -export -macalg sha1 (AES-256-CBC and hmacWithSHA256, the OpenSSL 3 defaults)
+	p12, _ := base64.StdEncoding.DecodeString(pbes2TestData)
+
+	_, c, err := Decode(p12, []byte("aes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject.CommonName != "fixture" {
+		t.Errorf("unexpected common name '%s'", c.Subject.CommonName)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	for _, algorithm := range []EncryptionAlgorithm{PBEWithSHAAnd3KeyTripleDESCBC, PBES2AES128CBC, PBES2AES256CBC} {
+		p12, err := Encode(key, cert, nil, "encode", WithKeyAlgorithm(algorithm), WithCertAlgorithm(algorithm))
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		k, c, err := Decode(p12, []byte("encode"))
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if !key.Equal(k) {
+			t.Errorf("%s: unexpected private key", algorithm)
+		}
+		if !c.Equal(cert) {
+			t.Errorf("%s: unexpected certificate", algorithm)
+		}
+	}
+
+	if _, err := Encode(key, cert, nil, "encode", WithKeyAlgorithm(pbeWithSHAAnd40BitRC4)); err == nil {
+		t.Errorf("expected encrypting with RC4 to be refused")
+	}
+}
+
+func TestEncodeKeyProviderName(t *testing.T) {
+	key, cert := testIdentity(t)
+	const provider = "Microsoft Software Key Storage Provider"
+
+	p12, err := Encode(key, cert, nil, "ksp", WithKeyProviderName(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := DecodeAll(p12, "ksp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single entry, found %d", len(entries))
+	}
+	if entries[0].KeyProviderName != provider {
+		t.Errorf("expected key provider name '%s', found '%s'", provider, entries[0].KeyProviderName)
+	}
+}
+
+func TestEncodeAttributes(t *testing.T) {
+	key, cert := testIdentity(t)
+	keyAttribute := newAttribute(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, "vendor key data", 42)
+	certAttribute := newAttribute(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}, []byte{1, 2, 3})
+
+	p12, err := Encode(key, cert, nil, "attrs", WithKeyAttributes(keyAttribute), WithCertAttributes(certAttribute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := DecodeAll(p12, "attrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single entry, found %d", len(entries))
+	}
+	attributes := entries[0].Attributes
+	if len(attributes) != 2 {
+		t.Fatalf("expected 2 attributes, found %d", len(attributes))
+	}
+	for i, expected := range []Attribute{keyAttribute, certAttribute} {
+		if !attributes[i].ID.Equal(expected.ID) || !bytes.Equal(attributes[i].Value.FullBytes, expected.Value.FullBytes) {
+			t.Errorf("expected attribute %d to be %s %x, found %s %x", i, expected.ID, expected.Value.FullBytes, attributes[i].ID, attributes[i].Value.FullBytes)
+		}
+	}
+}
+
+func TestEncodeTrustAnchors(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	other := newTestCert(t, "other", nil)
+
+	p12, err := Encode(leaf.key, leaf.cert, []*x509.Certificate{intermediate.cert, root.cert}, "trust", WithTrustAnchors(root.cert, other.cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := DecodeAll(p12, "trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, found %d", len(entries))
+	}
+	for i, expected := range []struct {
+		cert    *x509.Certificate
+		trusted bool
+	}{{leaf.cert, false}, {intermediate.cert, false}, {root.cert, true}, {other.cert, true}} {
+		e := entries[i]
+		if !e.Certificate.Equal(expected.cert) {
+			t.Errorf("expected entry %d to be '%s', found '%s'", i, expected.cert.Subject.CommonName, e.Certificate.Subject.CommonName)
+		}
+		if e.TrustAnchor != expected.trusted {
+			t.Errorf("expected entry %d to have trust anchor %v", i, expected.trusted)
+		}
+		if expected.trusted && (len(e.TrustedKeyUsage) != 1 || !e.TrustedKeyUsage[0].Equal(oidAnyExtendedKeyUsage)) {
+			t.Errorf("expected entry %d to be trusted for any extended key usage, found %v", i, e.TrustedKeyUsage)
+		}
+	}
+}
+
+func TestEncodeSortChain(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	other := newTestCert(t, "other", nil)
+
+	for _, test := range []struct {
+		caCerts, expected []*testCert
+		last              *testCert
+		unchained         int
+	}{
+		{[]*testCert{root, intermediate}, []*testCert{intermediate, root}, nil, 0},
+		{[]*testCert{root, other, intermediate}, []*testCert{intermediate, root, other}, root, 1},
+		{[]*testCert{other, intermediate}, []*testCert{intermediate, other}, intermediate, 1},
+	} {
+		var caCerts []*x509.Certificate
+		for _, c := range test.caCerts {
+			caCerts = append(caCerts, c.cert)
+		}
+		var chainErr *IncompleteChainError
+		p12, err := Encode(leaf.key, leaf.cert, caCerts, "sort", WithSortChain(), WithIncompleteChainHandler(func(err *IncompleteChainError) {
+			chainErr = err
+		}))
+		// an incomplete chain is only a warning: the PFX data is returned
+		// without an error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.last == nil && chainErr != nil {
+			t.Errorf("expected no warning, got: %v", chainErr)
+		}
+		if test.last != nil {
+			if chainErr == nil {
+				t.Fatal("expected an *IncompleteChainError")
+			}
+			if !chainErr.Last.Equal(test.last.cert) || len(chainErr.Unchained) != test.unchained {
+				t.Errorf("expected the chain to end at %q with %d certificates left, got: %v", test.last.cert.Subject, test.unchained, chainErr)
+			}
+		}
+
+		entries, err := DecodeAll(p12, "sort")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1+len(test.expected) {
+			t.Fatalf("expected %d entries, found %d", 1+len(test.expected), len(entries))
+		}
+		for i, c := range test.expected {
+			if e := entries[1+i]; !e.Certificate.Equal(c.cert) {
+				t.Errorf("expected certificate %d to be %q, found %q", i, c.cert.Subject, e.Certificate.Subject)
+			}
+		}
+	}
+
+	// the order given is kept by default
+	p12, err := Encode(leaf.key, leaf.cert, []*x509.Certificate{root.cert, intermediate.cert}, "sort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := DecodeAll(p12, "sort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || !entries[1].Certificate.Equal(root.cert) {
+		t.Errorf("expected the root to stay first without WithSortChain")
+	}
+}
+
+func TestTrustedKeyUsageRoundTrip(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(trustedKeyUsageTestData)
+	entries, err := DecodeAll(p12, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	clientAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+	if len(entries) != 1 || !entries[0].TrustAnchor {
+		t.Fatalf("expected a single trust anchor, found %+v", entries)
+	}
+	anchor := entries[0]
+	if len(anchor.TrustedKeyUsage) != 2 || !anchor.TrustedKeyUsage[0].Equal(serverAuth) || !anchor.TrustedKeyUsage[1].Equal(clientAuth) {
+		t.Fatalf("expected the anchor to be trusted for serverAuth and clientAuth, found %v", anchor.TrustedKeyUsage)
+	}
+
+	leaf := newTestCert(t, "leaf", nil)
+	p12, err = Encode(leaf.key, leaf.cert, nil, "trust", WithTrustAnchorUsage(anchor.Certificate, anchor.TrustedKeyUsage...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = DecodeAll(p12, "trust"); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[1].Certificate.Equal(anchor.Certificate) || !entries[1].TrustAnchor {
+		t.Fatalf("expected the anchor after the identity, found %d entries", len(entries))
+	}
+	if usages := entries[1].TrustedKeyUsage; len(usages) != 2 || !usages[0].Equal(serverAuth) || !usages[1].Equal(clientAuth) {
+		t.Errorf("expected the usages of the anchor to be kept, found %v", usages)
+	}
+}
+
+var pbes2TestData = `MIIGrgIBAzCCBnQGCSqGSIb3DQEHAaCCBmUEggZhMIIGXTCCAwIGCSqGSIb3DQEHBqCCAvMwggLv
+AgEAMIIC6AYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAigBYZZm884
+7wICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEFvMJSfRseYHSKrqtEJTmNCAggKA5bf5
+zkdBwsrKUdFHLFbQOgeC6IUiJgF1h37Mg9dkbbmnjMHROfcadeE1BY5ocOJKL9AXmdl+0/LIQFId
+NuklG8/cOw62RJ10BrJx6GiLa6E44t6nAGeXxXyCIIWR7bnOGQyzYzCErZh+Ac2ydj0gGBPM6iFf
+mLHTAx3oa6wkSmJfZdOWW/A/Lpf9qidpRZpT2FQRaXXxKR7G/CwG4CCtqFiuDycSTeHjRnm8zRrj
+Z5v+WMCCLFYgyrByx5Gj7SKYgIYy8wTLUw5IMxCuammdPII6olR1t+Er+okoSQOqEvhFoIE1Wpa4
+GeI4AkV3N8i6e5rEmOQMLAbGV+iAIIM6nKp6Eq8Lckp+D/m3Dl73GKd//ihQwq/4CbDkQndsd0ek
+gU7SsPULvw4G65j3P/UA4rVb/LZfjQGtUV7abM1ZzWYAQd+jDhii+zGaB5uGHbyU5avoHfWDL7De
+H6OdleyZPwIabM3GKHO5ac9Ggog/mgKnd+o7jZu7nOSNpajYGiRnAlKxhGkDdJPfhZhzkHrlZM4v
+4E7aiwhJfWaZBMX84doYZ3VrkbvCSzQ/KhpdOPstBx19ZoKpKu9p/bMrRu3tf24EkjHSyz+jqIzC
+qsD1C/1IWX7+K3NCuQweMS9bwXnSLsuGwm+uWuqjAJa8QZk0xFdQfchyK9pqkRHcQg1JpArGJkbQ
+7yQOUxFlBeJ0w0odynjqYdW6ZupoxpgYphqF/c20OpuYJsfGw08NrQUD2qp0zWRJjyh80EooS3+Q
+CCb9lQTBjXUqqiPe24B0Qckz0zqLaH0rSvFiDzKiWk/GLF9Nc6CfFf4cXisz2L7jhyEGPxERA/FP
+7py9s65xpIzbKjCCA1MGCSqGSIb3DQEHAaCCA0QEggNAMIIDPDCCAzgGCyqGSIb3DQEMCgECoIIC
+4TCCAt0wVwYJKoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECFf+/GjWH8ulAgIIADAMBggqhkiG
+9w0CCQUAMB0GCWCGSAFlAwQBKgQQy5d0at2sO4pE8ycW1wnoGgSCAoDxtUl2jnBOrkUBytTELvAm
+yHhS9QwjSv4Ync/4R2TYKhmjtCIRtETfKy35XCq6oYLhZWHUs3Az3YJIsygQmwA23oX+6BDel2bJ
+iKyenOKWXOX7G7mvUIj9IzH00tipVaI2qAAG4eSK7UoUZ6NxFhQSAPosoT5luoApTiwDWCPb0YK5
+Y6sx3i17mo3UOUizQFdcjHSVRAb4nxQ/Q8EruVBvolgbalt1r7Mld9h+htYoAcwUmiFjc7BFLo5l
+vuQJEgh18PYYXkSXq/YrPX/bzFlsqlOKPY44OiFQ9U8ajd9UZzI/t4EjKBVkCSIlo24Y9IeU3gRc
+n06oCNBzyEw+M+o1FdcHVWl+QdkBI71MHtZv9wAWvHwQIIQwBRMJWXGLMg8D1uXyEL26mBulDew8
+mQDpSZmcav+MNgHPYmu20LfVZj0eS/7zNwnvdId/qr2lSn4PcjEhAofk3IgC+DAeDVV1C2Gr3BTO
+qeQF/ul8OEeGhSko14c+UPB6x8zb5Vg0tb4Ki1QNDAhA9GjKS4/AV7jfPaR6TnkGFgDPA5BiTr7i
+7mAb4xR6+5H5xZY+7E55MffMptsdcTV8GFeW0YontcV4mn1C59qenDkGqTn5X9FgMCKl5rRY3hGi
+74l5QMGdDXSYYMn7UaXv4CZmDQgoZBBaPLs/4a47dX33v+P6AadWdt+Kv9vCDEG/SI4alFpsQDEw
+6RthILNbDAunnCP+aFrV0RdguWeVeXXzYzDAhbkHDVsYBD8arxlhMivMSCVAeCB14rl9XhMOLskv
+Z791Ssd3SG3RPgEgsmBgw298mVtsSbutPaBws74NexqMjgB1UxEHO8bqlZEZQ2yKpgx44V17MUQw
+HQYJKoZIhvcNAQkUMRAeDgBmAGkAeAB0AHUAcgBlMCMGCSqGSIb3DQEJFTEWBBQjUDwNU22BRoIz
+JzAH6fTb5CpSCzAxMCEwCQYFKw4DAhoFAAQUFB2sBxP76B1kIRV7WeGrQnsjS/EECENSPnnI3C3n
+AgIIAA==`
+
+func TestEncodeGenerateSalt(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	var salts [][]byte
+	generateSalt := func(length int) ([]byte, error) {
+		salt := bytes.Repeat([]byte{byte(0xa0 + len(salts))}, length)
+		salts = append(salts, salt)
+		return salt, nil
+	}
+	p12, err := Encode(key, cert, nil, "salt", WithGenerateSalt(generateSalt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(salts) != 3 {
+		t.Fatalf("expected salts for the certificates, the key and the MAC, generated %d", len(salts))
+	}
+	for i, salt := range salts {
+		if !bytes.Contains(p12, salt) {
+			t.Errorf("expected salt %d to be used", i)
+		}
+	}
+	if _, _, err = Decode(p12, []byte("salt")); err != nil {
+		t.Fatal(err)
+	}
+
+	short := func(length int) ([]byte, error) { return make([]byte, length-1), nil }
+	if _, err = Encode(key, cert, nil, "salt", WithGenerateSalt(short)); err == nil {
+		t.Error("expected a short salt to be rejected")
+	}
+}
+
+func TestEncodeWithRand(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	random := bytes.NewReader(bytes.Repeat([]byte{0x5a}, 2*defaultSaltLength+sha1.Size))
+	p12, err := Encode(key, cert, nil, "rand", WithRand(random))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if random.Len() != 0 {
+		t.Errorf("expected all salts to be read from the reader, %d bytes left", random.Len())
+	}
+	if _, _, err = Decode(p12, []byte("rand")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the AES IVs are read from the reader too
+	random = bytes.NewReader(bytes.Repeat([]byte{0x5a}, 2*defaultSaltLength+sha1.Size))
+	if _, err = Encode(key, cert, nil, "rand", WithRand(random), WithKeyAlgorithm(PBES2AES256CBC)); err == nil {
+		t.Error("expected an exhausted reader to fail the encoding")
+	}
+}
+
+func TestEncodeDistinctSalts(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "salts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSafe, err := AuthenticatedSafeBytes(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contentInfos []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &contentInfos); err != nil {
+		t.Fatal(err)
+	}
+	if len(contentInfos) != 2 {
+		t.Fatalf("expected 2 ContentInfos, found %d", len(contentInfos))
+	}
+
+	var certs encryptedData
+	if _, err = asn1.Unmarshal(contentInfos[0].Content.Bytes, &certs); err != nil {
+		t.Fatal(err)
+	}
+	_, certParams, err := pbeParamsFor(certs.EncryptedContentInfo.ContentEncryptionAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	var bags []rawSafeBag
+	if _, err = asn1.Unmarshal(contentInfos[1].Content.Bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = asn1.Unmarshal(data, &bags); err != nil {
+		t.Fatal(err)
+	}
+	var shroudedKey encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(bags[0].Value.Bytes, &shroudedKey); err != nil {
+		t.Fatal(err)
+	}
+	_, keyParams, err := pbeParamsFor(shroudedKey.AlgorithmIdentifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	salts := [][]byte{certParams.Salt, keyParams.Salt, pfx.MacData.MacSalt}
+	for i := range salts {
+		for j := i + 1; j < len(salts); j++ {
+			if bytes.Equal(salts[i], salts[j]) {
+				t.Errorf("expected salts %d and %d to differ, both are %x", i, j, salts[i])
+			}
+		}
+	}
+}
+
+func TestEncodeLayout(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	other := newTestCert(t, "other", nil)
+	caCerts := []*x509.Certificate{root.cert, other.cert, intermediate.cert}
+
+	for _, test := range []struct {
+		profile   Profile
+		keysFirst bool
+		caCerts   []*testCert
+	}{
+		{ProfileOpenSSL, false, []*testCert{root, other, intermediate}},
+		{ProfileWindows, true, []*testCert{root, other, intermediate}},
+		{ProfileJava, false, []*testCert{intermediate, root, other}},
+	} {
+		p12, err := Encode(leaf.key, leaf.cert, caCerts, "layout", WithLayout(test.profile))
+		if err != nil {
+			t.Fatalf("profile %d: %v", test.profile, err)
+		}
+
+		authSafe, err := AuthenticatedSafeBytes(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contentInfos []contentInfo
+		if _, err = asn1.Unmarshal(authSafe, &contentInfos); err != nil {
+			t.Fatal(err)
+		}
+		if keysFirst := contentInfos[0].ContentType.Equal(oidDataContentType); keysFirst != test.keysFirst {
+			t.Errorf("profile %d: expected the keys first: %v", test.profile, test.keysFirst)
+		}
+
+		entries, err := DecodeAll(p12, "layout")
+		if err != nil {
+			t.Fatalf("profile %d: %v", test.profile, err)
+		}
+		if len(entries) != 4 || !entries[0].Certificate.Equal(leaf.cert) || entries[0].PrivateKey == nil {
+			t.Fatalf("profile %d: expected the leaf identity followed by 3 certificates", test.profile)
+		}
+		for i, c := range test.caCerts {
+			if e := entries[i+1]; !e.Certificate.Equal(c.cert) {
+				t.Errorf("profile %d: expected certificate %d to be '%s', found '%s'", test.profile, i, c.cert.Subject.CommonName, e.Certificate.Subject.CommonName)
+			}
+		}
+	}
+}
+
+func TestEncodeKeyProtection(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	for _, test := range []struct {
+		protection  KeyProtection
+		bagType     asn1.ObjectIdentifier
+		contentType asn1.ObjectIdentifier
+	}{
+		{ShroudedKeyBag, oidPkcs8ShroudedKeyBagType, oidDataContentType},
+		{EncryptedKeyBag, oidKeyBagType, oidEncryptedDataContentType},
+		{EncryptedShroudedKeyBag, oidPkcs8ShroudedKeyBagType, oidEncryptedDataContentType},
+	} {
+		p12, err := Encode(key, cert, nil, "protect", WithKeyProtection(test.protection))
+		if err != nil {
+			t.Fatalf("protection %d: %v", test.protection, err)
+		}
+
+		authSafe, err := AuthenticatedSafeBytes(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contentInfos []contentInfo
+		if _, err = asn1.Unmarshal(authSafe, &contentInfos); err != nil {
+			t.Fatal(err)
+		}
+		if !contentInfos[1].ContentType.Equal(test.contentType) {
+			t.Errorf("protection %d: expected the key in a ContentInfo of type %v, found %v", test.protection, test.contentType, contentInfos[1].ContentType)
+		}
+		bags, _, _, err := (&DecodeOptions{Password: passwordString("protect")}).getSafeContents(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bags[1].ID.Equal(test.bagType) {
+			t.Errorf("protection %d: expected a bag of type %v, found %v", test.protection, test.bagType, bags[1].ID)
+		}
+
+		k, c, err := Decode(p12, []byte("protect"))
+		if err != nil {
+			t.Fatalf("protection %d: %v", test.protection, err)
+		}
+		if !key.Equal(k) || !c.Equal(cert) {
+			t.Errorf("protection %d: expected the test identity to round-trip", test.protection)
+		}
+		der, err := ExtractPrivateKeyDER(p12, "protect")
+		if err != nil {
+			t.Fatalf("protection %d: %v", test.protection, err)
+		}
+		if expected, _ := x509.MarshalPKCS8PrivateKey(key); !bytes.Equal(der, expected) {
+			t.Errorf("protection %d: expected the PKCS#8 encoding of the key", test.protection)
+		}
+	}
+}
+
+func TestEncodeEmptyPassword(t *testing.T) {
+	key, cert := testIdentity(t)
+	for _, algorithm := range []EncryptionAlgorithm{PBEWithSHAAnd3KeyTripleDESCBC, PBES2AES256CBC} {
+		p12, err := Encode(key, cert, nil, "", WithKeyAlgorithm(algorithm), WithCertAlgorithm(algorithm))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pfx, authSafe, err := parsePfx(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = verifyMac(&pfx.MacData, authSafe, []byte{0, 0}); err != nil {
+			t.Errorf("%v: MAC is not computed with the terminated empty password: %v", algorithm, err)
+		}
+
+		var metadata Metadata
+		opts := DecodeOptions{Password: passwordString(""), Metadata: &metadata}
+		decodedKey, decodedCert, err := opts.Decode(p12)
+		if err != nil {
+			t.Fatalf("%v: %v", algorithm, err)
+		}
+		if !metadata.PasswordNullTerminated {
+			t.Errorf("%v: password was not used with its NULL terminator", algorithm)
+		}
+		if !key.Equal(decodedKey) || !cert.Equal(decodedCert) {
+			t.Errorf("%v: decoded a different identity", algorithm)
+		}
+	}
+}
+
+func TestEncodeFriendlyName(t *testing.T) {
+	key, cert := testIdentity(t)
+	for _, test := range []struct {
+		opts              []EncodeOption
+		keyName, certName string
+		entryName         string
+	}{
+		{nil, "", "", ""},
+		{[]EncodeOption{WithFriendlyName("both")}, "both", "both", "both"},
+		{[]EncodeOption{WithFriendlyName("key"), WithCertFriendlyName("cert")}, "key", "cert", "key"},
+		{[]EncodeOption{WithCertFriendlyName("cert")}, "", "cert", "cert"},
+	} {
+		p12, err := Encode(key, cert, nil, "names", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bags, _, _, err := (&DecodeOptions{Password: passwordString("names")}).getSafeContents(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bag := range bags {
+			attributes, err := decodeBagAttributes(bag.Attributes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := test.certName
+			if isKeyBag(bag.ID) {
+				expected = test.keyName
+			}
+			if attributes.friendlyName != expected {
+				t.Errorf("%q/%q: expected bag %v to be named %q, found %q", test.keyName, test.certName, bag.ID, expected, attributes.friendlyName)
+			}
+		}
+
+		entries, err := DecodeAll(p12, "names")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].FriendlyName != test.entryName || entries[0].CertFriendlyName != test.certName {
+			t.Errorf("%q/%q: unexpected entries %+v", test.keyName, test.certName, entries)
+		}
+	}
+}
+
+func TestEncodeCertificate(t *testing.T) {
+	_, cert := testIdentity(t)
+	for _, test := range []struct {
+		opts []EncodeOption
+		name string
+	}{
+		{nil, cert.Subject.CommonName},
+		{[]EncodeOption{WithCertFriendlyName("named")}, "named"},
+	} {
+		p12, err := EncodeCertificate(cert, "cert", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyMAC(p12, "cert"); err != nil {
+			t.Error(err)
+		}
+		_, authSafe, err := parsePfx(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var authenticatedSafe []contentInfo
+		if _, err = asn1.Unmarshal(authSafe, &authenticatedSafe); err != nil || len(authenticatedSafe) != 1 {
+			t.Fatalf("expected a single ContentInfo, got err: %v", err)
+		}
+
+		entries, err := DecodeAll(p12, "cert")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].PrivateKey != nil || !entries[0].Certificate.Equal(cert) {
+			t.Fatalf("expected the certificate alone, found %+v", entries)
+		}
+		if entries[0].CertFriendlyName != test.name || len(entries[0].LocalKeyID) != 0 {
+			t.Errorf("expected the certificate to be named %q without a localKeyId, found %q and %x", test.name, entries[0].CertFriendlyName, entries[0].LocalKeyID)
+		}
+
+		key, certificate, caCerts, err := DecodeChain(p12, "cert")
+		if err != nil || key != nil || !certificate.Equal(cert) || len(caCerts) != 0 {
+			t.Errorf("expected DecodeChain to return the certificate without a key, got err: %v", err)
+		}
+		if _, _, err = ToPEMBundle(p12, "cert"); err == nil {
+			t.Error("expected ToPEMBundle to require a private key")
+		}
+	}
+
+	if _, err := EncodeCertificate(nil, "cert"); err == nil {
+		t.Error("expected a missing certificate to fail")
+	}
+}
+
+func TestEncodeEncryptedPKCS8Key(t *testing.T) {
+	key, cert := testIdentity(t)
+	aes := WithKeyAlgorithm(PBES2AES256CBC)
+	encrypted, err := EncryptPKCS8(key, "hsm", aes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p12, err := Encode(EncryptedPKCS8Key(encrypted), cert, nil, "hsm", aes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bags, _, _, err := (&DecodeOptions{Password: passwordString("hsm")}).getSafeContents(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bag := range bags {
+		if bag.ID.Equal(oidPkcs8ShroudedKeyBagType) && !bytes.Equal(bag.Value.Bytes, encrypted) {
+			t.Error("expected the encrypted key to be stored as it is")
+		}
+	}
+	privateKey, certificate, err := Decode(p12, []byte("hsm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := privateKey.(*rsa.PrivateKey); !ok || !k.Equal(key) || !certificate.Equal(cert) {
+		t.Error("expected the encoded identity")
+	}
+
+	if _, err = Encode(EncryptedPKCS8Key(encrypted), cert, nil, "hsm"); err == nil {
+		t.Error("expected a key encrypted with another algorithm than the key algorithm to be refused")
+	}
+	if _, err = Encode(EncryptedPKCS8Key(encrypted), cert, nil, "hsm", WithAnyEncryptedKeyAlgorithm()); err != nil {
+		t.Errorf("expected any algorithm to be accepted, got err: %v", err)
+	}
+	if p12, err = Encode(EncryptedPKCS8Key(encrypted), cert, nil, "hsm", aes, WithKeyProtection(EncryptedShroudedKeyBag)); err != nil {
+		t.Error(err)
+	} else if _, _, err = Decode(p12, []byte("hsm")); err != nil {
+		t.Errorf("expected the encrypted key to decode within an encryptedData ContentInfo, got err: %v", err)
+	}
+	if _, err = Encode(EncryptedPKCS8Key(encrypted), cert, nil, "hsm", aes, WithKeyProtection(EncryptedKeyBag)); err == nil {
+		t.Error("expected an encrypted key to be refused in a keyBag")
+	}
+	if _, err = Encode(EncryptedPKCS8Key("garbage"), cert, nil, "hsm", aes); err == nil {
+		t.Error("expected garbage to be refused")
+	}
+}
+
+func TestEncodeLocalKeyIDScheme(t *testing.T) {
+	key, cert := testIdentity(t)
+	certHash := sha1.Sum(cert.Raw)
+	publicKeyHash := sha1.Sum(x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	guid := []byte("{6D1C8A2E-4F0B-4C3A-9E7D-2B5F8A1C0E94}")
+
+	for name, test := range map[string]struct {
+		opts     []EncodeOption
+		expected []byte
+	}{
+		"default":    {nil, certHash[:]},
+		"CertSHA1":   {[]EncodeOption{WithLocalKeyIDScheme(CertSHA1)}, certHash[:]},
+		"PubKeySHA1": {[]EncodeOption{WithLocalKeyIDScheme(PubKeySHA1)}, publicKeyHash[:]},
+		"Explicit":   {[]EncodeOption{WithLocalKeyIDScheme(Explicit(guid))}, guid},
+	} {
+		p12, err := Encode(key, cert, nil, "scheme", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bags, _, _, err := (&DecodeOptions{Password: passwordString("scheme")}).getSafeContents(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bag := range bags {
+			attributes, err := decodeBagAttributes(bag.Attributes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(attributes.localKeyID, test.expected) {
+				t.Errorf("%s: expected bag %v to have localKeyId %x, found %x", name, bag.ID, test.expected, attributes.localKeyID)
+			}
+		}
+	}
+
+	for _, id := range [][]byte{nil, {}} {
+		if _, err := Encode(key, cert, nil, "scheme", WithLocalKeyIDScheme(Explicit(id))); err == nil {
+			t.Errorf("expected an empty explicit localKeyId %#v to be refused", id)
+		}
+	}
+}
+
+func TestEncodeMacSaltLength(t *testing.T) {
+	key, cert := testIdentity(t)
+	for _, test := range []struct {
+		opts   []EncodeOption
+		length int
+	}{
+		{nil, 20},
+		{[]EncodeOption{WithMacAlgorithm(SHA256)}, 32},
+		{[]EncodeOption{WithMacAlgorithm(PBMAC1)}, 32},
+		{[]EncodeOption{WithMacAlgorithm(PBMAC1), WithPBMAC1Hash(SHA1)}, 20},
+		{[]EncodeOption{WithMacAlgorithm(SHA256), WithMacSaltLength(defaultSaltLength)}, defaultSaltLength},
+	} {
+		p12, err := Encode(key, cert, nil, "salt length", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ParseMacData(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(info.Salt) != 2*test.length {
+			t.Errorf("%v: expected a MAC salt of %d bytes, found %s", info.DigestAlgorithm, test.length, info.Salt)
+		}
+		if err = VerifyMAC(p12, "salt length"); err != nil {
+			t.Errorf("%v: %v", info.DigestAlgorithm, err)
+		}
+	}
+}
+
+func TestEncodeVerifyAfterEncode(t *testing.T) {
+	key, cert := testIdentity(t)
+	root := newTestCert(t, "root", nil)
+	encrypted, err := EncryptPKCS8(key, "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, opts := range map[string][]EncodeOption{
+		"default":          nil,
+		"PBES2 and PBMAC1": {WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES128CBC), WithMacAlgorithm(PBMAC1)},
+		"keyBag":           {WithKeyProtection(EncryptedKeyBag), WithLayout(ProfileWindows)},
+		"trust anchors":    {WithTrustAnchors(newTestCert(t, "anchor", nil).cert), WithLayout(ProfileJava)},
+	} {
+		if _, err := Encode(key, cert, []*x509.Certificate{root.cert}, "verify", append(opts, WithVerifyAfterEncode())...); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err = Encode(EncryptedPKCS8Key(encrypted), cert, nil, "verify", WithVerifyAfterEncode()); err != nil {
+		t.Errorf("encrypted key: %v", err)
+	}
+	if _, err = EncodeCertificate(cert, "verify", WithVerifyAfterEncode()); err != nil {
+		t.Errorf("certificate: %v", err)
+	}
+
+	p12, err := Encode(key, cert, nil, "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyEncoded(p12, "verify", key, cert, nil); err != nil {
+		t.Error(err)
+	}
+	if err = verifyEncoded(p12, "verify", root.key, cert, nil); err == nil {
+		t.Error("expected another private key not to be found")
+	}
+	if err = verifyEncoded(p12, "verify", key, cert, []*x509.Certificate{root.cert}); err == nil {
+		t.Error("expected a missing CA certificate not to be found")
+	}
+	if err = verifyEncoded(p12, "other", key, cert, nil); err == nil {
+		t.Error("expected another password not to decode")
+	}
+}
+
+func TestEncoderPlan(t *testing.T) {
+	plan := NewEncoder().Plan()
+	expected := EncodePlan{
+		KeyAlgorithm:   PBEWithSHAAnd3KeyTripleDESCBC,
+		KeyProtection:  ShroudedKeyBag,
+		CertAlgorithm:  PBEWithSHAAnd40BitRC2CBC,
+		Iterations:     2048,
+		CertIterations: 2048,
+		SaltLength:     defaultSaltLength,
+		MacAlgorithm:   SHA1,
+		MacDigest:      SHA1,
+		MacIterations:  2048,
+		MacSaltLength:  20,
+	}
+	if plan != expected {
+		t.Errorf("expected the default plan %+v, found %+v", expected, plan)
+	}
+
+	enc := NewEncoder(WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES128CBC), WithKeyProtection(EncryptedKeyBag),
+		WithMacAlgorithm(PBMAC1), WithPBMAC1Hash(SHA256), WithIterations(4096), WithMacIterations(20000))
+	plan = enc.Plan()
+	expected = EncodePlan{
+		KeyAlgorithm:   PBES2AES256CBC,
+		KeyProtection:  EncryptedKeyBag,
+		CertAlgorithm:  PBES2AES128CBC,
+		Iterations:     4096,
+		CertIterations: 4096,
+		SaltLength:     defaultSaltLength,
+		MacAlgorithm:   PBMAC1,
+		MacDigest:      SHA256,
+		MacIterations:  20000,
+		MacSaltLength:  32,
+	}
+	if plan != expected {
+		t.Errorf("expected %+v, found %+v", expected, plan)
+	}
+
+	// the plan matches what is encoded
+	key, cert := testIdentity(t)
+	p12, err := enc.Encode(key, cert, nil, "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ParseMacData(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Iterations != plan.MacIterations || len(info.Salt) != 2*plan.MacSaltLength {
+		t.Errorf("expected the MAC to follow the plan, found %+v", info)
+	}
+}
+
+func TestEncodeDefaultIterations(t *testing.T) {
+	key, cert := testIdentity(t)
+	for _, test := range []struct {
+		opts           []EncodeOption
+		key, cert, mac int
+	}{
+		{nil, DefaultPBES1Iterations, DefaultPBES1Iterations, DefaultPBES1Iterations},
+		{[]EncodeOption{WithMacAlgorithm(SHA256)}, DefaultPBES1Iterations, DefaultPBES1Iterations, DefaultSHA256MacIterations},
+		{[]EncodeOption{WithMacAlgorithm(PBMAC1)}, DefaultPBES1Iterations, DefaultPBES1Iterations, DefaultSHA256MacIterations},
+		{[]EncodeOption{WithKeyAlgorithm(PBES2AES256CBC)}, DefaultPBES2Iterations, DefaultPBES1Iterations, DefaultPBES1Iterations},
+		{[]EncodeOption{WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES128CBC), WithMacAlgorithm(SHA256)},
+			DefaultPBES2Iterations, DefaultPBES2Iterations, DefaultSHA256MacIterations},
+		{[]EncodeOption{WithKeyAlgorithm(PBES2AES256CBC), WithIterations(1000), WithMacAlgorithm(SHA256), WithMacIterations(3000)}, 1000, 1000, 3000},
+	} {
+		enc := NewEncoder(test.opts...)
+		plan := enc.Plan()
+		if plan.Iterations != test.key || plan.CertIterations != test.cert || plan.MacIterations != test.mac {
+			t.Errorf("%s, %s, %s: expected %d, %d and %d iterations, found %+v", plan.KeyAlgorithm, plan.CertAlgorithm, plan.MacAlgorithm, test.key, test.cert, test.mac, plan)
+			continue
+		}
+
+		p12, err := enc.Encode(key, cert, nil, "iterations")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts, err := EncryptedParts(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range parts {
+			expected := test.cert
+			if part.Kind == ShroudedKey {
+				expected = test.key
+			}
+			if n := encryptionIterations(t, part.Algorithm); n != expected {
+				t.Errorf("%s: expected %d iterations for part %d, found %d", plan.KeyAlgorithm, expected, part.ContentInfoIndex, n)
+			}
+		}
+		info, err := ParseMacData(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Iterations != test.mac {
+			t.Errorf("%s: expected %d MAC iterations, found %d", plan.MacAlgorithm, test.mac, info.Iterations)
+		}
+	}
+}
+
+func TestEncodeIterations(t *testing.T) {
+	key, cert := testIdentity(t)
+	// counts whose encoding takes a leading zero byte, and one above 65535
+	for _, iterations := range []int{200, 50000, 100000} {
+		for _, algorithm := range []EncryptionAlgorithm{PBEWithSHAAnd3KeyTripleDESCBC, PBES2AES256CBC} {
+			p12, err := Encode(key, cert, nil, "iterations", WithKeyAlgorithm(algorithm), WithCertAlgorithm(algorithm), WithIterations(iterations))
+			if err != nil {
+				t.Fatalf("%s, %d iterations: %v", algorithm, iterations, err)
+			}
+			parts, err := EncryptedParts(p12)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, part := range parts {
+				if n := encryptionIterations(t, part.Algorithm); n != iterations {
+					t.Errorf("%s: expected %d iterations, found %d", algorithm, iterations, n)
+				}
+			}
+			if _, _, err = Decode(p12, []byte("iterations")); err != nil {
+				t.Errorf("%s, %d iterations: %v", algorithm, iterations, err)
+			}
+		}
+	}
+}
+
+func TestEncodeMacIterations(t *testing.T) {
+	key, cert := testIdentity(t)
+	for _, iterations := range []int{200, 100000} {
+		for _, algorithm := range []MacAlgorithm{SHA1, SHA256, PBMAC1} {
+			p12, err := Encode(key, cert, nil, "mac", WithMacAlgorithm(algorithm), WithMacIterations(iterations))
+			if err != nil {
+				t.Fatalf("%s, %d iterations: %v", algorithm, iterations, err)
+			}
+			info, err := ParseMacData(p12)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Iterations != iterations {
+				t.Errorf("%s: expected %d MAC iterations, found %d", algorithm, iterations, info.Iterations)
+			}
+			if _, _, err = Decode(p12, []byte("mac")); err != nil {
+				t.Errorf("%s, %d iterations: %v", algorithm, iterations, err)
+			}
+		}
+	}
+}
+
+// encryptionIterations returns the iteration count of the PBES1 or PBES2
+// algorithm.
+func encryptionIterations(t *testing.T, algorithm pkix.AlgorithmIdentifier) int {
+	if !algorithm.Algorithm.Equal(oidPBES2) {
+		_, params, err := pbeParamsFor(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return params.Iterations
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		t.Fatal(err)
+	}
+	return kdfParams.Iterations
+}
+
+// trustedKeyUsageTestData is a truststore, for the password "changeit",
+// holding the self-signed CA certificate "Trusted Server CA", whose
+// extendedKeyUsage is serverAuth and clientAuth, laid out as keytool
+// -importcert writes one: encrypted with PBES2, AES-256-CBC and 10000
+// iterations under a SHA-256 MAC, with the friendlyName "trusted server ca"
+// and a trustedKeyUsage of the extended key usages of the certificate. It was
+// built with the PFXBuilder, as no keytool was at hand; openssl pkcs12
+// -info reads it.
+var trustedKeyUsageTestData = `MIIDBwIBAzCCAr0GCSqGSIb3DQEHAaCCAq4EggKqMIICpjCCAqIGCSqGSIb3DQEHBqCCApMwggKP
+AgEAMIICiAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjUx+ybAW3Z
+JQICJxAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEMBoTFSBxFBcR21oAb24kE2AggIg6+N9
+cAha+zhzooGgeTacEjZxJeOS/a33HcAIowChiQM8qgTzrkpP9qun927NvgtpFDKDKtYJlRF+bLNs
+vTRHtmTZzTHEG1NbhSuqxq6EecCGp/qV7Y7VQ45nhwklagrkk+iIqLvQzWSTGLZAhB9XmomfVf1j
+5jBT0VlsROyXeqef+VLcmVFlu+0/KnTVqJBchr4T7C8SzLWFxVAMCZE+TCY8/22CiQEP+qJxpCz8
+HYdKEmgfaldeF8bC/goNfdVTdpIGdvNMPPhSb/JjOB2BCZFQ10/8f0pYMnjJoOHdwnSLzeLpPq4P
+XMbdZ+77ywyKL4uNtUnpEl8Xs2KmpyYDAiRrYqza42095UrKiCtW/dbVlFVD3XmesWHdgoxJzk8u
+MVtjGizipggqIt7bv+V+AcqT1GHnO6GMrGQ6VMESSGFAazc45JNo5DgjXersdGASIedW0r95NKqa
+QG/ln7pAP/TSM+89tqRSpyoNl55TT+TY8zOQB07bjDB7Kq/zYvAu0skEDXNpzhc2kAShyo9yhvv1
+Uc426kudzZm1sFj62MywJjUmj/qegk0xVuI2eM1Uoaa79qKAGFQe/20CvU0daJWDSrYMZibR8KXH
+NtqbgrfNa6AATgKbd51pCapY1/5Rssz48PNaA+uHLJSsG8kk0BsS3rsbUqkfMoi+qCDRAMqT3iMX
+EG483aTmx18aAGGOT6YVzsl4ar38pli0xrfMdjBBMDEwDQYJYIZIAWUDBAIBBQAEIPZUqOutBZLz
+3ZC1ccsvpqIrMMeKWK+9s4m07B6ITshwBAjWm/Z67x3JygICJxA=`
@@ -0,0 +1,401 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+)
+
+// Entry is a private key and/or certificate stored in a PFX, along with the
+// attributes of the bags it was decoded from. A private key and a certificate
+// are paired into one Entry when their bags carry the same localKeyId, or
+// failing that, when the certificate holds the public key of the private key.
+type Entry struct {
+	PrivateKey  interface{}
+	Certificate *x509.Certificate
+
+	// FriendlyName is the friendlyName of the key bag, or if it has none,
+	// of the certificate bag. CertFriendlyName is that of the certificate
+	// bag alone, which some files set differently from the key's. Names
+	// end at their first NULL, as Windows displays them.
+	FriendlyName     string
+	CertFriendlyName string
+
+	LocalKeyID []byte
+
+	// KeyProviderName is the Microsoft CSP or KSP the private key belongs
+	// to, if its bag names one.
+	KeyProviderName string
+
+	// TrustAnchor reports whether the certificate is marked as trusted with
+	// the Oracle trustedKeyUsage attribute, for the usages listed in
+	// TrustedKeyUsage. Java keytool reads such certificates as
+	// trustedCertEntry, and writes the extended key usages of the
+	// certificate, or anyExtendedKeyUsage if it has none. Encode writes them
+	// back with WithTrustAnchorUsage.
+	TrustAnchor     bool
+	TrustedKeyUsage []asn1.ObjectIdentifier
+
+	// Attributes holds the bag attributes that are not decoded into the
+	// fields above, verbatim. Those of the key bag come before those of the
+	// certificate bag.
+	Attributes []Attribute
+
+	// KeyMismatch reports whether the private key carried the localKeyId of
+	// a certificate that does not hold its public key, as in a corrupt or
+	// mis-assembled file, such as an EC certificate for an RSA key. That
+	// certificate is then returned as an entry of its own rather than
+	// paired with the key, which is left with no certificate unless another
+	// one holds its public key.
+	KeyMismatch bool
+}
+
+// Fingerprints returns the SHA-1 and SHA-256 hashes of the DER encoding of
+// the certificate of e, in lowercase hex, or empty strings if e has no
+// certificate.
+func (e Entry) Fingerprints() (sha1Hex, sha256Hex string) {
+	if e.Certificate == nil {
+		return "", ""
+	}
+	sha1Digest := sha1.Sum(e.Certificate.Raw)
+	sha256Digest := sha256.Sum256(e.Certificate.Raw)
+	return hex.EncodeToString(sha1Digest[:]), hex.EncodeToString(sha256Digest[:])
+}
+
+// DecodeAll extracts all private keys and certificates from pfxData.
+// Entries holding a private key come first, followed by the certificates
+// that were not paired with a key, both in the order they appear in pfxData.
+func DecodeAll(pfxData []byte, password string) ([]Entry, error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.DecodeAll(pfxData)
+}
+
+// DecodeAll is like the package-level DecodeAll, but obtains the password
+// from opts.Password.
+func (opts *DecodeOptions) DecodeAll(pfxData []byte) ([]Entry, error) {
+	bags, decrypted, p, err := opts.getSafeContents(pfxData)
+	defer wipe(p, decrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := opts.decodeEntries(bags, p)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].detach()
+	}
+	return entries, nil
+}
+
+// detach copies the attributes of e, which are kept verbatim and so refer to
+// the decrypted SafeContents they were decoded from, so that those can be
+// wiped. Everything else is copied out as it is decoded.
+func (e *Entry) detach() {
+	if e.Attributes != nil {
+		e.Attributes = append([]Attribute(nil), e.Attributes...)
+	}
+	for i := range e.Attributes {
+		value := &e.Attributes[i].Value
+		value.Bytes = append([]byte(nil), value.Bytes...)
+		value.FullBytes = append([]byte(nil), value.FullBytes...)
+	}
+}
+
+// AttributeOIDs returns the distinct OIDs of the attributes of all bags in
+// pfxData, in the order they first appear.
+func AttributeOIDs(pfxData []byte, password string) ([]asn1.ObjectIdentifier, error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	bags, decrypted, p, err := opts.getSafeContents(pfxData)
+	defer wipe(p, decrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	var oids []asn1.ObjectIdentifier
+	seen := make(map[string]bool)
+	for _, bag := range bags {
+		for _, attribute := range bag.Attributes {
+			if !seen[attribute.ID.String()] {
+				seen[attribute.ID.String()] = true
+				oids = append(oids, attribute.ID)
+			}
+		}
+	}
+	return oids, nil
+}
+
+func (opts *DecodeOptions) decodeEntries(bags []safeBag, password []byte) ([]Entry, error) {
+	d, err := opts.newDecoder(bags, nil, password)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(d.entries))
+	var mismatched []Entry
+	for _, e := range d.entries {
+		entry, cert, err := e.decode()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		if cert != nil {
+			mismatched = append(mismatched, *cert)
+		}
+	}
+	return pairByPublicKey(append(entries, mismatched...)), nil
+}
+
+// DecodeFunc extracts the private keys and certificates of pfxData, as
+// DecodeAll does, but calls fn with each entry as soon as it is decoded
+// rather than returning them all, so that keystores of many certificates are
+// never held in memory at once. See DecodeOptions.DecodeFunc.
+func DecodeFunc(pfxData []byte, password string, fn func(Entry) error) error {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.DecodeFunc(pfxData, fn)
+}
+
+// DecodeFunc is like the package-level DecodeFunc, but obtains the password
+// from opts.Password. The entries are those of DecodeAll, in its order,
+// except that a private key not paired with a certificate by localKeyId is
+// held back until a certificate holding its public key is reached, or else
+// until the end. Decoding stops at the first error fn returns, which
+// DecodeFunc returns.
+func (opts *DecodeOptions) DecodeFunc(pfxData []byte, fn func(Entry) error) error {
+	d, err := opts.NewDecoder(pfxData)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// private keys with no certificate are held back until one holding
+	// their public key is reached, as DecodeAll pairs them
+	var unpaired []Entry
+	deliver := func(entry Entry) error {
+		if entry.PrivateKey != nil && entry.Certificate == nil {
+			unpaired = append(unpaired, entry)
+			return nil
+		}
+		if entry.PrivateKey == nil {
+			for i := range unpaired {
+				if publicKeyMatches(unpaired[i].PrivateKey, entry.Certificate) {
+					key := unpaired[i]
+					key.addCertificate(&entry)
+					unpaired = append(unpaired[:i], unpaired[i+1:]...)
+					return fn(key)
+				}
+			}
+		}
+		return fn(entry)
+	}
+
+	for i, e := range d.entries {
+		entry, cert, err := e.decode()
+		d.entries[i] = nil // so that what fn does not keep can be collected
+		if err != nil {
+			return err
+		}
+		if err = deliver(entry); err != nil {
+			return err
+		}
+		if cert != nil {
+			if err = deliver(*cert); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range unpaired {
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode decodes e into an Entry. If the private key and certificate of e
+// were paired by localKeyId but do not match, the certificate is returned as
+// an entry of its own, as by splitMismatch.
+func (e *LazyEntry) decode() (entry Entry, mismatched *Entry, err error) {
+	entry = Entry{
+		FriendlyName:     e.FriendlyName,
+		CertFriendlyName: e.CertFriendlyName,
+		LocalKeyID:       e.LocalKeyID,
+		KeyProviderName:  e.KeyProviderName,
+		TrustAnchor:      e.TrustAnchor,
+		TrustedKeyUsage:  e.TrustedKeyUsage,
+		Attributes:       e.Attributes,
+	}
+	if entry.PrivateKey, err = e.PrivateKey(); err != nil {
+		return Entry{}, nil, err
+	}
+	if entry.Certificate, err = e.Certificate(); err != nil {
+		return Entry{}, nil, err
+	}
+	if entry.PrivateKey != nil && entry.Certificate != nil {
+		if public, ok := comparablePublicKey(entry.PrivateKey); ok && !public.Equal(entry.Certificate.PublicKey) {
+			cert, err := e.splitMismatch(&entry)
+			if err != nil {
+				return Entry{}, nil, err
+			}
+			return entry, &cert, nil
+		}
+	}
+	return entry, nil, nil
+}
+
+// splitMismatch takes the certificate that was paired with the private key
+// of entry by localKeyId, but does not hold its public key, out of entry, with
+// the attributes of its bag, and returns it as an entry of its own.
+func (e *LazyEntry) splitMismatch(entry *Entry) (Entry, error) {
+	keyAttributes, err := decodeBagAttributes(e.keyBag.Attributes)
+	if err != nil {
+		return Entry{}, e.keyBag.wrapError(err)
+	}
+	certAttributes, err := decodeBagAttributes(e.certBag.Attributes)
+	if err != nil {
+		return Entry{}, e.certBag.wrapError(err)
+	}
+
+	cert := Entry{
+		Certificate:      entry.Certificate,
+		FriendlyName:     certAttributes.friendlyName,
+		CertFriendlyName: certAttributes.friendlyName,
+		LocalKeyID:       certAttributes.localKeyID,
+		TrustAnchor:      certAttributes.trusted,
+		TrustedKeyUsage:  certAttributes.trustedKeyUsage,
+		Attributes:       certAttributes.other,
+	}
+	entry.Certificate = nil
+	entry.FriendlyName = keyAttributes.friendlyName
+	entry.CertFriendlyName = ""
+	entry.TrustAnchor = keyAttributes.trusted
+	entry.TrustedKeyUsage = keyAttributes.trustedKeyUsage
+	entry.Attributes = keyAttributes.other
+	entry.KeyMismatch = true
+	return cert, nil
+}
+
+// pairByPublicKey pairs the entries holding a private key but no certificate
+// with the first unpaired certificate of the same public key, the way
+// newDecoder pairs them by localKeyId.
+func pairByPublicKey(entries []Entry) []Entry {
+	for i := 0; i < len(entries); i++ {
+		if entries[i].PrivateKey == nil || entries[i].Certificate != nil {
+			continue
+		}
+		for j := i + 1; j < len(entries); j++ {
+			cert := &entries[j]
+			if cert.PrivateKey != nil || !publicKeyMatches(entries[i].PrivateKey, cert.Certificate) {
+				continue
+			}
+			entries[i].addCertificate(cert)
+			entries = append(entries[:j], entries[j+1:]...)
+			break
+		}
+	}
+	return entries
+}
+
+// addCertificate pairs the private key of e with the certificate of the
+// entry cert, taking its attributes.
+func (e *Entry) addCertificate(cert *Entry) {
+	e.Certificate = cert.Certificate
+	e.CertFriendlyName = cert.CertFriendlyName
+	if e.FriendlyName == "" {
+		e.FriendlyName = cert.FriendlyName
+	}
+	if !e.TrustAnchor {
+		e.TrustAnchor, e.TrustedKeyUsage = cert.TrustAnchor, cert.TrustedKeyUsage
+	}
+	e.Attributes = append(e.Attributes, cert.Attributes...)
+}
+
+// publicKeyMatches reports whether cert holds the public key of privateKey.
+// Keys of different types, or that cannot be compared, never match.
+func publicKeyMatches(privateKey interface{}, cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+	public, ok := comparablePublicKey(privateKey)
+	return ok && public.Equal(cert.PublicKey)
+}
+
+// comparablePublicKey returns the public key of privateKey, if it has one
+// that can be compared to the public key of a certificate. Equal is then
+// false, rather than panicking, for public keys of another type.
+func comparablePublicKey(privateKey interface{}) (interface{ Equal(crypto.PublicKey) bool }, bool) {
+	signer, ok := privateKey.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return nil, false
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return public, ok
+}
+
+func findLocalKeyID(entries []*LazyEntry, id []byte) int {
+	if len(id) == 0 {
+		return -1
+	}
+	for i, e := range entries {
+		if bytes.Equal(e.LocalKeyID, id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// bagAttributes are the attributes of a safe bag that entries expose.
+type bagAttributes struct {
+	friendlyName    string
+	localKeyID      []byte
+	keyProviderName string
+	trustedKeyUsage []asn1.ObjectIdentifier
+	trusted         bool
+	other           []Attribute
+}
+
+// decodeBagAttributes decodes the attributes it knows about, and keeps the
+// rest as they are.
+func decodeBagAttributes(attributes []pkcs12Attribute) (a bagAttributes, err error) {
+	for _, attribute := range attributes {
+		switch {
+		case attribute.ID.Equal(oidFriendlyName), attribute.ID.Equal(oidMicrosoftCSPName):
+			var value asn1.RawValue
+			if _, err = asn1.Unmarshal(attribute.Value.Bytes, &value); err != nil {
+				return
+			}
+			var name string
+			if name, err = decodeBMPName(value.Bytes); err != nil {
+				return
+			}
+			if attribute.ID.Equal(oidFriendlyName) {
+				a.friendlyName = name
+			} else {
+				a.keyProviderName = name
+			}
+		case attribute.ID.Equal(oidLocalKeyID):
+			if _, err = asn1.Unmarshal(attribute.Value.Bytes, &a.localKeyID); err != nil {
+				return a, errors.New("pkcs12: error decoding localKeyId attribute: " + err.Error())
+			}
+		case attribute.ID.Equal(oidTrustedKeyUsage):
+			rest := attribute.Value.Bytes
+			for len(rest) > 0 {
+				var usage asn1.ObjectIdentifier
+				if rest, err = asn1.Unmarshal(rest, &usage); err != nil {
+					return a, errors.New("pkcs12: error decoding trustedKeyUsage attribute: " + err.Error())
+				}
+				a.trustedKeyUsage = append(a.trustedKeyUsage, usage)
+			}
+			a.trusted = true
+		default:
+			a.other = append(a.other, Attribute{ID: attribute.ID, Value: attribute.Value})
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,511 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// buildTestPFX returns a PFX holding the test identity twice, as two
+// entries with localKeyIds 1 and 2, and a certificate with no key.
+func buildTestPFX(t *testing.T, password string) []byte {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	keys := NewContentInfoBuilder()
+	for _, id := range []byte{1, 2} {
+		name, err := NewFriendlyNameAttribute(string('0' + id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs.AddCertificate(cert.Raw, NewLocalKeyIDAttribute([]byte{id}))
+		keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, name, NewLocalKeyIDAttribute([]byte{id}))
+	}
+	certs.AddCertificate(cert.Raw)
+
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p12
+}
+
+func TestDecodeAll(t *testing.T) {
+	entries, err := DecodeAll(buildTestPFX(t, "all"), "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, but found %d", len(entries))
+	}
+	for i, e := range entries[:2] {
+		if e.PrivateKey == nil || e.Certificate == nil {
+			t.Errorf("expected entry %d to hold a key and a certificate", i)
+		}
+		if !bytes.Equal(e.LocalKeyID, []byte{byte(i + 1)}) {
+			t.Errorf("expected entry %d to have localKeyId %d, but found % x", i, i+1, e.LocalKeyID)
+		}
+		if e.FriendlyName != string('1'+byte(i)) {
+			t.Errorf("expected entry %d to have friendlyName %d, but found '%s'", i, i+1, e.FriendlyName)
+		}
+	}
+	if entries[2].PrivateKey != nil || entries[2].Certificate == nil {
+		t.Errorf("expected last entry to hold only a certificate")
+	}
+
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+	if entries, err = DecodeAll(p12, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Certificate == nil {
+		t.Fatalf("expected a single paired entry, but found %d entries", len(entries))
+	}
+	if err = entries[0].PrivateKey.(*rsa.PrivateKey).Validate(); err != nil {
+		t.Errorf("err while validating private key: %v", err)
+	}
+}
+
+func TestDecodeAllSkipsNonX509Certificates(t *testing.T) {
+	_, cert := testIdentity(t)
+	sdsi, err := asn1.Marshal(struct {
+		ID   asn1.ObjectIdentifier
+		Data string `asn1:"tag:0,explicit,ia5"`
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 2}, "(certificate)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := NewContentInfoBuilder()
+	certs.AddBag(oidCertBagType, sdsi)
+	certs.AddCertificate(cert.Raw)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(NewContentInfoBuilder())
+	p12, err := pfx.Build([]byte("sdsi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := DecodeAll(p12, "sdsi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].Certificate.Equal(cert) {
+		t.Errorf("expected only the X.509 certificate to be decoded, found %d entries", len(entries))
+	}
+}
+
+func TestDecodeAllPairsByPublicKey(t *testing.T) {
+	rsaKey, rsaCert := testIdentity(t)
+	ec := newTestCert(t, "ec", nil)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ed25519"}}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, edKey.Public(), edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCert(t, "other", nil)
+
+	// no localKeyIds, and the certificates in another order than the keys
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	for _, c := range []*x509.Certificate{other.cert, edCert, ec.cert, rsaCert} {
+		certs.AddCertificate(c.Raw)
+	}
+	keys := NewContentInfoBuilder()
+	for _, key := range []interface{}{rsaKey, ec.key, edKey} {
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	}
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("pairs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := DecodeAll(p12, "pairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*x509.Certificate{rsaCert, ec.cert, edCert, other.cert}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, found %d", len(expected), len(entries))
+	}
+	for i, cert := range expected {
+		if !entries[i].Certificate.Equal(cert) {
+			t.Errorf("expected entry %d to hold '%s', found '%s'", i, cert.Subject.CommonName, entries[i].Certificate.Subject.CommonName)
+		}
+		if (entries[i].PrivateKey != nil) != (i < 3) {
+			t.Errorf("expected entry %d to hold a private key: %v", i, i < 3)
+		}
+	}
+}
+
+func TestEntryFingerprints(t *testing.T) {
+	_, cert := testIdentity(t)
+
+	// as reported by openssl x509 -fingerprint
+	sha1Hex, sha256Hex := Entry{Certificate: cert}.Fingerprints()
+	if expected := "740f5e56abc44d7e1a9ff73ca7c4ac4ee8248adf"; sha1Hex != expected {
+		t.Errorf("expected SHA-1 fingerprint %s, found %s", expected, sha1Hex)
+	}
+	if expected := "c4fd96e86a4d893d0ee8a2505aa8bcd18143ea2b30aec358fe5a5c7cf56842d4"; sha256Hex != expected {
+		t.Errorf("expected SHA-256 fingerprint %s, found %s", expected, sha256Hex)
+	}
+
+	if sha1Hex, sha256Hex = (Entry{}).Fingerprints(); sha1Hex != "" || sha256Hex != "" {
+		t.Errorf("expected no fingerprints without a certificate")
+	}
+}
+
+func TestAttributeOIDs(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := NewFriendlyNameAttribute("oids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewKeyProviderNameAttribute("Microsoft Software Key Storage Provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendor := newAttribute(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, "vendor data")
+	id := NewLocalKeyIDAttribute([]byte{1})
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(cert.Raw, id, vendor)
+	certs.AddCertificate(cert.Raw, NewTrustedKeyUsageAttribute())
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, name, id, provider)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("oids"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oids, err := AttributeOIDs(p12, "oids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []asn1.ObjectIdentifier{oidLocalKeyID, vendor.ID, oidTrustedKeyUsage, oidFriendlyName, oidMicrosoftCSPName}
+	if len(oids) != len(expected) {
+		t.Fatalf("expected %v, found %v", expected, oids)
+	}
+	for i := range expected {
+		if !oids[i].Equal(expected[i]) {
+			t.Errorf("expected %v, found %v", expected, oids)
+			break
+		}
+	}
+
+	if _, err = AttributeOIDs(p12, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected an incorrect password, got err: %v", err)
+	}
+}
+
+func TestDecodeAllConcatenatedCertificates(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole chain is in the cert bag of the leaf
+	var chain []byte
+	for _, c := range []*testCert{leaf, intermediate, root} {
+		chain = append(chain, c.cert.Raw...)
+	}
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(chain, NewLocalKeyIDAttribute([]byte("leaf")))
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, NewLocalKeyIDAttribute([]byte("leaf")))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("concatenated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = DecodeAll(p12, "concatenated"); err == nil {
+		t.Error("expected the trailing certificates to be rejected without Lenient")
+	}
+
+	opts := DecodeOptions{Password: passwordString("concatenated"), Lenient: true}
+	entries, err := opts.DecodeAll(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*testCert{leaf, intermediate, root}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, found %d", len(expected), len(entries))
+	}
+	if entries[0].PrivateKey == nil || !bytes.Equal(entries[0].LocalKeyID, []byte("leaf")) {
+		t.Error("expected the first certificate to be paired with the key")
+	}
+	for i, c := range expected {
+		if !entries[i].Certificate.Equal(c.cert) {
+			t.Errorf("expected entry %d to hold '%s', found '%s'", i, c.cert.Subject.CommonName, entries[i].Certificate.Subject.CommonName)
+		}
+	}
+}
+
+// buildMismatchedPFX returns PFX data whose RSA private key carries the
+// localKeyId of an EC certificate, as a mis-assembled file would, followed by
+// the certificates of the key if withCert is set.
+func buildMismatchedPFX(t *testing.T, password string, withCert bool) []byte {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecName, err := NewFriendlyNameAttribute("ec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaName, err := NewFriendlyNameAttribute("rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(newTestCert(t, "ec", nil).cert.Raw, NewLocalKeyIDAttribute([]byte{1}), ecName)
+	if withCert {
+		certs.AddCertificate(cert.Raw)
+	}
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, NewLocalKeyIDAttribute([]byte{1}), rsaName)
+
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p12
+}
+
+func TestDecodeAllKeyMismatch(t *testing.T) {
+	key, cert := testIdentity(t)
+	for _, withCert := range []bool{false, true} {
+		entries, err := DecodeAll(buildMismatchedPFX(t, "mismatch", withCert), "mismatch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("with the certificate %v: expected the key and the EC certificate apart, found %d entries", withCert, len(entries))
+		}
+
+		k := entries[0]
+		if privateKey, ok := k.PrivateKey.(*rsa.PrivateKey); !ok || !privateKey.Equal(key) || !k.KeyMismatch {
+			t.Errorf("with the certificate %v: expected the RSA key to be reported mismatched, found %+v", withCert, k)
+		}
+		if withCert != (k.Certificate != nil && k.Certificate.Equal(cert)) {
+			t.Errorf("with the certificate %v: unexpected certificate of the key %v", withCert, k.Certificate)
+		}
+		if k.FriendlyName != "rsa" || len(k.Attributes) != 0 {
+			t.Errorf("with the certificate %v: expected the key to keep its own attributes, found %+v", withCert, k)
+		}
+
+		ec := entries[1]
+		if ec.PrivateKey != nil || ec.KeyMismatch || ec.CertFriendlyName != "ec" || !bytes.Equal(ec.LocalKeyID, []byte{1}) {
+			t.Errorf("with the certificate %v: expected the EC certificate as an entry of its own, found %+v", withCert, ec)
+		}
+		if _, ok := ec.Certificate.PublicKey.(*ecdsa.PublicKey); !ok {
+			t.Errorf("with the certificate %v: expected an EC certificate, found %T", withCert, ec.Certificate.PublicKey)
+		}
+	}
+
+	if _, _, _, err := DecodeChain(buildMismatchedPFX(t, "mismatch", false), "mismatch"); err == nil {
+		t.Error("expected DecodeChain not to pair the key with the EC certificate")
+	}
+}
+
+func TestDecodeFunc(t *testing.T) {
+	describe := func(e Entry) string {
+		sha1Hex, _ := e.Fingerprints()
+		return fmt.Sprintf("%q key:%v cert:%s mismatch:%v", e.FriendlyName, e.PrivateKey != nil, sha1Hex, e.KeyMismatch)
+	}
+	for name, p12 := range map[string][]byte{
+		"identities":         buildTestPFX(t, "func"),
+		"mismatch":           buildMismatchedPFX(t, "func", false),
+		"mismatch with cert": buildMismatchedPFX(t, "func", true),
+	} {
+		entries, err := DecodeAll(p12, "func")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := make(map[string]int)
+		for _, e := range entries {
+			expected[describe(e)]++
+		}
+
+		calls := 0
+		err = DecodeFunc(p12, "func", func(e Entry) error {
+			calls++
+			expected[describe(e)]--
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if calls != len(entries) {
+			t.Errorf("%s: expected %d entries, got %d", name, len(entries), calls)
+		}
+		for e, n := range expected {
+			if n != 0 {
+				t.Errorf("%s: expected entry %s as from DecodeAll", name, e)
+			}
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := DecodeFunc(buildTestPFX(t, "func"), "func", func(Entry) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("expected decoding to stop after the first entry, got %d calls, err: %v", calls, err)
+	}
+	if err = DecodeFunc(buildTestPFX(t, "func"), "wrong", func(Entry) error { return nil }); err != ErrIncorrectPassword {
+		t.Errorf("expected an incorrect password, got err: %v", err)
+	}
+}
+
+func TestDecodeAllFriendlyNameNulls(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(nullFriendlyNameTestData)
+	entries, err := DecodeAll(p12, "names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].FriendlyName != "alias" || entries[0].CertFriendlyName != "alias" {
+		t.Fatalf("expected the names to end at their first NULL, got %+v", entries)
+	}
+
+	blocks, err := ConvertToPEM(p12, []byte("names"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range blocks {
+		if name := b.Headers["friendlyName"]; name != "alias" {
+			t.Errorf("expected %s friendlyName header alias, got %q", b.Type, name)
+		}
+	}
+}
+
+// nullFriendlyNameTestData holds the identity of testIdentity, for the
+// password "names", with friendlyName BMPStrings written as some Windows
+// files have them: that of the key is "alias" followed by a NULL, that of
+// the certificate "alias", a NULL and "old". openssl pkcs12 -info displays
+// both as "alias".
+var nullFriendlyNameTestData = `MIIJawIBAzCCCTEGCSqGSIb3DQEHAaCCCSIEggkeMIIJGjCCBUsGCSqGSIb3DQEHAaCCBTwEggU4
+MIIFNDCCBTAGCyqGSIb3DQEMCgECoIIE7jCCBOowHAYKKoZIhvcNAQwBAzAOBAigewdbSBUhxAIC
+CAAEggTIkADk5XigQTayi/xY++bKb1abX39HL8rlEmqRAb086wqTMMv9LvJIJgPbeT2Bc63euI6M
+4f8HH9euqOKcPZkXr9D2mJ7xzr2g+8E+OGfiC0JdeJ2FVz6av41hBYfhEpeW4szcYMVpKKiwqQJr
+JdqNcI53bB8mtaulVi0zPWjOmLbj9k9XYRYR9XocvZN2lRr46iFustzmEgoZwPKvcIoMVX+EVwy5
+aQdflRX++b6/zshQG7zw2uXwChPbwYhX+WJuVGrD3241+mifF9nlVU/j4v9x656LB3Y/nisMo6aW
+npwZKxZc6BL5XEdkZEN7/YpJFMkyL5NUC6fuPIvdF1/0TocaEANPzyhnTmO0HzV4XJDQN6eNHnxc
+KDHU2oMKAZ6gZclyY8Hg3mtyl1SOFfKZ07GbZtBJSB0uYGnJ6UtwRcHJoknnSGzMwY9fsDiW9BLi
+v2a1YyaoNee++5Q2h62KsjJhhhUvl44r4Cb299an+6OkVh5qMGas+ALDJ3PDSVJBFkxScV+p1Z/D
+gunCrkE5hScDBaPV/rQZUeyPXgtHo2EOPhXVS8WZsZAhIjOdKkTeiUtpO2jgeFkM2ux51R5XxBbH
+L8Jg+lkYbJYSQRAnlmvHD0Ua/EY13LCC4dlnhmqsTWBm+gryBXYL4Z85tzUSVCQBa/EzlZYO4d9+
+A2FkpCcBPT3DbrN1D9mUKwHI8n3cD2XvTs3w0Gkp5zvFBaYrQxQ5yq6SB+DEVDFsVkV6WpU1Bgki
+Nw9eXg6/Y6HfUCgG5T9Ic40no8YfzRf4jeyyS4KMhN9GdUBYMJHCtiXma8OAg6nmRlZJOlodb95h
+TQuLq+5LlwePV2mDhjVymi65uQTQbC031h7y2My/hh8R3nSJgYYZ9zvu5fwa6FL+CnW17Jw+0cv7
+gjQbbSJ1g4bJZKLOED9aRY41QpvPhrfovs5bw5qSpRSsgOXD/6AkYE5F7dJfqQkT4t6evLX5WmMY
+hL/n/PQkEL0+30gMsHmrVclgklGC+DKd++5vb0O42URTwnvxas5e088kOVEAqgKhWIrBbDrcYrp0
+lsoAJDVS/UEnaX3uaos6az+dMEWz563HaC78834kXwiEj0XGlDoVPe5cSeFTFrs+3MNgtDjCxPDD
+J7jA8EEXvtC95uUZTQkBDlZWNB2o+sZ4h3q1GXU1PAUrvaBRCE/Av9jBuIN6RNC5GaN63ij51B03
++XkbmnPazsiGJhsoUipy5yAHjWeBBDKw+Iy/9HIjPoNu7ULXr+DcT7oMfmzbJH8ihfYRSDr8Qr8L
+MASEr17bDbLdlicIDU5WIC3tgcYsw4RGayog/YBcx1D4rOxPDVIl4i1TKq2+Eq3rgaSR5Ivr2dJ6
+qUvrc+OknCdmKcau+BHJMAp1HYayjBEAYgFjF00hDZ+QQz2az6FmSflM81acQgx8MKjo8XviKvOI
+cIrnCBWYVVb4zza8ttl6/1njDzVN5q3VZB5f4iad0ju3NssyUAY6+M9kgVnk6Q5+PIhnX4mNWbM5
+V3H8DBp4ydxQdwxY28Xl3jLuGQj/tqOyklaxlCjsINfuSVZsQmrQG/FMCK4HPPKOUwc7SvQ8tPbt
+uEsgEc0mqFM2xwvX7xELP+BrTgHeF8LEZYQkjH9IWp+sMS8wEAYJKoZIhvcNAQkVMQMEAQEwGwYJ
+KoZIhvcNAQkUMQ4eDABhAGwAaQBhAHMAADCCA8cGCSqGSIb3DQEHBqCCA7gwggO0AgEAMIIDrQYJ
+KoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQIcv4nzvbISZUCAggAgIIDgEQVK86Jbr+Ov9rpnqUW
+2nc4glbbLBoYCr/vd2FcNezLcox4qPzvtKfxrPF1RYOYQM81kfwpIfL/56T7I+bSRa+aNEFMNkew
+mblKqUsfsuLXIlOdQY8qC6c5+vv+halR34zhV4nREpTTcEZ0bf2qxko+JQsqFzWOfs2sdzfpw0tS
+MThRldjrm2mCr+10roPHR8izY+1/mtyyofNMUsN+OhcyPVyKzUJyOf/4Qc1HOw2izNaZ9GmaIFER
+YkQxiSA7ySjNuNQ+82Nr/6lHVi0e6qDCalViqfFgpF4/kdsXunF86WESRZHlGcLuBT3FhicCI+5Z
+WBLyC/QTWcYbJaq53E4q4VIIZwjWxn6cQXdFgu4i8FLxwHVniaKWZIyPYYktccD+qzI3wC52dCL3
+qwZPeEgEPv4vW30Rsi8x9Roc1TCpXqjCWhJTU9+dCSj2M4MYKZGdpwiS6PxdEetQxHwEJcxEJnDJ
+G1A+WqgTtnEwCSKcrYO806EuK8Nf4TXeWRhGYorttWaBRrnZnXBR5kgH7S6KfGrXMOK2Cmyqnc1M
+RZ2QcqKdNeDPQ2osL+UEmZgCr8q4tpEZnLH6ujMnfO5F+EoYLxiAd8vQMJXKAlsIveUucurUmAhH
+a8NsAt17f0xbHA0UIk1aeuZ+AHyYXmnw27O1+xeYAbATa6gBxAQ6Tw2t1GKv65r3kzdU9mk0INkr
+qZsYnJWnHGR4bTmhZ7YbPTlniOqPc/mfgGV5Qd7O2uBesFYp17fLwUPHdAafz80NY5CjeLkd006A
+NM6R7RNcq9GTyG1h0P/q1yPF5yUBQKLNAJpp60A5VZimyj6ZSR0CMDkn/w+vDQGf9PBz6fpMqfgx
+oXVijlfSaEfMkEqmlXayIEPGaCCqtaxTrx80douccTSTtUCZk7rnwFWrUGy1apQlSp9nxQLMaJJD
+glkoUmercYqZeeu1n1nA1xUiUQTH0EKO9W0z/7txGk78wzafMZ5yLNi9oaEkq04iWbwE0op/XKNh
+n0HEPq9Tbz29Wvh+5XI5pJIfYOdUkMy81b+hKMgOg03GT8e0dAycg+qtrhUzYtUPgF3FB2rKdbOq
+bv8rN5LVxbb8l2sNC4FVqc2Nrr4uqwyTnCFWbrsqMH6ayqb3M6mX51zVxRHUPMcdPSHnE15Ym+pL
+uK3KNpPJEsyhrShs7CWPDDJaqlpRZWN0MJP1MDEwITAJBgUrDgMCGgUABBTkA7qQOhXXWesRAwBC
+ECxTw9zYOQQIx9wLHwwRYwoCAggA`
+
+func TestDecodeAllWipesSafeContents(t *testing.T) {
+	_, cert := testIdentity(t)
+	value, err := asn1.MarshalWithParams([]string{"kept"}, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := Attribute{ID: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: asn1.RawValue{FullBytes: value}}
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(cert.Raw, unknown)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	p12, err := pfx.Build([]byte("wipe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the decrypted SafeContents are wiped once decoded, which must leave
+	// the attributes kept verbatim intact
+	entries, err := DecodeAll(p12, "wipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || len(entries[0].Attributes) != 1 {
+		t.Fatalf("expected an entry with one attribute, found %+v", entries)
+	}
+	var decoded []string
+	if _, err = asn1.UnmarshalWithParams(entries[0].Attributes[0].Value.FullBytes, &decoded, "set"); err != nil || len(decoded) != 1 || decoded[0] != "kept" {
+		t.Errorf("expected the attribute value to be kept, found %q, err: %v", decoded, err)
+	}
+}
@@ -0,0 +1,138 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"reflect"
+)
+
+// AreEquivalent reports whether the PFX data a and b, decoded with passwordA
+// and passwordB, hold the same private keys, certificates and attributes,
+// whatever the order of their bags, the algorithms and salts they are
+// encrypted with, or their MACs, such as the output of ReEncrypt or
+// Canonicalize and its input. Entries are those of DecodeAll: those holding a
+// private key are matched by public key, and the others by the DER encoding
+// of their certificate. The differences found are described, one per string,
+// in the order of a and then of b; equivalent is true if there are none.
+func AreEquivalent(a, b []byte, passwordA, passwordB string) (equivalent bool, differences []string, err error) {
+	entriesA, err := DecodeAll(a, passwordA)
+	if err != nil {
+		return false, nil, fmt.Errorf("pkcs12: error decoding a: %v", err)
+	}
+	entriesB, err := DecodeAll(b, passwordB)
+	if err != nil {
+		return false, nil, fmt.Errorf("pkcs12: error decoding b: %v", err)
+	}
+
+	matched := make([]bool, len(entriesB))
+	for _, ea := range entriesA {
+		j := 0
+		for ; j < len(entriesB); j++ {
+			if !matched[j] && sameEntry(&ea, &entriesB[j]) {
+				break
+			}
+		}
+		if j == len(entriesB) {
+			differences = append(differences, "only in a: "+describeEntry(&ea))
+			continue
+		}
+		matched[j] = true
+		differences = append(differences, diffEntry(&ea, &entriesB[j])...)
+	}
+	for j := range entriesB {
+		if !matched[j] {
+			differences = append(differences, "only in b: "+describeEntry(&entriesB[j]))
+		}
+	}
+	return len(differences) == 0, differences, nil
+}
+
+// sameEntry reports whether a and b stand for the same private key, or for
+// the same certificate if neither holds one.
+func sameEntry(a, b *Entry) bool {
+	if a.PrivateKey == nil || b.PrivateKey == nil {
+		return a.PrivateKey == nil && b.PrivateKey == nil && a.Certificate.Equal(b.Certificate)
+	}
+	public, ok := comparablePublicKey(a.PrivateKey)
+	if !ok {
+		// such as DSA keys
+		return reflect.DeepEqual(a.PrivateKey, b.PrivateKey)
+	}
+	other, ok := b.PrivateKey.(interface{ Public() crypto.PublicKey })
+	return ok && public.Equal(other.Public())
+}
+
+// diffEntry describes how the matching entries a and b differ.
+func diffEntry(a, b *Entry) (differences []string) {
+	what := describeEntry(a)
+	differ := func(field string, valueA, valueB interface{}) {
+		differences = append(differences, fmt.Sprintf("%s: %s %v in a, %v in b", what, field, valueA, valueB))
+	}
+	switch {
+	case (a.Certificate == nil) != (b.Certificate == nil):
+		differ("certificate", a.Certificate != nil, b.Certificate != nil)
+	case a.Certificate != nil && !a.Certificate.Equal(b.Certificate):
+		differ("certificate", a.Certificate.Subject, b.Certificate.Subject)
+	}
+	if a.FriendlyName != b.FriendlyName {
+		differ("friendlyName", fmt.Sprintf("%q", a.FriendlyName), fmt.Sprintf("%q", b.FriendlyName))
+	}
+	if a.CertFriendlyName != b.CertFriendlyName {
+		differ("certificate friendlyName", fmt.Sprintf("%q", a.CertFriendlyName), fmt.Sprintf("%q", b.CertFriendlyName))
+	}
+	if !bytes.Equal(a.LocalKeyID, b.LocalKeyID) {
+		differ("localKeyId", fmt.Sprintf("%x", a.LocalKeyID), fmt.Sprintf("%x", b.LocalKeyID))
+	}
+	if a.KeyProviderName != b.KeyProviderName {
+		differ("keyProviderName", fmt.Sprintf("%q", a.KeyProviderName), fmt.Sprintf("%q", b.KeyProviderName))
+	}
+	if a.TrustAnchor != b.TrustAnchor || !reflect.DeepEqual(a.TrustedKeyUsage, b.TrustedKeyUsage) {
+		differ("trustedKeyUsage", a.TrustedKeyUsage, b.TrustedKeyUsage)
+	}
+	if !sameAttributes(a.Attributes, b.Attributes) {
+		differ("attributes", attributeIDs(a.Attributes), attributeIDs(b.Attributes))
+	}
+	return differences
+}
+
+// sameAttributes reports whether a and b hold the same attributes, in any
+// order.
+func sameAttributes(a, b []Attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	matched := make([]bool, len(b))
+	for _, attribute := range a {
+		j := 0
+		for ; j < len(b); j++ {
+			if !matched[j] && attribute.ID.Equal(b[j].ID) && bytes.Equal(attribute.Value.FullBytes, b[j].Value.FullBytes) {
+				break
+			}
+		}
+		if j == len(b) {
+			return false
+		}
+		matched[j] = true
+	}
+	return true
+}
+
+func attributeIDs(attributes []Attribute) []string {
+	ids := make([]string, len(attributes))
+	for i, attribute := range attributes {
+		ids[i] = attribute.ID.String()
+	}
+	return ids
+}
+
+// describeEntry names e in the differences of AreEquivalent.
+func describeEntry(e *Entry) string {
+	switch {
+	case e.PrivateKey == nil:
+		return fmt.Sprintf("certificate %q", e.Certificate.Subject)
+	case e.Certificate == nil:
+		return fmt.Sprintf("%T private key", e.PrivateKey)
+	}
+	return fmt.Sprintf("private key of certificate %q", e.Certificate.Subject)
+}
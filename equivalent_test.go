@@ -0,0 +1,58 @@
+package pkcs12
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAreEquivalent(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	leaf := newTestCert(t, "leaf", root)
+	p12 := buildChainPFX(t, "a", leaf, root)
+
+	reencrypted, err := ReEncrypt(p12, "a", WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES128CBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name, password string
+		pfxData        []byte
+	}{
+		{"reencrypted", "a", reencrypted},
+		{"rebuilt", "b", buildChainPFX(t, "b", leaf, root)},
+	} {
+		equivalent, differences, err := AreEquivalent(p12, test.pfxData, "a", test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equivalent || len(differences) != 0 {
+			t.Errorf("%s: expected the keystores to be equivalent, found %q", test.name, differences)
+		}
+	}
+
+	// Encode derives another localKeyId, and sets a friendlyName
+	encoded, err := Encode(leaf.key, leaf.cert, nil, "c", WithFriendlyName("alias"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	equivalent, differences, err := AreEquivalent(p12, encoded, "a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equivalent {
+		t.Fatal("expected the keystores to differ")
+	}
+	expected := []string{": friendlyName", "certificate friendlyName", "localKeyId", `only in a: certificate "CN=root"`}
+	if len(differences) != len(expected) {
+		t.Fatalf("expected %d differences, found %q", len(expected), differences)
+	}
+	for i, e := range expected {
+		if !strings.Contains(differences[i], e) {
+			t.Errorf("expected difference %d to mention %s, found %q", i, e, differences[i])
+		}
+	}
+
+	if _, _, err = AreEquivalent(p12, encoded, "a", "wrong"); err == nil {
+		t.Error("expected an incorrect password to fail")
+	}
+}
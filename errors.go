@@ -1,6 +1,11 @@
 package pkcs12
 
-import "errors"
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrDecryption represents a failure to decrypt the input.
@@ -9,11 +14,92 @@ var (
 	// ErrIncorrectPassword is returned when an incorrect password is detected.
 	// Usually, P12/PFX data is signed to be able to verify the password.
 	ErrIncorrectPassword = errors.New("pkcs12: decryption password incorrect")
+
+	// ErrMissingMAC is returned when the integrity of PFX data cannot be
+	// checked because it has no MAC.
+	ErrMissingMAC = errors.New("pkcs12: no MAC present")
+
+	// ErrTooManyBags is returned when PFX data holds more safe bags than
+	// DecodeOptions.MaxBags allows.
+	ErrTooManyBags = errors.New("pkcs12: too many safe bags")
+
+	// ErrInputTooLarge is returned when PFX data is larger than
+	// DecodeOptions.MaxInputSize allows.
+	ErrInputTooLarge = errors.New("pkcs12: input too large")
+
+	// ErrEmptyKeystore is returned by Decode for PFX data that holds no
+	// safe bags, such as a keystore yet to be filled in, once its MAC is
+	// verified. DecodeAll returns no entries for it.
+	ErrEmptyKeystore = errors.New("pkcs12: keystore is empty")
+
+	// ErrLocalKeyIDNotFound is returned by ExtractByLocalKeyID when no
+	// private key of the PFX data has the localKeyId asked for.
+	ErrLocalKeyIDNotFound = errors.New("pkcs12: no private key with that localKeyId")
 )
 
+// ErrPublicKeyIntegrity is returned for PFX data whose integrity is protected
+// by a public-key signature (signedData) rather than a password MAC.
+var ErrPublicKeyIntegrity = NotImplementedError("pkcs12: public-key integrity mode (signedData) is not supported")
+
 // NotImplementedError indicates that the input is not currently supported.
 type NotImplementedError string
 
 func (e NotImplementedError) Error() string {
 	return string(e)
 }
+
+// IncompleteChainError is passed to the handler of WithIncompleteChainHandler
+// when Encode, with WithSortChain, could only partly order the chain of the
+// certificate. Last is the farthest certificate of the chain, which is not
+// self-signed if the certificate of its issuer is missing, and Unchained
+// those of the caCerts that are not in the chain, stored after it.
+type IncompleteChainError struct {
+	Last      *x509.Certificate
+	Unchained []*x509.Certificate
+}
+
+func (e *IncompleteChainError) Error() string {
+	if bytes.Equal(e.Last.RawIssuer, e.Last.RawSubject) {
+		return fmt.Sprintf("pkcs12: %d certificates are not in the chain", len(e.Unchained))
+	}
+	return fmt.Sprintf("pkcs12: issuer %q of %q is missing from the chain, and %d certificates are not in it", e.Last.Issuer, e.Last.Subject, len(e.Unchained))
+}
+
+// BatchError is returned by ReEncryptBatch when a file fails. Index is the
+// index of the file, and Err the error it failed with, which errors.Is and
+// errors.As look through.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%v (file %d)", e.Err, e.Index)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned when decoding fails on a particular ContentInfo of
+// the authenticated safe, or on a particular safe bag of it. Err is the
+// underlying error, which errors.Is and errors.As look through.
+type DecodeError struct {
+	// ContentInfoIndex is the index of the ContentInfo in the authenticated
+	// safe, and BagIndex that of the bag in its SafeContents, or -1 if the
+	// ContentInfo itself could not be decoded.
+	ContentInfoIndex int
+	BagIndex         int
+	Err              error
+}
+
+func (e *DecodeError) Error() string {
+	if e.BagIndex < 0 {
+		return fmt.Sprintf("%v (ContentInfo %d)", e.Err, e.ContentInfoIndex)
+	}
+	return fmt.Sprintf("%v (ContentInfo %d, bag %d)", e.Err, e.ContentInfoIndex, e.BagIndex)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
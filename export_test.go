@@ -0,0 +1,76 @@
+package pkcs12
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"testing"
+)
+
+func testIdentity(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+	pk, c, err := Decode(p12, []byte(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pk.(*rsa.PrivateKey), c
+}
+
+func TestCreateDefaultSaltLength(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	p12, err := Create(cert.Raw, x509.MarshalPKCS1PrivateKey(key), []byte("sesame"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfx, authSafe, err := parsePfx(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pfx.MacData.MacSalt) != defaultSaltLength {
+		t.Errorf("expected MAC salt of %d bytes, but found %d", defaultSaltLength, len(pfx.MacData.MacSalt))
+	}
+
+	var authenticatedSafe []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &authenticatedSafe); err != nil {
+		t.Fatal(err)
+	}
+	for _, ci := range authenticatedSafe {
+		var algorithm pbeParams
+		switch {
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var ed encryptedData
+			if _, err = asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &algorithm); err != nil {
+				t.Fatal(err)
+			}
+		case ci.ContentType.Equal(oidDataContentType):
+			var data []byte
+			var bags []rawSafeBag
+			var pkinfo encryptedPrivateKeyInfo
+			if _, err = asn1.Unmarshal(ci.Content.Bytes, &data); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = asn1.Unmarshal(data, &bags); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = asn1.Unmarshal(bags[0].Value.Bytes, &pkinfo); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = asn1.Unmarshal(pkinfo.AlgorithmIdentifier.Parameters.FullBytes, &algorithm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(algorithm.Salt) != defaultSaltLength {
+			t.Errorf("expected PBE salt of %d bytes, but found %d", defaultSaltLength, len(algorithm.Salt))
+		}
+	}
+
+	if _, _, err = Decode(p12, []byte("sesame")); err != nil {
+		t.Errorf("err while decoding created PFX: %v", err)
+	}
+}
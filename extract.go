@@ -0,0 +1,56 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+)
+
+// ExtractByLocalKeyID returns PFX data protected with newPassword holding
+// only the private key of pfxData whose localKeyId is id, along with its
+// certificate and chain. See Encoder.ExtractByLocalKeyID.
+func ExtractByLocalKeyID(pfxData []byte, password string, id []byte, newPassword string, opts ...EncodeOption) ([]byte, error) {
+	return NewEncoder(opts...).ExtractByLocalKeyID(pfxData, password, id, newPassword)
+}
+
+// ExtractByLocalKeyID decodes pfxData with password and encodes, with enc and
+// newPassword, the private key whose localKeyId is id, for keystores whose
+// entries are told apart by it rather than by a friendlyName. The
+// certificate paired with the key is stored with the issuers of it found
+// among the other certificates of pfxData, from the closest to the farthest;
+// the remaining certificates are left out. The localKeyId is kept, and so is
+// the friendlyName unless enc sets another. ErrLocalKeyIDNotFound is
+// returned if no private key has the localKeyId id.
+func (enc *Encoder) ExtractByLocalKeyID(pfxData []byte, password string, id []byte, newPassword string) ([]byte, error) {
+	entries, err := DecodeAll(pfxData, password)
+	if err != nil {
+		return nil, err
+	}
+
+	match := -1
+	var others []*x509.Certificate
+	for i, e := range entries {
+		if match < 0 && e.PrivateKey != nil && len(e.LocalKeyID) > 0 && bytes.Equal(e.LocalKeyID, id) {
+			match = i
+			continue
+		}
+		if e.Certificate != nil {
+			others = append(others, e.Certificate)
+		}
+	}
+	if match < 0 {
+		return nil, ErrLocalKeyIDNotFound
+	}
+	entry := entries[match]
+	if entry.Certificate == nil {
+		return nil, fmt.Errorf("pkcs12: private key with localKeyId %x has no certificate", id)
+	}
+	chain, _ := splitChain(entry.Certificate, others)
+
+	extract := *enc
+	extract.keyIDScheme = Explicit(id)
+	if extract.keyName == "" {
+		extract.keyName = entry.FriendlyName
+	}
+	return extract.Encode(entry.PrivateKey, entry.Certificate, chain[1:], newPassword)
+}
@@ -0,0 +1,70 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestExtractByLocalKeyID(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	other := newTestCert(t, "other", nil)
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	keys := NewContentInfoBuilder()
+	for _, c := range []struct {
+		id   string
+		cert *testCert
+	}{{"other", other}, {"leaf", leaf}} {
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(c.cert.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := NewFriendlyNameAttribute(c.id + " alias")
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs.AddCertificate(c.cert.cert.Raw, NewLocalKeyIDAttribute([]byte(c.id)))
+		keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, name, NewLocalKeyIDAttribute([]byte(c.id)))
+	}
+	certs.AddCertificate(root.cert.Raw)
+	certs.AddCertificate(intermediate.cert.Raw)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extracted, err := ExtractByLocalKeyID(p12, "store", []byte("leaf"), "extracted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := DecodeAll(extracted, "extracted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected the identity and its 2 issuers, found %d entries", len(entries))
+	}
+	if !entries[0].Certificate.Equal(leaf.cert) || entries[0].PrivateKey == nil {
+		t.Errorf("expected the leaf identity first, found %q", entries[0].Certificate.Subject.CommonName)
+	}
+	if !bytes.Equal(entries[0].LocalKeyID, []byte("leaf")) || entries[0].FriendlyName != "leaf alias" {
+		t.Errorf("expected the localKeyId and friendlyName to be kept, found %q and %q", entries[0].LocalKeyID, entries[0].FriendlyName)
+	}
+	if !entries[1].Certificate.Equal(intermediate.cert) || !entries[2].Certificate.Equal(root.cert) {
+		t.Errorf("expected the issuers in chain order, found %q and %q", entries[1].Certificate.Subject.CommonName, entries[2].Certificate.Subject.CommonName)
+	}
+
+	if _, err = ExtractByLocalKeyID(p12, "store", []byte("missing"), "extracted"); !errors.Is(err, ErrLocalKeyIDNotFound) {
+		t.Errorf("expected ErrLocalKeyIDNotFound, got: %v", err)
+	}
+	if _, err = ExtractByLocalKeyID(p12, "store", nil, "extracted"); !errors.Is(err, ErrLocalKeyIDNotFound) {
+		t.Errorf("expected an empty localKeyId not to match, got: %v", err)
+	}
+}
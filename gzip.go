@@ -0,0 +1,58 @@
+package pkcs12
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// gzipEnvelopeMagic starts the output of EncodeGzip, so that DecodeGzip can
+// tell it apart from PFX data, which starts with a DER SEQUENCE.
+const gzipEnvelopeMagic = "PKCS12GZ\x01"
+
+// errNotGzipEnvelope is returned by DecodeGzip for data EncodeGzip did not
+// produce.
+var errNotGzipEnvelope = errors.New("pkcs12: data is not in the gzip envelope of EncodeGzip")
+
+// EncodeGzip compresses pfxData, such as the output of Encode, for storage
+// where both ends use this package. The result is the bytes "PKCS12GZ"
+// followed by the version byte 1 and the gzip (RFC 1952) compression of
+// pfxData. This envelope is specific to this package: it is not PKCS#12, and
+// other PKCS#12 readers cannot read it. The compression gains most on large
+// trust bundles, as encrypted contents hardly compress.
+func EncodeGzip(pfxData []byte) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(gzipEnvelopeMagic)
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(pfxData); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecodeGzip returns the PFX data compressed by EncodeGzip into data. PFX
+// data decompressing to more than DefaultMaxInputSize bytes fails with
+// ErrInputTooLarge.
+func DecodeGzip(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(gzipEnvelopeMagic)) {
+		return nil, errNotGzipEnvelope
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data[len(gzipEnvelopeMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	pfxData, err := io.ReadAll(io.LimitReader(r, DefaultMaxInputSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(pfxData) > DefaultMaxInputSize {
+		return nil, ErrInputTooLarge
+	}
+	return pfxData, nil
+}
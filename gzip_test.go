@@ -0,0 +1,51 @@
+package pkcs12
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestEncodeGzip(t *testing.T) {
+	p12 := buildTestPFX(t, "gzip")
+	data, err := EncodeGzip(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("PKCS12GZ\x01")) {
+		t.Errorf("expected the envelope to start with its magic, found % x", data[:9])
+	}
+	if _, err = DecodeAll(data, "gzip"); err == nil {
+		t.Error("expected the envelope not to be read as PFX data")
+	}
+
+	decoded, err := DecodeGzip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, p12) {
+		t.Error("expected the PFX data back")
+	}
+	if _, err = DecodeAll(decoded, "gzip"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = DecodeGzip(p12); err == nil {
+		t.Error("expected PFX data not in the envelope to be refused")
+	}
+}
+
+func TestDecodeGzipTooLarge(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString(gzipEnvelopeMagic)
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(make([]byte, DefaultMaxInputSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeGzip(b.Bytes()); err != ErrInputTooLarge {
+		t.Errorf("expected input too large, got err: %v", err)
+	}
+}
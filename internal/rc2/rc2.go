@@ -3,6 +3,11 @@
 https://www.ietf.org/rfc/rfc2268.txt
 http://people.csail.mit.edu/rivest/pubs/KRRR98.pdf
 
+Words are read and written little-endian, as specified by RFC 2268. This
+matches OpenSSL, whose RC2 is the de facto standard for PKCS#12; calling New
+with an effective key length of len(key)*8 gives the same results as
+OpenSSL's rc2-40-cbc, rc2-64-cbc and rc2-cbc.
+
 This code is licensed under the MIT license.
 */
 package rc2
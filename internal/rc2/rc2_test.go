@@ -2,6 +2,7 @@ package rc2
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/hex"
 	"testing"
 )
@@ -87,6 +88,57 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+// Vectors from: openssl enc -<cipher> -K <key> -iv f0e1d2c3b4a59687 -nopad
+// where the effective key length is the key length, as with OpenSSL's EVP
+// ciphers.
+func TestOpenSSLCompatibility(t *testing.T) {
+	var tests = []struct {
+		name   string
+		key    string
+		cipher string
+	}{
+		{
+			"rc2-40-cbc",
+			"0123456789",
+			"304fd1342e5c28dc7dc32ec58075e99c63de37789cbd654dc3958b86aded07a0",
+		},
+		{
+			"rc2-64-cbc",
+			"0123456789abcdef",
+			"5b32e335595975ea724c4a1d92aba6e0b5705b5611f0eb782b99119bf4d72eee",
+		},
+		{
+			"rc2-cbc",
+			"0123456789abcdeffedcba9876543210",
+			"aa45f9dc504c9b95c89081b788e51f2a99cef5cdd2133052df6827ebf28871a5",
+		},
+	}
+
+	iv, _ := hex.DecodeString("f0e1d2c3b4a59687")
+	p, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+	for _, tt := range tests {
+		k, _ := hex.DecodeString(tt.key)
+		c, _ := hex.DecodeString(tt.cipher)
+
+		b, err := New(k, len(k)*8)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		dst := make([]byte, len(p))
+		cipher.NewCBCEncrypter(b, iv).CryptBlocks(dst, p)
+		if !bytes.Equal(dst, c) {
+			t.Errorf("%s: encrypt failed: got % 2x wanted % 2x\n", tt.name, dst, c)
+		}
+
+		cipher.NewCBCDecrypter(b, iv).CryptBlocks(dst, c)
+		if !bytes.Equal(dst, p) {
+			t.Errorf("%s: decrypt failed: got % 2x wanted % 2x\n", tt.name, dst, p)
+		}
+	}
+}
+
 func BenchmarkEncrypt(b *testing.B) {
 	r, _ := New([]byte{0, 0, 0, 0, 0, 0, 0, 0}, 64)
 	b.ResetTimer()
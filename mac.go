@@ -1,11 +1,16 @@
 package pkcs12
 
 import (
+	"crypto"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
 	"hash"
+	"sync"
 )
 
 type macData struct {
@@ -14,37 +19,106 @@ type macData struct {
 	Iterations int `asn1:"optional,default:1"`
 }
 
-// from PKCS#7:
+// from PKCS#7. Some producers leave the AlgorithmIdentifier out, which is
+// then taken to be SHA-1.
 type digestInfo struct {
-	Algorithm pkix.AlgorithmIdentifier
+	Algorithm pkix.AlgorithmIdentifier `asn1:"optional"`
 	Digest    []byte
 }
 
+// present reports whether the PFX carried MacData at all.
+func (m *macData) present() bool {
+	return m.Mac.Digest != nil || len(m.Mac.Algorithm.Algorithm) > 0
+}
+
+// iterations returns the iteration count of the MAC key derivation. Some
+// producers write 0 rather than leaving out the default of 1, which is what
+// they and other readers mean by it.
+func (m *macData) iterations() int {
+	if m.Iterations < 1 {
+		return 1
+	}
+	return m.Iterations
+}
+
 const (
-	sha1Algorithm = "SHA-1"
+	sha1Algorithm   = "SHA-1"
+	sha256Algorithm = "SHA-256"
 )
 
 var (
-	oidSha1Algorithm = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
-	hashNameByID     = map[string]string{
-		oidSha1Algorithm.String(): sha1Algorithm,
+	oidSha1Algorithm   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSha256Algorithm = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	hashNameByID       = map[string]string{
+		oidSha1Algorithm.String():   sha1Algorithm,
+		oidSha256Algorithm.String(): sha256Algorithm,
 	}
 	hashByName = map[string]func() hash.Hash{
-		sha1Algorithm: sha1.New,
+		sha1Algorithm:   sha1.New,
+		sha256Algorithm: sha256.New,
+	}
+	hashIDByName = map[string]asn1.ObjectIdentifier{
+		sha1Algorithm:   oidSha1Algorithm,
+		sha256Algorithm: oidSha256Algorithm,
 	}
 )
 
-func verifyMac(macData *macData, message, password []byte) error {
-	name, ok := hashNameByID[macData.Mac.Algorithm.Algorithm.String()]
-	if !ok {
-		return NotImplementedError("unknown digest algorithm: " + macData.Mac.Algorithm.Algorithm.String())
+// MacAlgorithm is the digest algorithm the integrity MAC of a PFX is
+// computed with.
+type MacAlgorithm string
+
+// MAC algorithms supported for encoding.
+const (
+	SHA1   MacAlgorithm = sha1Algorithm
+	SHA256 MacAlgorithm = sha256Algorithm
+
+	// PBMAC1 is the MAC of RFC 9579, keyed with PBKDF2 rather than the
+	// PKCS#12 KDF. It requires a reader that supports it, such as OpenSSL
+	// 3.4 or later.
+	PBMAC1 MacAlgorithm = "PBMAC1"
+)
+
+var (
+	macDigestsMu sync.RWMutex
+	macDigests   = make(map[string]crypto.Hash)
+)
+
+// RegisterMACDigest makes PFX data whose MAC digest algorithm is oid
+// verifiable, with hash used for both the PKCS#12 key derivation and the
+// HMAC. Built-in digest algorithms take precedence over registered ones.
+// Registration must happen before Decode, typically in an init function.
+func RegisterMACDigest(oid asn1.ObjectIdentifier, hash crypto.Hash) {
+	if !hash.Available() {
+		panic("pkcs12: RegisterMACDigest of unavailable hash function")
 	}
-	k := deriveMacKeyByAlg[name](macData.MacSalt, password, macData.Iterations)
-	password = nil
+	macDigestsMu.Lock()
+	macDigests[oid.String()] = hash
+	macDigestsMu.Unlock()
+}
 
-	mac := hmac.New(hashByName[name], k)
-	mac.Write(message)
-	expectedMAC := mac.Sum(nil)
+func registeredMACDigest(oid asn1.ObjectIdentifier) (crypto.Hash, bool) {
+	macDigestsMu.RLock()
+	defer macDigestsMu.RUnlock()
+	h, ok := macDigests[oid.String()]
+	return h, ok
+}
+
+// deriveMacKey derives a MAC key with the PKCS#12 KDF over hash.
+func deriveMacKey(hash crypto.Hash, salt, password []byte, iterations int) []byte {
+	sum := func(in []byte) []byte {
+		h := hash.New()
+		h.Write(in)
+		return h.Sum(nil)
+	}
+	return pbkdf(sum, hash.Size(), hash.New().BlockSize(), salt, password, iterations, 3, hash.Size())
+}
+
+func verifyMac(macData *macData, message, password []byte) error {
+	expectedMAC, err := computeMac(macData, message, password)
+	password = nil
+	if err != nil {
+		return err
+	}
 
 	if !hmac.Equal(macData.Mac.Digest, expectedMAC) {
 		return ErrIncorrectPassword
@@ -52,11 +126,332 @@ func verifyMac(macData *macData, message, password []byte) error {
 	return nil
 }
 
-func generateMacSha1(message, salt, password []byte, iterations int) ([]byte, error) {
-	name := sha1Algorithm
-	k := deriveMacKeyByAlg[name](salt, password, iterations)
+// computeMac returns the MAC of message with password, computed with the
+// algorithm and parameters of macData.
+func computeMac(macData *macData, message, password []byte) ([]byte, error) {
+	algorithm := macData.Mac.Algorithm.Algorithm
+	if len(algorithm) == 0 {
+		algorithm = oidSha1Algorithm
+	}
+	if algorithm.Equal(oidPBMAC1) {
+		return pbmac1(macData.Mac.Algorithm, message, password)
+	}
+
+	var k []byte
+	var newHash func() hash.Hash
+	if name, ok := hashNameByID[algorithm.String()]; ok {
+		k = deriveMacKeyByAlg[name](macData.MacSalt, password, macData.iterations())
+		newHash = hashByName[name]
+	} else if h, ok := registeredMACDigest(algorithm); ok {
+		k = deriveMacKey(h, macData.MacSalt, password, macData.iterations())
+		newHash = h.New
+	} else {
+		return nil, NotImplementedError("unknown digest algorithm: " + algorithm.String())
+	}
+	password = nil
+
+	mac := hmac.New(newHash, k)
+	mac.Write(message)
+	return mac.Sum(nil), nil
+}
+
+func generateMac(name string, message, salt, password []byte, iterations int, keys *derivedKeys) ([]byte, error) {
+	derive, ok := deriveMacKeyByAlg[name]
+	if !ok {
+		return nil, NotImplementedError("MAC algorithm " + name + " is not supported")
+	}
+	k := keys.derive(name+" MAC", salt, password, iterations, func() []byte {
+		return derive(salt, password, iterations)
+	})
 	password = nil
 	mac := hmac.New(hashByName[name], k)
 	mac.Write(message)
 	return mac.Sum(nil), nil
 }
+
+// VerifyMAC checks the integrity MAC of pfxData with password without
+// decrypting any of its contents. It returns ErrIncorrectPassword if the MAC
+// does not match, and ErrMissingMAC if pfxData has no MAC.
+func VerifyMAC(pfxData []byte, password string) error {
+	pfx, authSafe, err := parsePfx(pfxData)
+	if err != nil {
+		return err
+	}
+	if !pfx.MacData.present() {
+		return ErrMissingMAC
+	}
+
+	p, err := bmpString([]byte(password))
+	defer func() { // clear out BMP version of the password before we return
+		for i := 0; i < len(p); i++ {
+			p[i] = 0
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	_, err = verifyPassword(pfx, authSafe, p)
+	return err
+}
+
+// RotateMACPassword returns pfxData with its MAC computed anew with
+// newMacPassword, for files whose MAC password differs from the password of
+// their contents, contentPassword, as read by DecodeSeparatePasswords. The
+// MAC is first verified with oldMacPassword, failing with
+// ErrIncorrectPassword, and the contents and private keys are checked to
+// decrypt with contentPassword, so that the file is still readable once
+// rotated; what they decrypt to is wiped and not used. The MAC is then
+// recomputed with the same algorithm, salt and iterations. Everything but the
+// MAC is kept byte for byte, so the contents stay encrypted as they were. It
+// returns ErrMissingMAC if pfxData has no MAC.
+func RotateMACPassword(pfxData []byte, contentPassword, oldMacPassword, newMacPassword string) ([]byte, error) {
+	pfx, authSafe, err := parsePfx(pfxData)
+	if err != nil {
+		return nil, err
+	}
+	if !pfx.MacData.present() {
+		return nil, ErrMissingMAC
+	}
+
+	oldP, err := bmpString([]byte(oldMacPassword))
+	defer wipe(oldP, nil)
+	if err != nil {
+		return nil, err
+	}
+	newP, err := bmpString([]byte(newMacPassword))
+	defer wipe(newP, nil)
+	if err != nil {
+		return nil, err
+	}
+	contentP, err := bmpString([]byte(contentPassword))
+	defer wipe(contentP, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = verifyPassword(pfx, authSafe, oldP); err != nil {
+		return nil, err
+	}
+	if err = checkContentPassword(authSafe, contentP); err != nil {
+		return nil, err
+	}
+	if pfx.MacData.Mac.Digest, err = computeMac(&pfx.MacData, authSafe, newP); err != nil {
+		return nil, err
+	}
+	mac, err := asn1.Marshal(pfx.MacData.Mac)
+	if err != nil {
+		return nil, err
+	}
+
+	// all but the MAC itself is copied as it was encoded, the authenticated
+	// safe in particular, as the MAC covers it
+	var raw struct {
+		Version  asn1.RawValue
+		AuthSafe asn1.RawValue
+		MacData  struct {
+			Mac        asn1.RawValue
+			MacSalt    asn1.RawValue
+			Iterations asn1.RawValue `asn1:"optional"`
+		}
+	}
+	if _, err = asn1.Unmarshal(pfxData, &raw); err != nil {
+		return nil, err
+	}
+	raw.MacData.Mac = asn1.RawValue{FullBytes: mac}
+	return asn1.Marshal(raw)
+}
+
+// checkContentPassword checks that the encrypted contents of authSafe, and
+// the private keys they hold, decrypt with password, and wipes what they
+// decrypt to.
+func checkContentPassword(authSafe, password []byte) error {
+	opts := new(DecodeOptions)
+	bags, decrypted, err := opts.decryptAuthenticatedSafe(authSafe, password)
+	defer wipe(nil, decrypted)
+	if err != nil {
+		return err
+	}
+	for i := range bags {
+		if !isKeyBag(bags[i].ID) {
+			continue
+		}
+		pkData, err := opts.privateKeyDER(&bags[i], password)
+		wipe(pkData, nil)
+		if err != nil {
+			return bags[i].wrapError(err)
+		}
+	}
+	return nil
+}
+
+// AuthenticatedSafeBytes returns the content octets of the authenticated
+// safe of pfxData, exactly as they are covered by its MAC. Nothing is
+// decrypted.
+func AuthenticatedSafeBytes(pfxData []byte) ([]byte, error) {
+	_, authSafe, err := parsePfx(pfxData)
+	if err != nil {
+		return nil, err
+	}
+	return authSafe, nil
+}
+
+// ValidatePassword reports whether password is the one the MAC of pfxData
+// was computed with, without decrypting any of its contents. An error is
+// returned only if pfxData cannot be parsed or has no MAC.
+func ValidatePassword(pfxData []byte, password string) (bool, error) {
+	switch err := VerifyMAC(pfxData, password); err {
+	case nil:
+		return true, nil
+	case ErrIncorrectPassword:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// ProbePasswords returns the first of candidates that the MAC of pfxData was
+// computed with. pfxData is parsed once and only the MAC is computed for each
+// candidate, nothing is decrypted. Candidates that cannot be represented as a
+// BMPString are skipped. The byte copies made of each candidate are zeroed
+// once it is tried. It returns ErrIncorrectPassword if none of candidates
+// matches, and ErrMissingMAC if pfxData has no MAC.
+func ProbePasswords(pfxData []byte, candidates []string) (matched string, err error) {
+	pfx, authSafe, err := parsePfx(pfxData)
+	if err != nil {
+		return "", err
+	}
+	if !pfx.MacData.present() {
+		return "", ErrMissingMAC
+	}
+
+	var utf8Candidate []byte
+	defer func() { // clear out the last candidate before we return
+		wipe(utf8Candidate, nil)
+	}()
+	for _, candidate := range candidates {
+		wipe(utf8Candidate, nil)
+		utf8Candidate = append(utf8Candidate[:0], candidate...)
+		p, err := bmpString(utf8Candidate)
+		if err != nil {
+			continue
+		}
+		_, err = verifyPassword(pfx, authSafe, p)
+		wipe(p, nil) // clear out BMP version of the candidate
+		if err == nil {
+			return candidate, nil
+		}
+		if err != ErrIncorrectPassword {
+			return "", err
+		}
+	}
+	return "", ErrIncorrectPassword
+}
+
+// MacInfo describes the integrity MAC of PFX data.
+type MacInfo struct {
+	// DigestAlgorithm is the OID of the digest algorithm, or of PBMAC1.
+	// Hash is the corresponding hash, or of the HMAC for PBMAC1, and is
+	// zero if it is not known.
+	DigestAlgorithm asn1.ObjectIdentifier
+	Hash            crypto.Hash
+
+	// Salt, in lowercase hex, and Iterations are those the MAC key is
+	// derived with, taken from the PBKDF2 parameters for PBMAC1.
+	Salt       string
+	Iterations int
+}
+
+var cryptoHashByOID = map[string]crypto.Hash{
+	oidSha1Algorithm.String():   crypto.SHA1,
+	oidSha256Algorithm.String(): crypto.SHA256,
+	oidHmacWithSHA1.String():    crypto.SHA1,
+	oidHmacWithSHA256.String():  crypto.SHA256,
+	oidHmacWithSHA512.String():  crypto.SHA512,
+}
+
+// ParseMacData returns the parameters of the integrity MAC of pfxData,
+// without a password or decrypting anything, for checks such as a minimum
+// iteration count. It returns ErrMissingMAC if pfxData has no MAC.
+func ParseMacData(pfxData []byte) (*MacInfo, error) {
+	pfx, _, err := parsePfx(pfxData)
+	if err != nil {
+		return nil, err
+	}
+	if !pfx.MacData.present() {
+		return nil, ErrMissingMAC
+	}
+
+	info := &MacInfo{
+		DigestAlgorithm: pfx.MacData.Mac.Algorithm.Algorithm,
+		Salt:            hex.EncodeToString(pfx.MacData.MacSalt),
+		Iterations:      pfx.MacData.iterations(),
+	}
+	if len(info.DigestAlgorithm) == 0 {
+		info.DigestAlgorithm = oidSha1Algorithm
+	}
+	if !info.DigestAlgorithm.Equal(oidPBMAC1) {
+		var ok bool
+		if info.Hash, ok = cryptoHashByOID[info.DigestAlgorithm.String()]; !ok {
+			info.Hash, _ = registeredMACDigest(info.DigestAlgorithm)
+		}
+		return info, nil
+	}
+
+	var params pbmac1Params
+	if _, err = asn1.Unmarshal(pfx.MacData.Mac.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	info.Hash = cryptoHashByOID[params.MessageAuthScheme.Algorithm.String()]
+	if params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		var kdfParams pbkdf2Params
+		if _, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+			return nil, err
+		}
+		info.Salt = hex.EncodeToString(kdfParams.Salt)
+		info.Iterations = kdfParams.Iterations
+	}
+	return info, nil
+}
+
+// Mode is how the integrity of PFX data is protected, as returned by
+// IntegrityMode.
+type Mode int
+
+// Modes of integrity protection.
+const (
+	// ModeNone is PFX data with no MAC, whose integrity cannot be checked.
+	ModeNone Mode = iota
+
+	// ModePasswordMAC is the HMAC of RFC 7292, keyed by the PKCS#12 key
+	// derivation of the password.
+	ModePasswordMAC
+
+	// ModePBMAC1 is the PBMAC1 MAC of RFC 9579, keyed by PBKDF2 of the
+	// password.
+	ModePBMAC1
+
+	// ModePublicKey is a signedData authenticated safe, which this package
+	// does not decode; see ErrPublicKeyIntegrity.
+	ModePublicKey
+)
+
+// IntegrityMode returns how the integrity of pfxData is protected, without a
+// password or decrypting anything, so that it can be handed to the right
+// verification path.
+func IntegrityMode(pfxData []byte) (Mode, error) {
+	pfx := new(pfxPdu)
+	if _, err := asn1.Unmarshal(pfxData, pfx); err != nil {
+		return ModeNone, fmt.Errorf("error reading P12 data: %v", err)
+	}
+	switch {
+	case pfx.AuthSafe.ContentType.Equal(oidSignedDataContentType):
+		return ModePublicKey, nil
+	case !pfx.AuthSafe.ContentType.Equal(oidDataContentType):
+		return ModeNone, NotImplementedError("only password-protected PFX is implemented")
+	case !pfx.MacData.present():
+		return ModeNone, nil
+	case pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidPBMAC1):
+		return ModePBMAC1, nil
+	}
+	return ModePasswordMAC, nil
+}
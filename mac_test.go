@@ -1,7 +1,12 @@
 package pkcs12
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
 	"encoding/asn1"
+	"encoding/base64"
+	"strings"
 	"testing"
 )
 
@@ -36,3 +41,598 @@ func TestVerifyMac(t *testing.T) {
 	}
 
 }
+
+func TestVerifyMACExported(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	for _, algorithm := range []MacAlgorithm{SHA1, SHA256} {
+		p12, err := Encode(key, cert, nil, "mac", WithMacAlgorithm(algorithm))
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if err = VerifyMAC(p12, "mac"); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+		if err = VerifyMAC(p12, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected incorrect password, got err: %v", algorithm, err)
+		}
+		if _, _, err = Decode(p12, []byte("mac")); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+	}
+}
+
+func TestVerifyMACDigestAlgorithmForms(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "forms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+
+	type algorithmWithParameters struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.RawValue
+	}
+	type algorithmWithoutParameters struct {
+		Algorithm asn1.ObjectIdentifier
+	}
+	type macData struct {
+		Mac        interface{}
+		MacSalt    []byte
+		Iterations int
+	}
+	withMac := func(mac interface{}) []byte {
+		data, err := asn1.Marshal(struct {
+			Version  int
+			AuthSafe contentInfo
+			MacData  macData
+		}{pfx.Version, pfx.AuthSafe, macData{mac, pfx.MacData.MacSalt, pfx.MacData.Iterations}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	digest := pfx.MacData.Mac.Digest
+	for name, p12 := range map[string][]byte{
+		"NULL parameters": withMac(struct {
+			Algorithm algorithmWithParameters
+			Digest    []byte
+		}{algorithmWithParameters{oidSha1Algorithm, asn1.NullRawValue}, digest}),
+		"absent parameters": withMac(struct {
+			Algorithm algorithmWithoutParameters
+			Digest    []byte
+		}{algorithmWithoutParameters{oidSha1Algorithm}, digest}),
+		"absent algorithm": withMac(struct{ Digest []byte }{digest}),
+	} {
+		if err = VerifyMAC(p12, "forms"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if err = VerifyMAC(p12, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected incorrect password, got err: %v", name, err)
+		}
+		if _, _, err = Decode(p12, []byte("forms")); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyMACSha256(t *testing.T) {
+	// created with: openssl pkcs12 -export -macalg sha256
+	p12, _ := base64.StdEncoding.DecodeString(sha256MacTestData)
+
+	if err := VerifyMAC(p12, "m256"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Decode(p12, []byte("m256")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var sha256MacTestData = `MIIGvgIBAzCCBnQGCSqGSIb3DQEHAaCCBmUEggZhMIIGXTCCAwIGCSqGSIb3DQEHBqCCAvMwggLv
+AgEAMIIC6AYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAgGQdg07itM
+MwICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEMo03HWyJQpRyfw3K4hdu+qAggKADJxb
+kgp33sFHGkyj4KPl4FplzZdj7ZLjMG/qDWZ7UK27kTq2YftC3BR2Au0YOPw/+GkY0/eCk74Abx6P
+XeRa2k86M2dU5oVAKCcKuxJS6MhPN1uSAZNNJxc9HVD9HHaUWp07AOwJcdQBlDzkhlWH8ncRwil9
+aDpoSuoaMVxVDf2voIx1mnF6Vzva9KZYMEYRtvwoi0iLt6Lxs78tkXb7ZNNLrO3UJW/1LuBrHkQj
+ZatwOL0De75pcC0SOwYkDqiQ6z1t14T2cFmkNW898fgxFEAGmbOxndJZL8xwT9vnvDzMuyZ259T3
+rfVFVuIy6l9oq0Xq8YtZyHVXQIr3NLbnjtdg5dmxWPKO2aueAH8FMaFQBEbl0T6RDvrLXbMSVL2m
+/Tg+dfThAnDK+u+hiEdauhCiQB0JsTJANXM3KmiGS21BlYxQ6BuNuL6GhSomNuys/KfoRtqLuP9S
+J4TgRwFrkQm4t3Pu5R6dCDhtWr4VXHHw5phz3cUnflpYvsmJYL1NWH+mZd2ryHf9r4GVJZ4y2pm7
+VI4SxRY3Vw1VHo+iRBt/r48FOVP3MhDEuBBzq8fjr1eBQ0WpmBcOPmsauqNk2f7bo7xVMGHkbOTL
+E38sacxex+DlKaqtvY582Hyiji97vaCMP6rvq2CM4UoYofljNS8CYwZHIa2fwK7UE1XGpasm2k8i
+mNHuE4YaiXAsvFsii5aM3X0EH89eCs+tXNKtR/RH4hkZBLOw0m6oF/3OdwrB38fPecnOPe01AjiM
+U1YTkI53gvGLM2e+rjBaXVT8uTjXf2IfzCQC3JD2WXyrzNXe/g//WzlIgajcAzTCJ9CZ99Sr/zxI
+4KbTFWxmDq5TBTCCA1MGCSqGSIb3DQEHAaCCA0QEggNAMIIDPDCCAzgGCyqGSIb3DQEMCgECoIIC
+4TCCAt0wVwYJKoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECK33zMUuAUIlAgIIADAMBggqhkiG
+9w0CCQUAMB0GCWCGSAFlAwQBKgQQl8eX+uQTasM9YoSXfSpQGwSCAoAZ/vdAwza4bLcz3vF9WSbk
+iuMfdKE7hjM1RKZbFW4Mk3jzIT5x7+59kogPq3xPqeIICpW0oMZq7sY7rI7U5MJpvdoZSBnrJ1Ek
+CnfD9/QaOJAe0mUZ4lx+DLt2XFD/ySJpkAw5jVJLSiSLW7cN0pukudqFrHT6WCYJVSfAGgierKj6
+lDxwsKUWHlJVDb8maxDfcc/YV8WBFS75FrT7POCKbdbgEl+DIdMYIRnTgTdOkqaDx3kyjSbPJSNA
+SZjbwYJ1el7SW5U+ppt8kBnZ0C4d5ZEqD+iTXvflY20WUjdLTJmeKwBdxsYmV4WMBoH9vUAcrCiA
+HcxrU8cMtzBRJKoOsjYjiEpqZXNvac96tJAIFu7NmtHS2ZQzeDs9bUNennFxQEpN6WOScCyoyCWO
+h706fNe8tUGLPpDbQ6fMnss/gTRLfOYy1CJxWdp41WOS6x//D6l7Omy2u/vNJ9YwCDk+Yi9RdBFh
+SvMQBkOLmzBwOe2a441rImPSFlAwrRxsyjP8ivfbK5IUK/2NS+2JWNGzDFG0mgN/WfmWVH4SMxMi
+Ngl4Umr5wTtPY8l2i5vpkUgB6LiR1hFS0b/0jUaorx/0L5AHbBZwDeR4iyt4yk+v7sRKN4vnz2oC
+G+LAluFY6Z4Cx1+RzpmoMq24o4lwtmIoipN0FfRvv2N+dny9yet0WZe5AsGczh3PmaaaG9V1wO7n
+im51/i8L5PYO/MbcFC3bOvoxsZEhXXO4kDHtmvIEQ8fHOTcB2aYoZeA4XhBo8zDK5mHvRIAgZ0sG
+aUMhZQjSHK3KzBI33VuUgT3THeTCCPupZgz7OUy6hnSZctJF4J/tUGuZKvDupoM7ZnDJL2cAMUQw
+HQYJKoZIhvcNAQkUMRAeDgBmAGkAeAB0AHUAcgBlMCMGCSqGSIb3DQEJFTEWBBQjUDwNU22BRoIz
+JzAH6fTb5CpSCzBBMDEwDQYJYIZIAWUDBAIBBQAEIKADar+7+aoz7fQgzNvLktLNjFUXuB8Nt86/
+08o9KbsnBAiWhBqgS1AkogICCAA=`
+
+func TestVerifyMACNonCanonicalEncoding(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(unsortedAttributesTestData)
+
+	// the attributes of the bags are not in the sorted order DER
+	// requires for a SET OF, so a re-encoding of the parsed authenticated
+	// safe would not match the octets the MAC was computed over
+	authSafe, err := AuthenticatedSafeBytes(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localKeyID, _ := asn1.Marshal(oidLocalKeyID)
+	friendlyName, _ := asn1.Marshal(oidFriendlyName)
+	if bytes.Index(authSafe, localKeyID) > bytes.Index(authSafe, friendlyName) {
+		t.Fatal("expected the test data to have unsorted attributes")
+	}
+
+	if err = VerifyMAC(p12, "unsorted"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries, err := DecodeAll(p12, "unsorted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].FriendlyName != "unsorted" || entries[0].PrivateKey == nil {
+		t.Errorf("expected a single private key named 'unsorted', found %d entries", len(entries))
+	}
+}
+
+// unsortedAttributesTestData holds a private key and its certificate, whose
+// localKeyId attributes precede their friendlyName attributes.
+var unsortedAttributesTestData = `MIIJcgIBAzCCCTgGCSqGSIb3DQEHAaCCCSkEggklMIIJITCCA8cGCSqGSIb3DQEHBqCCA7gwggO0
+AgEAMIIDrQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQI4N5/a8+21eYCAggAgIIDgLKTd9I7
+nYwvJOJIghDYf2hWpMCtCKtOJLXf+fANaTgy6H++eASxaOTp/+YtUfMMxFmqYW1bSwdZ2B3j4Ha3
+/a6y1x5/F/IGWYZsn1eB6qsWBJtoSKHLwa6zZXD+fsmSnJzNuw/nwuckYYaKr+GUduZkVs/tfgxF
+QPeiCMvY79ZzBoTew3YljjO3CaMgxvTb93uTxG6eFnPcT23X2r9Tg/cT3yMzK4EjKY/JJ5X2wNKc
+kcgHBHYt+TJ1RUyoXZyc5gyY62E7kdkTYxXcyunvAHmu+aQpdOnxngEzfHURdAGYrKQrMT0Sqrs8
+I0fKzr1kHiv9DBvQL/2/NPVhT83efOYm12gl5pOYciUYAf5UkZE/DxABqRaF6kKlLuUh8LxKWtUv
+UUkvhYTi4cRpc/vMgoGIhaJHkOLKykouhB8vu4dJK3Ne63LuWGRI7R5/anxLUM1ur2wTlc+C4GnM
+Lz+trc8j4/LBq+7+E5nzFQj6Mv3iel6jINFKHDfLYcWvjKGP7ThxtM3/u53q9wsqyfQV6B/Y90+H
+tuv2pQuO9B2MvAYll6W7jLFT4RmjAw0JPWvSgdgLFfB3Zj7IcEKPDNq5sSh1mQOyJE7EJeAZLDAC
+ta5UBSKWoTbwwKG/sJm+PshEe04IcXy5VWrzIVFpEaQ7obMbZjtKhIMbsARnqBr+Xh7kpty5SokC
+vs99+VJXm+joyWy5boKA1L14PR4QEdpqpnxDs4mK6aAqzrd81i75LDPlG4IhSfXYwzloilp+y2PA
+dBNogxULGUyVVw3g6dIWTkj2u2bWF+sRMsrj8Rv7EfeUWjhgOldYFDm7avB+8VQrm3pN7DAt2/N6
+kIc3NkTVUTOOVtRP/kQWeVTVtXrxZqBWcW/wEWYR1cYZTOoIaNjU5k8YMHa5c+VqDACnL2n3wlve
+L/mdRAx3/IQ7zDtg0+wt0WGsW/IHt4kAc0RDVFoHWjvMYun/2tCP2ALh4LhnngEdjKvmp2EVo5E4
+xzRwzq2CSzZ9N3021zTNVfOUKfMg2Srq/muHfim5wVkWCzqq/y1cEjHsnOylM0taBbnXiv7sQko4
+3OG1VXB264CVde/aIS67pv0qvoWQrB8nH4sAfs0vqtH6/qQtPRozV3Ur+hlkZlaJJO5vOkjrwf6S
+mww2KK03/R32frfsFgYsIp0zj+W0dFsOunvqEQJxeWPncoMUMIIFUgYJKoZIhvcNAQcBoIIFQwSC
+BT8wggU7MIIFNwYLKoZIhvcNAQwKAQKgggTuMIIE6jAcBgoqhkiG9w0BDAEDMA4ECMK77yFekE38
+AgIIAASCBMhb5VxeOmNJ/B3bJeVdgK9snAqL4iByRCzs04V39EZnV1AQbV8YuEVsYpTAOg/U50mh
+F1xQRwXKQ2QVMb2DinW89AypmNbEumfTzIGcGibyTAhas6vMRvn0zfFsV7Led3jOMecPBSiABPC4
+Vab+1US6I3baiJFwY4eAXXnKrBDvmdbjmqNIAAlG7ar5Mijrn1qSxK5vlzWA44aemdat7d3eumU4
+VgnE0vk46bRHeSbjaf1K1SjxSjVFtxWjin8jlOIT1HTSSNZ6InUOBjpWDL/oXiZRNeBdT+MBz6z7
+XMtY/VHj97uDbXRdUhuL4oRHLPhQVrQALOyP44/pRCmqajNoLtLR2qr9R7pblXrA8FVsfz0eFHiJ
+llhDMqCyAa+KrLLd2qufplVd2jN+jG8OL3aAOQu2P/RcRKWyAbdEdZl4kWUGukcAzvhAk36yFXH6
+bJicYQ7BwDSCfx9diJWTjb6fF5QB5TNme0APv/EcTHrw6KoK//BYDiSuzsqOOOrbZMarYVoH/v0v
+FQB6sLmPkniVikelSI/vNUxPVGTaibzkX8cZo888Cdqr+dawYjKWcjsRxaNxTIu6B2Pi8tABjEcg
+zLmKoZZn99O1eRugQDenNrNacxcT9Nir/NKHz62JvE0TRZ3VAhUNAl5tGdvLTXe66QaNpacdiFPt
+NwcZjVRBP40rcqsCAVjjOeH6EYutyoYEPAVVLd0swBiGc3l7Uj+BaDYPAYWDj6k3sxAEqxh8amnf
+f6HZe8pFT+DhePfDiLTp7pJsuraWD0QROc5OfnQR16AHX6Jw1fNhMgZ6iwfyqwLHXVGjZxvnHDft
+uuDbLJ07ykbcJ5BO2FtziVgkPAJLs5lIZKDWrA9msp44K9u1gQBnBsnSWPGMgNwDyJCUWVq7rAmI
+4jBylDqboa518jAcR/YwiIjivo5OHhxffDJr+Q39z7BNsulyaiYzd/Byv3B2fuwjvTU0W/zmOIj3
+iC869TELM/5DNjry+vsl/qa6foT0/P6kCCK2YHnYfUzCHcaNsVX+0fHigsXMEkp0izHvQoXdXgkG
+cPTDnWuHQq26a7F6E0glTwMi3n9uIU3i8AFib4GMAceApF72QiDud8XJ4D10DzCfM1YTlruNGuzG
+ydtl/48yjJrWYu2F6aFa3hCUkuHgLYyS/PEW7nNNyx8bTIYPKlaZlYhKUrzG5DARo3n2W7kNPc+X
+ND62xlcQ6tQkm7pnUycJ33C4dvbqV65A8vd6DO+DjDNAV2LfPp+eQNicWsatHbLJ41IWzyv5oLtf
+e7wgsqZz5gLCsY/jhoJg8+5/Lge6r00zvvgaTyhChMVRsqFlTaT1MuoefbplfcGNNyUoRZy+bsQN
+3KtgIRhLaf0xJH7L3ofi5hLoRmtDE/XwbCDTF/qDwzHL9Vo3hmYH1XtdL90OUP6ELgcf4KecM4+L
+KwFZVudJomE9KYAVXXVmxfr/NqaiQc8/Waj8lGh/9yuzuinaLNXmuqlE2a3ru81KrCGdrBCF2UEK
+/EFpIEC8G7th0F0wV4/c/DcDHJXsqwy/2x/jI6pvHyIgRoT/oiDMV3ZJWQs0XUIvDW24+tfeGkhE
+NhBJAlJKiPHCpM7t+CZzY43zjUDI6pm1otpHOj/7ZC8DADExNjATBgkqhkiG9w0BCRUxBgQEAQID
+BDAfBgkqhkiG9w0BCRQxEh4QAHUAbgBzAG8AcgB0AGUAZDAxMCEwCQYFKw4DAhoFAAQUoGJolo6A
++FUqemjYLXPNZUI1v+4ECGxTp+cwQ9FdAgIIAA==`
+
+func TestPBMAC1(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	for _, hash := range []MacAlgorithm{SHA1, SHA256} {
+		p12, err := Encode(key, cert, nil, "pbmac1", WithMacAlgorithm(PBMAC1), WithPBMAC1Hash(hash), WithMacIterations(1000))
+		if err != nil {
+			t.Fatalf("%s: %v", hash, err)
+		}
+
+		pfx, _, err := parsePfx(p12)
+		if err != nil {
+			t.Fatalf("%s: %v", hash, err)
+		}
+		if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidPBMAC1) {
+			t.Errorf("%s: expected PBMAC1, found %s", hash, pfx.MacData.Mac.Algorithm.Algorithm)
+		}
+		if string(pfx.MacData.MacSalt) != "NOT USED" || pfx.MacData.Iterations != 1 {
+			t.Errorf("%s: unexpected MacData salt %q and iterations %d", hash, pfx.MacData.MacSalt, pfx.MacData.Iterations)
+		}
+
+		if err = VerifyMAC(p12, "pbmac1"); err != nil {
+			t.Errorf("%s: %v", hash, err)
+		}
+		if err = VerifyMAC(p12, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected incorrect password, got err: %v", hash, err)
+		}
+		if _, _, err = Decode(p12, []byte("pbmac1")); err != nil {
+			t.Errorf("%s: %v", hash, err)
+		}
+	}
+}
+
+func TestRegisterMACDigest(t *testing.T) {
+	// created with: openssl pkcs12 -export -macalg sha512
+	p12, _ := base64.StdEncoding.DecodeString(sha512MacTestData)
+	oidSha512 := asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	if _, ok := registeredMACDigest(oidSha512); !ok {
+		if err := VerifyMAC(p12, "m512"); err == nil {
+			t.Fatalf("expected an unregistered digest to be refused")
+		} else if _, ok := err.(NotImplementedError); !ok {
+			t.Errorf("expected not implemented error, got: %T %s", err, err)
+		}
+	}
+
+	RegisterMACDigest(oidSha512, crypto.SHA512)
+	if err := VerifyMAC(p12, "m512"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMAC(p12, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected incorrect password, got err: %v", err)
+	}
+}
+
+func TestAuthenticatedSafeBytes(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(sha256MacTestData)
+
+	authSafe, err := AuthenticatedSafeBytes(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, _, err := parsePfx(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := bmpString([]byte("m256"))
+	mac, err := generateMac(sha256Algorithm, authSafe, pfx.MacData.MacSalt, password, pfx.MacData.Iterations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mac, pfx.MacData.Mac.Digest) {
+		t.Errorf("expected the MAC over the returned bytes to match the PFX")
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(sha256MacTestData)
+
+	if ok, err := ValidatePassword(p12, "m256"); !ok || err != nil {
+		t.Errorf("expected the password to be valid, got %v, %v", ok, err)
+	}
+	if ok, err := ValidatePassword(p12, "wrong"); ok || err != nil {
+		t.Errorf("expected the password to be invalid, got %v, %v", ok, err)
+	}
+	if _, err := ValidatePassword(p12[:len(p12)/2], "m256"); err == nil {
+		t.Errorf("expected an error for truncated data")
+	}
+
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	noMac, err := asn1.Marshal(struct {
+		Version  int
+		AuthSafe contentInfo
+	}{pfx.Version, pfx.AuthSafe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidatePassword(noMac, "m256"); err != ErrMissingMAC {
+		t.Errorf("expected missing MAC, got err: %v", err)
+	}
+}
+
+var sha512MacTestData = `MIIG3gIBAzCCBnQGCSqGSIb3DQEHAaCCBmUEggZhMIIGXTCCAwIGCSqGSIb3DQEHBqCCAvMwggLv
+AgEAMIIC6AYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAh0se32VL/U
+HAICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEMindEYA7AWxRA5zmnCs35qAggKAwruK
+gR1FF3jSfKl3UVcjzKByVc1WYVgVmm3T5yr9lGSgfbQeBmKzhowqrelFBP4RvEbeES8y3PK9symu
+s4BvkxRIOJ48yY6vpyXu7Ued4d4jiGCdpRS5H/Ayjirk7qVgs0qRfbyM+rVy+gB1VhTHhcGbeYZb
+uXxDV8FCMJO1r3z25UJBzRJiJuK/ll9VWpKbm997a21hJKDc3iPOC4LecaC6lDJeYxzoN7BwZjIh
+rTSv+xpOfR3ieVQSqkTVW1c+8427ameW53LcKAnzKAZp+RHP/ExaTneakZ0mzXiZwMedfDsi8fwY
+RC9Tc4wSAbnVh21iLCYIxnDRvQFJ0t2jCG184POVOdZbXP9aS8KDdmkqlIqPi+dTWueBjLKCUZbN
+e2skgUjtmlwrNA7EPn/4xukqzII2wVfpwngGgIiPTQZb70GTFVY+a8fPQTzFCd+IC0f0lNM7eInC
+FVDV44pxeQqt2xrbaQtw3ZXdnpdhtb4hoyszzPJHxMnpt9OcJSVIjTKXJk1n60LzQrgcwxKKGUFv
+hdrVKgGkjGOh5RWeN4h2h1DTVMgHx6mimcL7cYPnNlJh9mAhbxzrpM2UVS898uQxMOt9QNPsNkzG
+OH7OqfSRO9Ms+B0pRVDGL0Tu9uGRuo9NP6TT2WYs6PV2aN+SaCB/2Rm/2MZzw0qmA0DnSvk5fMg3
+oDgB9thV1TGzP1mQxD2TS2uc6zHnsIfMgBm1ESKpXXPk7kzECOkge8Ro7IXHCIw0dw7n1aBuiDCq
+VikTAoRAZCK7wBLNYpsIeb6d8xLVHYNSpCj7bfWn/nf+p15OEQYKf++7I9XLj2XHk591XeKZZhRz
+3gfVz+u/1vwj+jCCA1MGCSqGSIb3DQEHAaCCA0QEggNAMIIDPDCCAzgGCyqGSIb3DQEMCgECoIIC
+4TCCAt0wVwYJKoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECCnhd381FzuaAgIIADAMBggqhkiG
+9w0CCQUAMB0GCWCGSAFlAwQBKgQQSFwk8PBK7b0hVc+W10zURQSCAoDhqeRaelxjQtttan9YrjlK
+5KWQRLOQjPHKTaF0XD4cP6hZ5SSrUCbH/q7P2LnYAkrqyOSy+9aRWmkpcBKj+7nPhGdAO9oHFHly
+GNSlKOvbtbAKj0ro//niojJgRjXj6VeTDe2wcEL004JDESfqSC+qQg11o0rKq7TBFaNuwdQPld4V
+4246EKPWacmiudGyv8eQiTupyk+++ZPfOSXqLVYb/TvhmOV/m/5kbZvpL+EQrNYh4z883P+uSBBd
+4Xy2/9YAtmQPSUKZonkiefj7kZLS8LkSyb2m6xtDIadG5/ookzLD7u1jkngjj6ZsuBctDPXQTE3T
++a6Gv+ecLZJ+TITyTSvSwZ7TYp1zCtosKYLpJ3utowBxSRXwCJB3Re482RZ5k7LcgHHUi7d5zPbO
+CdJpYQ5Tzp1oSy2b1seUACUKUAtZZk+42/NnMbtdDTLW+LGMVMbPB1+7HzinBIwuTwE0iaKoi0f4
+NH34Kz+xHRAiavsstwdJfxhoKHqsNtGoIGOiguCd7OFuMiFJ1SWD9di1+hmT4lLaXlgRiMI7CKR9
+MofhYAZDfusIxSt2q/rIH2riVPY1bA3Kquiq7I3wVxTlg/HcQiKzLeHmP6X8WzJImATh5O+N5gLv
+aQfecmIWQtYf7n4un6N4wNOK4R944VynUMRTRl7qMEp/o1Pi1u0jLf1yJQYiLPcktF4erAdSxnco
+GRyMheih/6YDeLPGYX88tF786x/1TGowm3zMiwRz3DDvAnwiKoxMdnD4/eHUL4l7wx5TXO2RLddk
+Eh62uEcRKcwN+EN6lvI3jycaCtJWPywuApAJP7cY99bmPwGDn++OgBj4VPAFIbG3ulkwHTHFMUQw
+HQYJKoZIhvcNAQkUMRAeDgBmAGkAeAB0AHUAcgBlMCMGCSqGSIb3DQEJFTEWBBQjUDwNU22BRoIz
+JzAH6fTb5CpSCzBhMFEwDQYJYIZIAWUDBAIDBQAEQN1EHZbGjJKmwg4VAzbQXosSuv4ZZXwbnILJ
+kTPbaaDJ9MtzNhgcoP65ZQPrTXa8PVUv0nZKIasJq3AOQldSHt8ECJGMwXpUAhtmAgIIAA==`
+
+func TestMacSaltIndependentOfContentSalts(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbeSalt := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	for _, macSalt := range [][]byte{pbeSalt, {8, 7, 6, 5, 4, 3, 2, 1}} {
+		certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd40BitRC2CBC, pbeSalt, 1000)
+		certs.AddCertificate(cert.Raw)
+		keys := NewContentInfoBuilder()
+		keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, pbeSalt, 1000)
+		pfx := NewPFXBuilder(macSalt, 3000)
+		pfx.Add(certs)
+		pfx.Add(keys)
+		p12, err := pfx.Build([]byte("salts"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = VerifyMAC(p12, "salts"); err != nil {
+			t.Errorf("MAC salt %x: %v", macSalt, err)
+		}
+		if _, _, err = Decode(p12, []byte("salts")); err != nil {
+			t.Errorf("MAC salt %x: %v", macSalt, err)
+		}
+	}
+}
+
+func TestProbePasswords(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matched, err := ProbePasswords(p12, []string{"", "wrong", "probe", "other"}); err != nil || matched != "probe" {
+		t.Errorf("expected 'probe' to match, got %q, err: %v", matched, err)
+	}
+	if _, err := ProbePasswords(p12, []string{"wrong", "other"}); err != ErrIncorrectPassword {
+		t.Errorf("expected incorrect password, got err: %v", err)
+	}
+	if _, err := ProbePasswords(p12, nil); err != ErrIncorrectPassword {
+		t.Errorf("expected incorrect password without candidates, got err: %v", err)
+	}
+	if _, err := ProbePasswords(p12[:len(p12)/2], []string{"probe"}); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+}
+
+func TestParseMacData(t *testing.T) {
+	key, cert := testIdentity(t)
+
+	for _, test := range []struct {
+		opts       []EncodeOption
+		algorithm  asn1.ObjectIdentifier
+		hash       crypto.Hash
+		iterations int
+	}{
+		{nil, oidSha1Algorithm, crypto.SHA1, 2048},
+		{[]EncodeOption{WithMacAlgorithm(SHA256), WithMacIterations(4096)}, oidSha256Algorithm, crypto.SHA256, 4096},
+		{[]EncodeOption{WithMacAlgorithm(PBMAC1), WithMacIterations(10000)}, oidPBMAC1, crypto.SHA256, 10000},
+	} {
+		opts := append(test.opts, WithGenerateSalt(func(length int) ([]byte, error) { return bytes.Repeat([]byte{0x42}, length), nil }))
+		p12, err := Encode(key, cert, nil, "info", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ParseMacData(p12)
+		if err != nil {
+			t.Fatalf("%v: %v", test.algorithm, err)
+		}
+		if !info.DigestAlgorithm.Equal(test.algorithm) || info.Hash != test.hash || info.Iterations != test.iterations || info.Salt != strings.Repeat("42", test.hash.Size()) {
+			t.Errorf("%v: unexpected %+v", test.algorithm, info)
+		}
+	}
+
+	var pfx pfxPdu
+	p12, _ := Encode(key, cert, nil, "info")
+	if _, err := asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	noMac, err := asn1.Marshal(struct {
+		Version  int
+		AuthSafe contentInfo
+	}{pfx.Version, pfx.AuthSafe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseMacData(noMac); err != ErrMissingMAC {
+		t.Errorf("expected missing MAC, got err: %v", err)
+	}
+}
+
+func TestVerifyMACDefaultIterations(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(noMacIterTestData)
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	zero, err := asn1.Marshal(struct {
+		Version  int
+		AuthSafe contentInfo
+		MacData  struct {
+			Mac        digestInfo
+			MacSalt    []byte
+			Iterations int
+		}
+	}{pfx.Version, pfx.AuthSafe, struct {
+		Mac        digestInfo
+		MacSalt    []byte
+		Iterations int
+	}{pfx.MacData.Mac, pfx.MacData.MacSalt, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"absent": p12, "zero": zero} {
+		if err = VerifyMAC(data, "once"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if err = VerifyMAC(data, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected incorrect password, got err: %v", name, err)
+		}
+		if entries, err := DecodeAll(data, "once"); err != nil || len(entries) != 1 {
+			t.Errorf("%s: expected the fixture identity, got err: %v", name, err)
+		}
+		if info, err := ParseMacData(data); err != nil || info.Iterations != 1 {
+			t.Errorf("%s: expected 1 iteration to be reported, got %+v, err: %v", name, info, err)
+		}
+	}
+}
+
+// noMacIterTestData was created with
+// openssl pkcs12 -export -nomaciter -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1
+// which leaves the iterations of MacData out, for the default of 1
+var noMacIterTestData = `MIIF9QIBAzCCBb8GCSqGSIb3DQEHAaCCBbAEggWsMIIFqDCCAqcGCSqGSIb3DQEHBqCCApgwggKU
+AgEAMIICjQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQIxwEdD7NUe0wCAggAgIICYOrt7OrA
+zR3d/RL4UBGZnE6uLqZQHFuGqMFF0jv4RCuFEopDEdi1wD7j8RV3upUc7yjjT5A92XOomYrGG3YT
+Y8/A5sUh0LE1i8FXq1e9+bJ42S3e+6019++w85gTKzSy3yj0CjbFe2LvU3FzkC3MdKEenkC8Ee2C
+M8Ip8xbG7yq7DAsc/HKNDE+AW6i4UHhaXPF0CQGIqdnIwLVSwBzSxYBgCciLiI0C6xIadY7nTNCl
+BLTrHguDYJYY0Ml71Ok4OK+uCeH//FDfLZbfa0uYkDMOFlbbfNAs4JEYGrAkmB8ZWEOFYY+BMX8P
+a0c+L07tlH0WEz9Wa9apT8zewEbS0kZOaVNsOfBiZrA0tvcKJFNKlyWeSFxLPoaaeZUiAGG2/33a
+PsrGVtFo1dH7mKU0oktSE1f09xpntaL5xBdexgY3Z8H6JoaE4uSjinT1nF9Xt8vnvxgBoKj3q2UQ
+VTf6xAawW1cwKBlzv0qxQgynHtc76vvszA/bjg89MjD46SfCeW1iSgpoE3SEdYXh3l6nnrhnwvq1
+jIFZ1fTJVUS/4KKb67ypAl7Ra/ToBDiyGNNM0cANUmQPfMAFMHj8s0O43Gh368t/1trIkfeimdgT
+EFFENfZBO0CW6rX9wG/38b7C8UZXAsulFWhbzkJ5XTf7SijnQ9L80gWH0uzywCp2qGryUs2Svpx6
+oaRmIjCAX+jGGLGQ8wd8ssBT72+fkEhiUBDsjCz0dIbSXwmvzKtTuVJ6zxH1NSARq7s99TMiSskW
+s7KZBd+FvFv9xdeN9drk6dBMbIK1fTf6wb1N93TQN3G2MIIC+QYJKoZIhvcNAQcBoIIC6gSCAuYw
+ggLiMIIC3gYLKoZIhvcNAQwKAQKgggKmMIICojAcBgoqhkiG9w0BDAEDMA4ECJPc9K3Z1OsQAgII
+AASCAoAfL4qP7IK/F6u4WV7p7jFAQ7UXsLPjeYP0omQNR+K7gyo8dB+5WouwoV3/9xqDGOa1mhYD
+9oO58KJWSrB6mzZ6iY5bkGtXsm29Uox3IbOwAIoSi6FKaBBkVZ6pcAm2lbwtaG4FoE2kJOscRuhW
+H7pN4LCU3A3wyV7w5cCG297jLBVbLKEBwZVuYx5WyXjUQyoLIzQKkBc8UGTQ2+NT1s0k0YvrpOqp
+ARhrE5AqnLEVAcGI1jKIlPd5DjE8iMTBZ7W/jmjETN4UCdunrap3hqheXQF6CbmzBcfDtfXyliYN
+3EaEbV3ocXFi+OaHea/jDKVhcofiMk+dXb5SHXldizVKbb0IVDBbw9NqOmivXS1Iky4e+Uu4Ng+U
+7cVR+T1eShTbksUEFvX6Dgsdn1OZQI/DeZbjbd06/vdYMfTKbwCl4ckU5Z9S8wuuzVNe0AA4zjP1
+wQqd08Do8bqKBGTorm+EOgsu1AM0Jl2En3qQXj1A2+s8DQfRPDFK84DvExK2tA5q2cFueYp+pTkv
+dHbDnhpWSAJdKg9qo5OQDnloRs+NOVtIv9B3vXKiIrg6YgMuVqykmzToi+gzYhiJvYTAoTOM4hd0
+fCBaPNaJTxegEpa9Tbzp3SHLnfN4CLst8H5ER8y6SeT7BHlhVtyc76MSSMbgQBjg01iKbaS3mbR9
+Pok1jzeiefA7ClbvJoDE8Bq6elUj1mIDHeHWh/XFh4xPEWep59uU0yNJSmZVxdHsbIiEp+VLPSDn
+Kc+QRhjVbCpXLudyqGXQu6MiIQvMzmeDCNSc0evtzqPrjCZMwb0+Tj02kYYilBURHlsYf6MpY1TM
+Fsf0mKz28d5jn9M01jtUCQK3MSUwIwYJKoZIhvcNAQkVMRYEFCNQPA1TbYFGgjMnMAfp9NvkKlIL
+MC0wITAJBgUrDgMCGgUABBRTNVK/LYjxEOpNGODHYdwITHKIQwQIgBLZ6djSyHM=`
+
+func TestIntegrityMode(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbmac1, err := Encode(key, cert, nil, "mode", WithMacAlgorithm(PBMAC1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	noMac, err := asn1.Marshal(struct {
+		Version  int
+		AuthSafe contentInfo
+	}{pfx.Version, pfx.AuthSafe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := asn1.Marshal(pfxPdu{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidSignedDataContentType,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x30, 0}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		data []byte
+		mode Mode
+	}{
+		"password MAC": {p12, ModePasswordMAC},
+		"PBMAC1":       {pbmac1, ModePBMAC1},
+		"no MAC":       {noMac, ModeNone},
+		"signedData":   {signed, ModePublicKey},
+	} {
+		if mode, err := IntegrityMode(test.data); err != nil || mode != test.mode {
+			t.Errorf("%s: expected mode %d, got %d, err: %v", name, test.mode, mode, err)
+		}
+	}
+
+	if _, err = IntegrityMode([]byte("garbage")); err == nil {
+		t.Error("expected garbage to fail")
+	}
+}
+
+func TestRotateMACPassword(t *testing.T) {
+	key, cert := testIdentity(t)
+	for name, opts := range map[string][]EncodeOption{
+		"SHA-1":  nil,
+		"PBMAC1": {WithMacAlgorithm(PBMAC1)},
+	} {
+		p12, err := Encode(key, cert, nil, "content", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = RotateMACPassword(p12, "content", "wrong", "mac"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected the old MAC password to be verified, got err: %v", name, err)
+		}
+		if _, err = RotateMACPassword(p12, "wrong", "content", "mac"); err == nil {
+			t.Errorf("%s: expected the content password to be checked", name)
+		}
+		rotated, err := RotateMACPassword(p12, "content", "content", "mac")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		before, _ := AuthenticatedSafeBytes(p12)
+		after, err := AuthenticatedSafeBytes(rotated)
+		if err != nil || !bytes.Equal(before, after) {
+			t.Errorf("%s: expected the authenticated safe to be kept byte for byte, err: %v", name, err)
+		}
+		if err = VerifyMAC(rotated, "mac"); err != nil {
+			t.Errorf("%s: expected the new MAC password to verify, got err: %v", name, err)
+		}
+		if err = VerifyMAC(rotated, "content"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected the old MAC password not to verify, got err: %v", name, err)
+		}
+		if _, _, err = DecodeSeparatePasswords(rotated, "mac", "content"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		back, err := RotateMACPassword(rotated, "content", "mac", "content")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(back, p12) {
+			t.Errorf("%s: expected rotating back to restore the original file", name)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"time"
+)
+
+// PasswordFunc returns the UTF-8 encoded password to decode a PFX with.
+// It is called at most once per decoding attempt, and the returned buffer is
+// zeroed by the package as soon as it has been converted.
+type PasswordFunc func() ([]byte, error)
+
+// PasswordNormalizer is a Unicode normalization form, such as a norm.Form of
+// golang.org/x/text/unicode/norm, that a password can be converted to.
+type PasswordNormalizer interface {
+	// Bytes returns the UTF-8 encoded b in the normalization form. It may
+	// return b itself.
+	Bytes(b []byte) []byte
+}
+
+// DecodeOptions controls how PFX data is decoded.
+type DecodeOptions struct {
+	// Password is called to obtain the password for each decoding attempt.
+	// If nil, the empty password is used.
+	Password PasswordFunc
+
+	// MaxAttempts is the number of times Password is called before giving up
+	// when the MAC does not verify. Values less than 1 mean a single attempt.
+	MaxAttempts int
+
+	// AllowedAlgorithms, if non-nil, lists the only encryption algorithms
+	// that will be decrypted. Strict callers can use it to refuse legacy
+	// algorithms such as RC4 and 40-bit RC2.
+	AllowedAlgorithms []asn1.ObjectIdentifier
+
+	// RejectSignatureAlgorithms lists signature algorithms, such as
+	// x509.SHA1WithRSA, that decoded certificates must not be signed with.
+	// Decoding fails on the first certificate that is.
+	RejectSignatureAlgorithms []x509.SignatureAlgorithm
+
+	// RejectExpired makes Decode and DecodeChain fail if the certificate
+	// they return, the leaf, is not valid at the current time: if its
+	// NotAfter is past or its NotBefore is yet to come. CA certificates are
+	// not checked.
+	RejectExpired bool
+
+	// Now, if non-nil, returns the current time for RejectExpired, in place
+	// of time.Now.
+	Now func() time.Time
+
+	// MaxBags is the number of safe bags, across all ContentInfos, that are
+	// decoded before giving up with ErrTooManyBags, to protect services that
+	// accept untrusted PFX data. Values less than 1 mean DefaultMaxBags.
+	MaxBags int
+
+	// MaxInputSize is the size in bytes of the largest PFX data that is
+	// parsed, for the same reason. Larger input fails with ErrInputTooLarge
+	// before any of it is parsed, and DecodeReader reads no more than one
+	// byte past it. Values less than 1 mean DefaultMaxInputSize.
+	MaxInputSize int
+
+	// AnyVersion decodes PFX data whatever the version it declares, rather
+	// than failing with a NotImplementedError unless it is 3, the only
+	// version RFC 7292 defines. Metadata.Version reports the declared one.
+	AnyVersion bool
+
+	// SkipMACVerification decrypts the PFX without verifying its MAC, for
+	// recovering the contents of a file whose MAC is corrupted or of an
+	// unsupported kind. WARNING: this gives up on detecting tampering, and
+	// an incorrect password is then only noticed, if at all, as garbled
+	// contents. Password is called once, and Metadata.MACVerified reports
+	// false.
+	SkipMACVerification bool
+
+	// NormalizePassword, if non-empty, lists the Unicode normalization
+	// forms the password is tried in, in order, until the MAC verifies, for
+	// passwords typed on platforms that compose characters differently.
+	// The password is then not tried as given, unless it is unchanged by
+	// one of the forms. norm.NFC and norm.NFD of
+	// golang.org/x/text/unicode/norm are such forms.
+	NormalizePassword []PasswordNormalizer
+
+	// TryUTF8Password retries, when the MAC does not verify with the
+	// password as a BMPString, with the raw UTF-8 bytes of the password, as
+	// some nonconforming producers give the PKCS#12 key derivation. That is
+	// the derivation of the MAC and PBES1 encryption; PBES2 contents are
+	// not expected from such producers, and do not decrypt.
+	// Metadata.PasswordUTF8 reports whether the MAC verified that way.
+	TryUTF8Password bool
+
+	// TryNULTerminatedPassword retries, when the MAC does not verify with
+	// the password, with the password followed by a single NUL character,
+	// as producers built on C libraries that count the terminating NUL of
+	// the password into its length derive their keys; the BMPString then
+	// ends with that NUL before its own NULL terminator. Such passwords are
+	// tried after those of NormalizePassword and before those of
+	// TryUTF8Password. Metadata.PasswordTrailingNUL reports whether the MAC
+	// verified that way.
+	TryNULTerminatedPassword bool
+
+	// Lenient accepts encrypted contents whose PKCS#7 padding does not
+	// validate if, taken as unpadded, they are a complete ASN.1 value, to
+	// recover files from encoders that leave out the block of padding when
+	// the plaintext is already block-aligned. It also accepts, outside of
+	// Decode, cert bags holding several concatenated certificates, each of
+	// which is decoded as an entry, decodes the shrouded key that some
+	// producers nest in a secretBag as if it were a pkcs8ShroudedKeyBag, and
+	// reads an AuthenticatedSafe placed directly in the PFX PDU without its
+	// data ContentInfo. An encryption iteration count of 0 is taken as 1, as
+	// the few tools that write it mean, rather than refused. It is never the
+	// default, as it weakens the detection of an incorrect password or
+	// corrupt data, and none of this conforms to RFC 7292.
+	Lenient bool
+
+	// Metadata, if non-nil, is filled in with what was learned about the
+	// encoding of the PFX data while decoding it.
+	Metadata *Metadata
+}
+
+// DefaultMaxBags is the number of safe bags decoded when
+// DecodeOptions.MaxBags is not set. It is far more than keystores hold in
+// practice.
+const DefaultMaxBags = 10000
+
+// maxBags returns the effective opts.MaxBags.
+func (opts *DecodeOptions) maxBags() int {
+	if opts.MaxBags < 1 {
+		return DefaultMaxBags
+	}
+	return opts.MaxBags
+}
+
+// DefaultMaxInputSize is the size of the largest PFX data decoded when
+// DecodeOptions.MaxInputSize is not set, 64 MiB. It is far more than
+// keystores hold in practice, even with DefaultMaxBags certificates.
+const DefaultMaxInputSize = 64 << 20
+
+// maxInputSize returns the effective opts.MaxInputSize.
+func (opts *DecodeOptions) maxInputSize() int {
+	if opts.MaxInputSize < 1 {
+		return DefaultMaxInputSize
+	}
+	return opts.MaxInputSize
+}
+
+// checkInputSize returns ErrInputTooLarge if p12Data is larger than
+// opts.MaxInputSize allows.
+func (opts *DecodeOptions) checkInputSize(p12Data []byte) error {
+	if len(p12Data) > opts.maxInputSize() {
+		return ErrInputTooLarge
+	}
+	return nil
+}
+
+// Metadata describes how PFX data was encoded, so that it can be re-encoded
+// the same way.
+type Metadata struct {
+	// Version is the version declared by the PFX PDU.
+	Version int
+
+	// PasswordNullTerminated reports whether the password was used with its
+	// terminating BMPString NULL, as RFC 7292 specifies. Some producers leave
+	// it out, in which case the MAC only verifies without it.
+	PasswordNullTerminated bool
+
+	// MACVerified reports whether the integrity of the PFX data was verified
+	// with its MAC. It is false when there was no MAC, or when
+	// DecodeOptions.SkipMACVerification was set.
+	MACVerified bool
+
+	// PasswordUTF8 reports whether the MAC only verified with the raw UTF-8
+	// bytes of the password, with DecodeOptions.TryUTF8Password. Encoding
+	// always uses a BMPString, so re-encoding such a file normalizes it.
+	PasswordUTF8 bool
+
+	// PasswordTrailingNUL reports whether the MAC only verified with the
+	// password followed by a NUL character, with
+	// DecodeOptions.TryNULTerminatedPassword. Re-encoding with the password
+	// followed by "\x00" keeps it that way.
+	PasswordTrailingNUL bool
+}
+
+// Decode is like the package-level Decode, but obtains the password from
+// opts.Password, retrying up to opts.MaxAttempts times on ErrIncorrectPassword.
+func (opts *DecodeOptions) Decode(pfxData []byte) (privateKey interface{}, certificate *x509.Certificate, err error) {
+	bags, _, p, err := opts.getSafeContents(pfxData)
+	defer func() { // clear out BMP version of the password before we return
+		for i := 0; i < len(p); i++ {
+			p[i] = 0
+		}
+	}()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return opts.decodeBags(bags, p)
+}
+
+// DecodeReader is like Decode, but reads the PFX data from r, reading no more
+// of it than opts.MaxInputSize allows.
+func (opts *DecodeOptions) DecodeReader(r io.Reader) (privateKey interface{}, certificate *x509.Certificate, err error) {
+	pfxData, err := io.ReadAll(io.LimitReader(r, int64(opts.maxInputSize())+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return opts.Decode(pfxData)
+}
+
+// getSafeContents returns the bags of p12Data, the decrypted buffers they
+// were parsed from, and the BMP password that verified the MAC. The MAC is
+// verified before anything is decrypted, so an incorrect password always
+// fails with ErrIncorrectPassword at the same point, whatever the contents.
+func (opts *DecodeOptions) getSafeContents(p12Data []byte) (bags []safeBag, decrypted [][]byte, password []byte, err error) {
+	authSafe, password, err := opts.authenticate(p12Data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if bags, decrypted, err = opts.decryptAuthenticatedSafe(authSafe, password); err != nil {
+		return nil, decrypted, password, err
+	}
+	return bags, decrypted, password, nil
+}
+
+// authenticate parses p12Data and verifies its MAC, and returns the content
+// octets of the authenticated safe along with the BMP password that verified
+// the MAC.
+func (opts *DecodeOptions) authenticate(p12Data []byte) (authSafe, password []byte, err error) {
+	if err = opts.checkInputSize(p12Data); err != nil {
+		return nil, nil, err
+	}
+	pfx, authSafe, err := parsePfxVersion(p12Data, opts.AnyVersion)
+	if err != nil && opts.Lenient {
+		if barePfx, bareAuthSafe, bareErr := parseBarePfx(p12Data, opts.AnyVersion); bareErr == nil {
+			pfx, authSafe, err = barePfx, bareAuthSafe, nil
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Metadata != nil {
+		opts.Metadata.Version = pfx.Version
+	}
+
+	if opts.SkipMACVerification {
+		var candidates [][]byte
+		if candidates, _, _, err = opts.passwords(); err != nil {
+			return nil, nil, err
+		}
+		password = candidates[0]
+		wipe(nil, candidates[1:])
+		opts.setMetadata(password, false, false, false)
+		return authSafe, password, nil
+	}
+
+	attempts := opts.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	utf8, nul := false, false
+	for i := 0; i < attempts && password == nil; i++ {
+		var candidates [][]byte
+		var nulFrom, utf8From int
+		if candidates, nulFrom, utf8From, err = opts.passwords(); err != nil {
+			return nil, nil, err
+		}
+		for j, p := range candidates {
+			if password, err = verifyPassword(pfx, authSafe, p); err == nil {
+				utf8 = j >= utf8From
+				nul = j >= nulFrom && !utf8
+				wipe(nil, candidates[j+1:])
+				break
+			}
+			wipe(p, nil)
+			if err != ErrIncorrectPassword {
+				wipe(nil, candidates[j+1:])
+				return nil, nil, err
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.setMetadata(password, utf8, nul, pfx.MacData.present())
+	return authSafe, password, nil
+}
+
+// passwordString returns a PasswordFunc that always returns password.
+func passwordString(password string) PasswordFunc {
+	return func() ([]byte, error) { return []byte(password), nil }
+}
+
+// passwords calls opts.Password and returns the distinct BMP versions of the
+// result in each of the opts.NormalizePassword forms, or as it is if there
+// are none, zeroing the UTF-8 buffers it was given and normalized into. With
+// opts.TryNULTerminatedPassword, these are followed by the BMP versions of
+// each followed by a NUL character, from candidates[nulFrom] on, and with
+// opts.TryUTF8Password, by copies of the UTF-8 buffers, from
+// candidates[utf8From] on.
+func (opts *DecodeOptions) passwords() (candidates [][]byte, nulFrom, utf8From int, err error) {
+	var utf8Password []byte
+	if opts.Password != nil {
+		if utf8Password, err = opts.Password(); err != nil {
+			wipe(utf8Password, nil)
+			return nil, 0, 0, err
+		}
+	}
+	normalized := [][]byte{utf8Password}
+	if len(opts.NormalizePassword) > 0 {
+		normalized = normalized[:0]
+		for _, form := range opts.NormalizePassword {
+			normalized = append(normalized, form.Bytes(utf8Password))
+		}
+	}
+	defer func() {
+		wipe(utf8Password, normalized)
+	}()
+
+	for _, n := range normalized {
+		p, err := bmpString(n)
+		if err != nil {
+			wipe(nil, candidates)
+			return nil, 0, 0, err
+		}
+		if containsPassword(candidates, p) {
+			wipe(p, nil)
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	nulFrom = len(candidates)
+	if opts.TryNULTerminatedPassword {
+		for _, n := range normalized {
+			terminated := append(append(make([]byte, 0, len(n)+1), n...), 0)
+			p, err := bmpString(terminated)
+			wipe(terminated, nil)
+			if err != nil {
+				wipe(nil, candidates)
+				return nil, 0, 0, err
+			}
+			if containsPassword(candidates, p) {
+				wipe(p, nil)
+				continue
+			}
+			candidates = append(candidates, p)
+		}
+	}
+	utf8From = len(candidates)
+	if opts.TryUTF8Password {
+		for _, n := range normalized {
+			if !containsPassword(candidates[utf8From:], n) {
+				candidates = append(candidates, append([]byte(nil), n...))
+			}
+		}
+	}
+	return candidates, nulFrom, utf8From, nil
+}
+
+// containsPassword reports whether password is among candidates.
+func containsPassword(candidates [][]byte, password []byte) bool {
+	for _, c := range candidates {
+		if bytes.Equal(c, password) {
+			return true
+		}
+	}
+	return false
+}
+
+// setMetadata records the convention of the password that verified the MAC,
+// which is raw UTF-8 if utf8 is set and BMP otherwise, followed by a NUL
+// character if nul is set, and whether there was a MAC to verify, in
+// opts.Metadata, if requested.
+func (opts *DecodeOptions) setMetadata(password []byte, utf8, nul, macVerified bool) {
+	if opts.Metadata != nil {
+		opts.Metadata.PasswordNullTerminated = !utf8 && nullTerminated(password)
+		opts.Metadata.PasswordUTF8 = utf8
+		opts.Metadata.PasswordTrailingNUL = nul
+		opts.Metadata.MACVerified = macVerified
+	}
+}
+
+// checkValidity enforces opts.RejectExpired on the leaf certificate cert.
+func (opts *DecodeOptions) checkValidity(cert *x509.Certificate) error {
+	if !opts.RejectExpired {
+		return nil
+	}
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("pkcs12: certificate %q is only valid from %v to %v, not at %v", cert.Subject, cert.NotBefore.UTC(), cert.NotAfter.UTC(), now.UTC())
+	}
+	return nil
+}
+
+// checkCertificate enforces opts.RejectSignatureAlgorithms on cert.
+func (opts *DecodeOptions) checkCertificate(cert *x509.Certificate) error {
+	for _, rejected := range opts.RejectSignatureAlgorithms {
+		if cert.SignatureAlgorithm == rejected {
+			return fmt.Errorf("pkcs12: certificate %q is signed with rejected algorithm %v", cert.Subject, rejected)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package pkcs12
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// EncryptedPartKind is what an EncryptedPart decrypts to.
+type EncryptedPartKind int
+
+// Kinds of EncryptedPart.
+const (
+	// EncryptedSafeContents is an encryptedData ContentInfo, which decrypts
+	// to the DER encoding of a SafeContents.
+	EncryptedSafeContents EncryptedPartKind = iota
+
+	// ShroudedKey is a pkcs8ShroudedKeyBag, which decrypts to the DER
+	// encoding of a PKCS#8 PrivateKeyInfo.
+	ShroudedKey
+)
+
+// EncryptedPart is password-encrypted content of PFX data, as returned by
+// EncryptedParts.
+type EncryptedPart struct {
+	Kind EncryptedPartKind
+
+	// ContentInfoIndex is the index of the ContentInfo in the authenticated
+	// safe, and BagIndex that of the shrouded key bag in its SafeContents,
+	// or -1 for an EncryptedSafeContents.
+	ContentInfoIndex int
+	BagIndex         int
+
+	Algorithm  pkix.AlgorithmIdentifier
+	Ciphertext []byte
+}
+
+// EncryptedParts returns the encrypted ContentInfos of pfxData, and the
+// shrouded keys of its unencrypted ones, in the order they appear, without a
+// password, so that they can be decrypted elsewhere with Decrypt. Nothing is
+// decrypted and the MAC is not verified. Shrouded keys inside encrypted
+// ContentInfos are only found in the SafeContents those decrypt to.
+func EncryptedParts(pfxData []byte) ([]EncryptedPart, error) {
+	_, authSafe, err := parsePfx(pfxData)
+	if err != nil {
+		return nil, err
+	}
+	var authenticatedSafe []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &authenticatedSafe); err != nil {
+		return nil, err
+	}
+
+	var parts []EncryptedPart
+	for i, ci := range authenticatedSafe {
+		switch {
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var encryptedData encryptedData
+			if _, err = asn1.Unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
+				return nil, &DecodeError{ContentInfoIndex: i, BagIndex: -1, Err: err}
+			}
+			info := encryptedData.EncryptedContentInfo
+			parts = append(parts, EncryptedPart{
+				Kind:             EncryptedSafeContents,
+				ContentInfoIndex: i,
+				BagIndex:         -1,
+				Algorithm:        info.ContentEncryptionAlgorithm,
+				Ciphertext:       info.EncryptedContent,
+			})
+		case ci.ContentType.Equal(oidDataContentType):
+			var data []byte
+			var bags []rawSafeBag
+			if _, err = asn1.Unmarshal(ci.Content.Bytes, &data); err == nil {
+				_, err = asn1.Unmarshal(data, &bags)
+			}
+			if err != nil {
+				return nil, &DecodeError{ContentInfoIndex: i, BagIndex: -1, Err: err}
+			}
+			for j, bag := range bags {
+				if !bag.ID.Equal(oidPkcs8ShroudedKeyBagType) {
+					continue
+				}
+				var info encryptedPrivateKeyInfo
+				if _, err = asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+					return nil, &DecodeError{ContentInfoIndex: i, BagIndex: j, Err: err}
+				}
+				parts = append(parts, EncryptedPart{
+					Kind:             ShroudedKey,
+					ContentInfoIndex: i,
+					BagIndex:         j,
+					Algorithm:        info.AlgorithmIdentifier,
+					Ciphertext:       info.EncryptedData,
+				})
+			}
+		}
+	}
+	return parts, nil
+}
+
+// Decrypt decrypts p with password, as decoding the PFX data it came from
+// would, and returns the plaintext described by p.Kind. The caller should
+// zero the plaintext of a ShroudedKey once done with it. As no MAC is
+// verified, an incorrect password is detected only as far as the padding or
+// plaintext turn out invalid.
+func (p EncryptedPart) Decrypt(password string) ([]byte, error) {
+	bmpPassword, err := bmpString([]byte(password))
+	defer func() { // clear out BMP version of the password before we return
+		wipe(bmpPassword, nil)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := pbDecrypt(encryptedPrivateKeyInfo{AlgorithmIdentifier: p.Algorithm, EncryptedData: p.Ciphertext}, bmpPassword)
+	if err != nil {
+		return nil, err
+	}
+	var value asn1.RawValue
+	if rest, err := asn1.Unmarshal(decrypted, &value); err != nil || len(rest) != 0 {
+		wipe(decrypted, nil)
+		return nil, ErrDecryption
+	}
+	return decrypted, nil
+}
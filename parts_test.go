@@ -0,0 +1,56 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestEncryptedParts(t *testing.T) {
+	p12 := buildTestPFX(t, "parts")
+	parts, err := EncryptedParts(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []EncryptedPart{
+		{Kind: EncryptedSafeContents, ContentInfoIndex: 0, BagIndex: -1},
+		{Kind: ShroudedKey, ContentInfoIndex: 1, BagIndex: 0},
+		{Kind: ShroudedKey, ContentInfoIndex: 1, BagIndex: 1},
+	}
+	if len(parts) != len(expected) {
+		t.Fatalf("expected %d parts, found %d", len(expected), len(parts))
+	}
+	key, _ := testIdentity(t)
+	for i, part := range parts {
+		if part.Kind != expected[i].Kind || part.ContentInfoIndex != expected[i].ContentInfoIndex || part.BagIndex != expected[i].BagIndex {
+			t.Errorf("part %d: expected %+v, found kind %d at ContentInfo %d, bag %d", i, expected[i], part.Kind, part.ContentInfoIndex, part.BagIndex)
+		}
+		if !part.Algorithm.Algorithm.Equal(oidByAlg[string(PBEWithSHAAnd3KeyTripleDESCBC)]) {
+			t.Errorf("part %d: unexpected algorithm %v", i, part.Algorithm.Algorithm)
+		}
+
+		plaintext, err := part.Decrypt("parts")
+		if err != nil {
+			t.Errorf("part %d: %v", i, err)
+			continue
+		}
+		switch part.Kind {
+		case EncryptedSafeContents:
+			var bags []rawSafeBag
+			if _, err = asn1.Unmarshal(plaintext, &bags); err != nil || len(bags) != 3 {
+				t.Errorf("part %d: expected the three cert bags, got err: %v", i, err)
+			}
+		case ShroudedKey:
+			privateKey, err := parsePKCS8PrivateKey(plaintext)
+			if k, ok := privateKey.(*rsa.PrivateKey); err != nil || !ok || !k.Equal(key) {
+				t.Errorf("part %d: expected the private key, got err: %v", i, err)
+			}
+		}
+
+		if wrong, err := part.Decrypt("wrong"); err == nil && bytes.Equal(wrong, plaintext) {
+			t.Errorf("part %d: expected an incorrect password not to decrypt it", i)
+		}
+	}
+}
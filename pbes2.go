@@ -0,0 +1,240 @@
+// implementation of https://tools.ietf.org/html/rfc8018#section-6.2
+
+package pkcs12
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	pbes2AES128CBC = "PBES2-AES128-CBC"
+	pbes2AES192CBC = "PBES2-AES192-CBC"
+	pbes2AES256CBC = "PBES2-AES256-CBC"
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHmacWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHmacWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+var prfByOID = map[string]func() hash.Hash{
+	oidHmacWithSHA1.String():   sha1.New,
+	oidHmacWithSHA256.String(): sha256.New,
+	oidHmacWithSHA512.String(): sha512.New,
+}
+
+// pbes2Scheme is an encryption scheme of PBES2 with the key size it takes.
+type pbes2Scheme struct {
+	oid     asn1.ObjectIdentifier
+	keySize int
+}
+
+var pbes2SchemeByAlg = map[string]pbes2Scheme{
+	pbes2AES128CBC: {oidAES128CBC, 16},
+	pbes2AES192CBC: {oidAES192CBC, 24},
+	pbes2AES256CBC: {oidAES256CBC, 32},
+}
+
+// encryptionAlgorithmOf returns the EncryptionAlgorithm that encrypts as
+// algorithm describes, whatever its parameters, such as the PBKDF2
+// pseudorandom function of PBES2.
+func encryptionAlgorithmOf(algorithm pkix.AlgorithmIdentifier) (EncryptionAlgorithm, bool) {
+	if name, ok := algByOID[algorithm.Algorithm.String()]; ok {
+		return EncryptionAlgorithm(name), true
+	}
+	if !algorithm.Algorithm.Equal(oidPBES2) {
+		return "", false
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return "", false
+	}
+	for name, scheme := range pbes2SchemeByAlg {
+		if scheme.oid.Equal(params.EncryptionScheme.Algorithm) {
+			return EncryptionAlgorithm(name), true
+		}
+	}
+	return "", false
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	Prf        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+func pbes2DecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte, lenient bool) (cipher.BlockMode, error) {
+	if !hasParameters(algorithm) {
+		return nil, errors.New("pkcs12: algorithm PBES2 is missing its parameters")
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, NotImplementedError("PBES2 key derivation function " + params.KeyDerivationFunc.Algorithm.String() + " is not supported")
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+	if len(kdfParams.Salt) == 0 {
+		return nil, errors.New("pkcs12: algorithm PBES2 has an empty salt")
+	}
+	iterations, err := iterationCount("PBES2", kdfParams.Iterations, lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	prf := sha1.New
+	if len(kdfParams.Prf.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = prfByOID[kdfParams.Prf.Algorithm.String()]; !ok {
+			return nil, NotImplementedError("PBKDF2 pseudorandom function " + kdfParams.Prf.Algorithm.String() + " is not supported")
+		}
+	}
+
+	keySize := 0
+	for _, scheme := range pbes2SchemeByAlg {
+		if scheme.oid.Equal(params.EncryptionScheme.Algorithm) {
+			keySize = scheme.keySize
+		}
+	}
+	if keySize == 0 {
+		return nil, NotImplementedError("PBES2 encryption scheme " + params.EncryptionScheme.Algorithm.String() + " is not supported")
+	}
+	// the key length is optional, but must agree with the cipher when present
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keySize {
+		return nil, fmt.Errorf("pkcs12: PBKDF2 key length %d does not match the %d bytes of encryption scheme %s", kdfParams.KeyLength, keySize, params.EncryptionScheme.Algorithm)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("pkcs12: PBES2 IV has the wrong length")
+	}
+
+	k := pbes2Key(prf, password, kdfParams.Salt, iterations, keySize)
+	password = nil
+
+	code, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCBCDecrypter(code, iv), nil
+}
+
+// pbes2Encrypt encrypts message with AES-CBC under a key derived with
+// PBKDF2 and HMAC-SHA256, and returns it along with its AlgorithmIdentifier.
+// The IV is read from random, and the key taken from keys if it was derived
+// before.
+func pbes2Encrypt(name string, message, salt, password []byte, iterations int, random io.Reader, keys *derivedKeys) (*AsnItem, []byte, error) {
+	scheme := pbes2SchemeByAlg[name]
+
+	iv, err := readRandomBytes(random, aes.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k := keys.derive(name, salt, password, iterations, func() []byte {
+		return pbes2Key(sha256.New, password, salt, iterations, scheme.keySize)
+	})
+	password = nil
+
+	code, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted := cbcEncrypt(cipher.NewCBCEncrypter(code, iv), message)
+
+	a := AsnSequence()
+	a.append(asnObjectIdentifier(oidPBES2))
+	params := a.append(AsnSequence())
+	kdf := params.append(AsnSequence())
+	kdf.append(asnObjectIdentifier(oidPBKDF2))
+	kdfParams := kdf.append(AsnSequence())
+	kdfParams.append(AsnOctetString(salt))
+	kdfParams.append(AsnInteger(iterations))
+	kdfParams.append(AsnInteger(scheme.keySize))
+	prf := kdfParams.append(AsnSequence())
+	prf.append(asnObjectIdentifier(oidHmacWithSHA256))
+	prf.append(AsnNull())
+	enc := params.append(AsnSequence())
+	enc.append(asnObjectIdentifier(scheme.oid))
+	enc.append(AsnOctetString(iv))
+	return a, encrypted, nil
+}
+
+// pbes2Key derives a key of keyLength bytes with PBKDF2 from the BMP
+// password, wiping the UTF-8 copy of the password PBKDF2 is given.
+func pbes2Key(prf func() hash.Hash, password, salt []byte, iterations, keyLength int) []byte {
+	utf8Password := pbes2Password(password)
+	defer wipe(utf8Password, nil)
+	return pbkdf2(prf, utf8Password, salt, iterations, keyLength)
+}
+
+// pbes2Password converts the BMP password used by the PKCS#12 KDF to the
+// UTF-8 password PBKDF2 is given, as OpenSSL does, decoding it as
+// decodeBMPString would. The result is always a copy, which the caller should
+// wipe, and is built without any intermediate string that could not be.
+func pbes2Password(password []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+	if len(password)%2 != 0 {
+		return append([]byte(nil), password...)
+	}
+	if nullTerminated(password) {
+		password = password[:len(password)-2]
+	}
+
+	// a BMP character takes at most 3 bytes in UTF-8, and a surrogate pair
+	// 4, so the result is never reallocated, leaving no copy behind
+	utf8Password := make([]byte, 0, len(password)/2*3)
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(password); i += 2 {
+		r := rune(password[i])<<8 | rune(password[i+1])
+		if utf16.IsSurrogate(r) {
+			next := rune(utf8.RuneError)
+			if i+3 < len(password) {
+				next = rune(password[i+2])<<8 | rune(password[i+3])
+			}
+			if r = utf16.DecodeRune(r, next); r != utf8.RuneError {
+				i += 2
+			}
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		utf8Password = append(utf8Password, buf[:n]...)
+	}
+	wipe(buf[:], nil)
+	return utf8Password
+}
@@ -1,12 +1,21 @@
 package pkcs12
 
 import (
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
 	"math/big"
 )
 
 var (
 	deriveKeyByAlg = map[string]func(salt, password []byte, iterations int) []byte{
+		pbeWithSHAAnd128BitRC4: func(salt, password []byte, iterations int) []byte {
+			return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 1, 16)
+		},
+		pbeWithSHAAnd40BitRC4: func(salt, password []byte, iterations int) []byte {
+			return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 1, 5)
+		},
 		pbeWithSHAAnd3KeyTripleDESCBC: func(salt, password []byte, iterations int) []byte {
 			return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 1, 24)
 		},
@@ -26,6 +35,9 @@ var (
 		sha1Algorithm: func(salt, password []byte, iterations int) []byte {
 			return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 3, 20)
 		},
+		sha256Algorithm: func(salt, password []byte, iterations int) []byte {
+			return pbkdf(sha256Sum, 32, 64, salt, password, iterations, 3, 32)
+		},
 	}
 )
 
@@ -34,6 +46,11 @@ func sha1Sum(in []byte) []byte {
 	return sum[:]
 }
 
+func sha256Sum(in []byte) []byte {
+	sum := sha256.Sum256(in)
+	return sum[:]
+}
+
 func pbkdf(hash func([]byte) []byte, u, v int, salt, password []byte, r int, ID byte, size int) (key []byte) {
 	// implementation of https://tools.ietf.org/html/rfc7292#appendix-B.2 , RFC text verbatim in comments
 
@@ -132,7 +149,7 @@ func pbkdf(hash func([]byte) []byte, u, v int, salt, password []byte, r int, ID
 	}
 
 	//    6.  For i=1, 2, ..., c, do the following:
-	A := make([]byte, c*20)
+	A := make([]byte, c*u)
 	for i := 0; i < c; i++ {
 
 		//        A.  Set A2=H^r(D||I). (i.e., the r-th hash of D||1,
@@ -141,7 +158,7 @@ func pbkdf(hash func([]byte) []byte, u, v int, salt, password []byte, r int, ID
 		for j := 1; j < r; j++ {
 			Ai = hash(Ai[:])
 		}
-		copy(A[i*20:], Ai[:])
+		copy(A[i*u:], Ai[:])
 
 		if i < c-1 { // skip on last iteration
 
@@ -190,3 +207,35 @@ func pbkdf(hash func([]byte) []byte, u, v int, salt, password []byte, r int, ID
 	//    hold for 2-key and 3-key triple-DES keys, for CDMF keys, and for any
 	//    similar keys with parity bits "built into them".
 }
+
+// pbkdf2 implements PBKDF2 of https://tools.ietf.org/html/rfc8018#section-5.2
+// with HMAC over prf as the pseudorandom function.
+func pbkdf2(prf func() hash.Hash, password, salt []byte, iterations, size int) []byte {
+	mac := hmac.New(prf, password)
+	password = nil
+	hLen := mac.Size()
+	blocks := (size + hLen - 1) / hLen
+
+	key := make([]byte, 0, blocks*hLen)
+	u := make([]byte, hLen)
+	for i := 1; i <= blocks; i++ {
+		// T_i = U_1 \xor U_2 \xor ... \xor U_c, where U_1 = PRF(P, S || INT(i))
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write([]byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)})
+		key = mac.Sum(key)
+		t := key[len(key)-hLen:]
+		copy(u, t)
+
+		// and U_j = PRF(P, U_{j-1})
+		for j := 2; j <= iterations; j++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return key[:size]
+}
@@ -2,6 +2,7 @@ package pkcs12
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"testing"
 )
 
@@ -18,3 +19,22 @@ func TestThatPBKDFWorksCorrectlyForLongKeys(t *testing.T) {
 		t.Fatalf("expected key '% x', but found '% x'", key, expected)
 	}
 }
+
+func TestPBKDF2(t *testing.T) {
+	// test vectors from https://tools.ietf.org/html/rfc6070
+	var tests = []struct {
+		password, salt string
+		iterations     int
+		key            string
+	}{
+		{"password", "salt", 1, "\x0c\x60\xc8\x0f\x96\x1f\x0e\x71\xf3\xa9\xb5\x24\xaf\x60\x12\x06\x2f\xe0\x37\xa6"},
+		{"password", "salt", 2, "\xea\x6c\x01\x4d\xc7\x2d\x6f\x8c\xcd\x1e\xd9\x2a\xce\x1d\x41\xf0\xd8\xde\x89\x57"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "\x3d\x2e\xec\x4f\xe4\x1c\x84\x9b\x80\xc8\xd8\x36\x62\xc0\xe4\x4a\x8b\x29\x1a\x96\x4c\xf2\xf0\x70\x38"},
+	}
+	for _, tt := range tests {
+		key := pbkdf2(sha1.New, []byte(tt.password), []byte(tt.salt), tt.iterations, len(tt.key))
+		if !bytes.Equal(key, []byte(tt.key)) {
+			t.Errorf("expected key '% x', but found '% x'", tt.key, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// implementation of https://tools.ietf.org/html/rfc9579
+
+package pkcs12
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+)
+
+var (
+	oidPBMAC1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 14}
+
+	hmacIDByName = map[string]asn1.ObjectIdentifier{
+		sha1Algorithm:   oidHmacWithSHA1,
+		sha256Algorithm: oidHmacWithSHA256,
+	}
+)
+
+// pbmac1NotUsed is written in place of the macSalt of MacData, which PBMAC1
+// ignores in favour of the salt in its PBKDF2 parameters.
+var pbmac1NotUsed = []byte("NOT USED")
+
+type pbmac1Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	MessageAuthScheme pkix.AlgorithmIdentifier
+}
+
+// pbmac1 computes the PBMAC1 of message as described by algorithm. Unlike
+// the PKCS#12 KDF, PBKDF2 is given the UTF-8 form of the BMP password.
+func pbmac1(algorithm pkix.AlgorithmIdentifier, message, password []byte) ([]byte, error) {
+	var params pbmac1Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	mac, ok := prfByOID[params.MessageAuthScheme.Algorithm.String()]
+	if !ok {
+		return nil, NotImplementedError("PBMAC1 message authentication scheme " + params.MessageAuthScheme.Algorithm.String() + " is not supported")
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, NotImplementedError("PBMAC1 key derivation function " + params.KeyDerivationFunc.Algorithm.String() + " is not supported")
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+	if len(kdfParams.Salt) == 0 {
+		return nil, errors.New("pkcs12: algorithm PBMAC1 has an empty salt")
+	}
+	if kdfParams.KeyLength <= 0 {
+		return nil, errors.New("pkcs12: PBMAC1 key length is missing")
+	}
+	prf := sha1.New
+	if len(kdfParams.Prf.Algorithm) > 0 {
+		if prf, ok = prfByOID[kdfParams.Prf.Algorithm.String()]; !ok {
+			return nil, NotImplementedError("PBKDF2 pseudorandom function " + kdfParams.Prf.Algorithm.String() + " is not supported")
+		}
+	}
+
+	return pbmac1Sum(mac, prf, message, kdfParams.Salt, password, kdfParams.Iterations, kdfParams.KeyLength), nil
+}
+
+func pbmac1Sum(mac, prf func() hash.Hash, message, salt, password []byte, iterations, keyLength int) []byte {
+	k := pbes2Key(prf, password, salt, iterations, keyLength)
+	password = nil
+	h := hmac.New(mac, k)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// generatePBMAC1 computes the PBMAC1 of message, with HMAC over the named
+// hash as both the message authentication scheme and the PBKDF2
+// pseudorandom function, and returns it along with its AlgorithmIdentifier.
+func generatePBMAC1(name string, message, salt, password []byte, iterations int, keys *derivedKeys) (*AsnItem, []byte, error) {
+	oid, ok := hmacIDByName[name]
+	if !ok {
+		return nil, nil, NotImplementedError("PBMAC1 hash " + name + " is not supported")
+	}
+	newHash := hashByName[name]
+	keyLength := newHash().Size()
+	k := keys.derive("PBMAC1 "+name, salt, password, iterations, func() []byte {
+		return pbes2Key(newHash, password, salt, iterations, keyLength)
+	})
+	password = nil
+	h := hmac.New(newHash, k)
+	h.Write(message)
+	mac := h.Sum(nil)
+
+	a := AsnSequence()
+	a.append(asnObjectIdentifier(oidPBMAC1))
+	params := a.append(AsnSequence())
+	kdf := params.append(AsnSequence())
+	kdf.append(asnObjectIdentifier(oidPBKDF2))
+	kdfParams := kdf.append(AsnSequence())
+	kdfParams.append(AsnOctetString(salt))
+	kdfParams.append(AsnInteger(iterations))
+	kdfParams.append(AsnInteger(keyLength))
+	prf := kdfParams.append(AsnSequence())
+	prf.append(asnObjectIdentifier(oid))
+	prf.append(AsnNull())
+	scheme := params.append(AsnSequence())
+	scheme.append(asnObjectIdentifier(oid))
+	scheme.append(AsnNull())
+	return a, mac, nil
+}
@@ -0,0 +1,179 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+)
+
+// ToPEMBundle extracts the private key and certificates of pfxData as the PEM
+// files servers such as nginx and HAProxy are configured with. See
+// DecodeOptions.ToPEMBundle.
+func ToPEMBundle(pfxData []byte, password string) (certPEM, keyPEM []byte, err error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.ToPEMBundle(pfxData)
+}
+
+// ToPEMBundle extracts the private key and certificates of pfxData, which
+// must hold exactly one private key, as PEM. certPEM holds the certificate of
+// the key followed by the CA certificates, each issuer after the certificate
+// it issued where it can be found; certificates that are not part of the
+// chain come last, in the order they appear. keyPEM holds the unencrypted
+// PKCS#8 private key. The password is obtained from opts.Password.
+func (opts *DecodeOptions) ToPEMBundle(pfxData []byte) (certPEM, keyPEM []byte, err error) {
+	privateKey, certificate, caCerts, err := opts.DecodeChain(pfxData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if privateKey == nil {
+		return nil, nil, errors.New("private key missing")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: PrivateKeyType, Bytes: der})
+	for i := range der { // clear out the unencrypted private key
+		der[i] = 0
+	}
+
+	var certs bytes.Buffer
+	for _, c := range orderChain(certificate, caCerts) {
+		if err = pem.Encode(&certs, &pem.Block{Type: CertificateType, Bytes: c.Raw}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return certs.Bytes(), keyPEM, nil
+}
+
+// orderChain returns leaf followed by its issuers found in caCerts, from the
+// closest to the farthest, and then the rest of caCerts.
+func orderChain(leaf *x509.Certificate, caCerts []*x509.Certificate) []*x509.Certificate {
+	chain, rest := splitChain(leaf, caCerts)
+	return append(chain, rest...)
+}
+
+// splitChain returns leaf followed by its issuers found in caCerts, from the
+// closest to the farthest, and the rest of caCerts, in their order.
+func splitChain(leaf *x509.Certificate, caCerts []*x509.Certificate) (chain, rest []*x509.Certificate) {
+	chain = []*x509.Certificate{leaf}
+	rest = append([]*x509.Certificate(nil), caCerts...)
+	for last := leaf; !bytes.Equal(last.RawIssuer, last.RawSubject); {
+		i := 0
+		for ; i < len(rest); i++ {
+			if bytes.Equal(rest[i].RawSubject, last.RawIssuer) && last.CheckSignatureFrom(rest[i]) == nil {
+				break
+			}
+		}
+		if i == len(rest) {
+			break
+		}
+		last = rest[i]
+		chain = append(chain, last)
+		rest = append(rest[:i], rest[i+1:]...)
+	}
+	return chain, rest
+}
+
+// EncodeFromPEM produces PFX data from the PEM encoded private key and
+// certificates, protected with password. See Encoder.EncodeFromPEM.
+func EncodeFromPEM(keyPEM, certPEM, caPEM []byte, password string, opts ...EncodeOption) ([]byte, error) {
+	return NewEncoder(opts...).EncodeFromPEM(keyPEM, certPEM, caPEM, password)
+}
+
+// EncodeFromPEM is like Encode, but takes the private key and certificates
+// PEM encoded. keyPEM holds the private key as PKCS#8, PKCS#1 or SEC 1, and
+// may be encrypted, as an ENCRYPTED PRIVATE KEY or with the legacy OpenSSL
+// headers, with the passphrase set by WithPEMPassphrase. certPEM and caPEM
+// hold any number of CERTIFICATE blocks, of which the one holding the public
+// key of the private key is taken as its certificate and the others are
+// ordered as by ToPEMBundle.
+func (enc *Encoder) EncodeFromPEM(keyPEM, certPEM, caPEM []byte, password string) ([]byte, error) {
+	privateKey, err := enc.parsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for _, data := range [][]byte{certPEM, caPEM} {
+		for rest := data; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if block.Type != CertificateType {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+	}
+
+	for i, cert := range certs {
+		if publicKeyMatches(privateKey, cert) {
+			caCerts := append(append([]*x509.Certificate(nil), certs[:i]...), certs[i+1:]...)
+			return enc.Encode(privateKey, cert, orderChain(cert, caCerts)[1:], password)
+		}
+	}
+	return nil, errors.New("pkcs12: no certificate holds the public key of the private key")
+}
+
+// parsePEMPrivateKey returns the first private key in keyPEM, decrypting it
+// with enc.pemPassphrase if it is encrypted.
+func (enc *Encoder) parsePEMPrivateKey(keyPEM []byte) (privateKey interface{}, err error) {
+	var block *pem.Block
+	for rest := keyPEM; ; {
+		if block, rest = pem.Decode(rest); block == nil {
+			return nil, errors.New("pkcs12: no private key found in PEM data")
+		}
+		switch block.Type {
+		case "ENCRYPTED PRIVATE KEY", PrivateKeyType, "RSA PRIVATE KEY", "EC PRIVATE KEY":
+		default:
+			continue
+		}
+		break
+	}
+
+	der := block.Bytes
+	decrypted := block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block)
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		var info encryptedPrivateKeyInfo
+		if _, err = asn1.Unmarshal(block.Bytes, &info); err != nil {
+			return nil, errors.New("pkcs12: error decoding encrypted private key: " + err.Error())
+		}
+		var password []byte
+		if password, err = bmpString([]byte(enc.pemPassphrase)); err != nil {
+			return nil, err
+		}
+		der, err = pbDecrypt(info, password)
+		for i := range password {
+			password[i] = 0
+		}
+	} else if decrypted {
+		der, err = x509.DecryptPEMBlock(block, []byte(enc.pemPassphrase))
+	}
+	if err != nil {
+		return nil, errors.New("pkcs12: error decrypting private key: " + err.Error())
+	}
+	if decrypted {
+		defer func() { // clear out the decrypted private key before we return
+			for i := range der {
+				der[i] = 0
+			}
+		}()
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	}
+	return parsePKCS8PrivateKey(der)
+}
@@ -0,0 +1,125 @@
+package pkcs12
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestToPEMBundle(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	other := newTestCert(t, "other", nil)
+
+	// the issuers are stored in the reverse order of the chain
+	p12 := buildChainPFX(t, "bundle", leaf, other, root, intermediate)
+	certPEM, keyPEM, err := ToPEMBundle(p12, "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != CertificateType {
+			t.Fatalf("unexpected block type %s", block.Type)
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, c)
+	}
+	expected := []*testCert{leaf, intermediate, root, other}
+	if len(certs) != len(expected) {
+		t.Fatalf("expected %d certificates, found %d", len(expected), len(certs))
+	}
+	for i, c := range expected {
+		if !certs[i].Equal(c.cert) {
+			t.Errorf("expected certificate %d to be '%s', found '%s'", i, c.cert.Subject.CommonName, certs[i].Subject.CommonName)
+		}
+	}
+
+	block, rest := pem.Decode(keyPEM)
+	if block == nil || block.Type != PrivateKeyType || len(rest) != 0 {
+		t.Fatalf("expected a single private key block, found %q", keyPEM)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || !k.Equal(leaf.key) {
+		t.Error("expected the private key of the leaf")
+	}
+}
+
+func TestEncodeFromPEM(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(leaf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(leaf.key.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", sec1, []byte("passphrase"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := encryptPEMPrivateKey(pkcs8, PBES2AES256CBC, "passphrase", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the leaf is among the CA certificates, which are out of order
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: CertificateType, Bytes: root.cert.Raw})
+	caPEM := append(pem.EncodeToMemory(&pem.Block{Type: CertificateType, Bytes: leaf.cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: CertificateType, Bytes: intermediate.cert.Raw})...)
+
+	for name, keyPEM := range map[string][]byte{
+		"PKCS#8":           pem.EncodeToMemory(&pem.Block{Type: PrivateKeyType, Bytes: pkcs8}),
+		"SEC 1":            pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}),
+		"encrypted PKCS#8": pem.EncodeToMemory(encrypted),
+		"legacy encrypted": pem.EncodeToMemory(legacy),
+	} {
+		p12, err := EncodeFromPEM(keyPEM, certPEM, caPEM, "from pem", WithPEMPassphrase("passphrase"))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		entries, err := DecodeAll(p12, "from pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []*testCert{leaf, intermediate, root}
+		if len(entries) != len(expected) {
+			t.Fatalf("%s: expected %d entries, found %d", name, len(expected), len(entries))
+		}
+		if k, ok := entries[0].PrivateKey.(*ecdsa.PrivateKey); !ok || !k.Equal(leaf.key) {
+			t.Errorf("%s: expected the private key of the leaf", name)
+		}
+		for i, c := range expected {
+			if !entries[i].Certificate.Equal(c.cert) {
+				t.Errorf("%s: expected certificate %d to be '%s', found '%s'", name, i, c.cert.Subject.CommonName, entries[i].Certificate.Subject.CommonName)
+			}
+		}
+	}
+
+	keyPEM := pem.EncodeToMemory(encrypted)
+	if _, err = EncodeFromPEM(keyPEM, certPEM, caPEM, "from pem", WithPEMPassphrase("wrong")); err == nil {
+		t.Error("expected an error for an incorrect passphrase")
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: PrivateKeyType, Bytes: pkcs8})
+	if _, err = EncodeFromPEM(keyPEM, certPEM, nil, "from pem"); err == nil {
+		t.Error("expected an error when no certificate matches the private key")
+	}
+}
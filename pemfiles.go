@@ -0,0 +1,217 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PEMFileOption configures WritePEMFiles and ToPEMWriter.
+type PEMFileOption func(*pemFileOptions)
+
+type pemFileOptions struct {
+	keyAlgorithm  EncryptionAlgorithm
+	keyPassphrase string
+	iterations    int
+	createDirs    bool
+	keyLast       bool
+}
+
+// newPEMFileOptions returns the defaults with opts applied.
+func newPEMFileOptions(opts []PEMFileOption) pemFileOptions {
+	o := pemFileOptions{iterations: 2048}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithKeyEncryption writes the private key as an ENCRYPTED PRIVATE KEY,
+// encrypted with algorithm and passphrase, rather than unencrypted.
+func WithKeyEncryption(algorithm EncryptionAlgorithm, passphrase string) PEMFileOption {
+	return func(opts *pemFileOptions) {
+		opts.keyAlgorithm = algorithm
+		opts.keyPassphrase = passphrase
+	}
+}
+
+// WithCreateDirs creates the missing parent directories of the files, with
+// 0700 permissions.
+func WithCreateDirs() PEMFileOption {
+	return func(opts *pemFileOptions) { opts.createDirs = true }
+}
+
+// WithKeyLast has ToPEMWriter write the private keys after the certificates
+// rather than before them.
+func WithKeyLast() PEMFileOption {
+	return func(opts *pemFileOptions) { opts.keyLast = true }
+}
+
+// WritePEMFiles extracts the private key and certificates of pfxData, which
+// must hold exactly one private key, into PEM files: the PKCS#8 private key
+// to keyPath, its certificate to certPath, and the CA certificates, ordered
+// as by ToPEMBundle, to caPath. Files whose path is empty are not written.
+// The key file is given 0600 permissions, even if it already exists, and the
+// certificate files 0644.
+func WritePEMFiles(pfxData []byte, password string, keyPath, certPath, caPath string, opts ...PEMFileOption) error {
+	o := newPEMFileOptions(opts)
+
+	privateKey, certificate, caCerts, err := DecodeChain(pfxData, password)
+	if err != nil {
+		return err
+	}
+	if privateKey == nil {
+		return errors.New("pkcs12: private key missing")
+	}
+
+	if keyPath != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return err
+		}
+		block := &pem.Block{Type: PrivateKeyType, Bytes: der}
+		if o.keyAlgorithm != "" {
+			if block, err = encryptPEMPrivateKey(der, o.keyAlgorithm, o.keyPassphrase, o.iterations); err != nil {
+				return err
+			}
+		}
+		keyPEM := pem.EncodeToMemory(block)
+		for i := range der { // clear out the unencrypted private key
+			der[i] = 0
+		}
+		err = writePEMFile(keyPath, keyPEM, 0600, o.createDirs)
+		for i := range keyPEM {
+			keyPEM[i] = 0
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if certPath != "" {
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: CertificateType, Bytes: certificate.Raw})
+		if err = writePEMFile(certPath, certPEM, 0644, o.createDirs); err != nil {
+			return err
+		}
+	}
+
+	if caPath != "" {
+		var caPEM bytes.Buffer
+		for _, c := range orderChain(certificate, caCerts)[1:] {
+			if err = pem.Encode(&caPEM, &pem.Block{Type: CertificateType, Bytes: c.Raw}); err != nil {
+				return err
+			}
+		}
+		if err = writePEMFile(caPath, caPEM.Bytes(), 0644, o.createDirs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToPEMWriter writes the private keys and certificates of pfxData to w as
+// PEM, decrypted with password. See DecodeOptions.ToPEMWriter.
+func ToPEMWriter(w io.Writer, pfxData []byte, password string, opts ...PEMFileOption) error {
+	decodeOpts := &DecodeOptions{Password: passwordString(password)}
+	return decodeOpts.ToPEMWriter(w, pfxData, opts...)
+}
+
+// ToPEMWriter writes the private keys of pfxData to w as PKCS#8 PRIVATE KEY
+// blocks, or ENCRYPTED PRIVATE KEY blocks with WithKeyEncryption, followed by
+// its X.509 certificates as CERTIFICATE blocks, or the other way around with
+// WithKeyLast, each in the order it appears. Other bags are skipped. Each
+// block is decoded only as it is written, so that no more than one is held in
+// memory. If a bag fails to decode, the blocks before it are left written and
+// a *DecodeError locating it is returned. The password is obtained from
+// opts.Password.
+func (opts *DecodeOptions) ToPEMWriter(w io.Writer, pfxData []byte, pemOpts ...PEMFileOption) error {
+	o := newPEMFileOptions(pemOpts)
+	bags, decrypted, p, err := opts.getSafeContents(pfxData)
+	defer wipe(p, decrypted)
+	if err != nil {
+		return err
+	}
+
+	for _, keys := range []bool{!o.keyLast, o.keyLast} {
+		for i := range bags {
+			bag := &bags[i]
+			if isKeyBag(bag.ID) != keys {
+				continue
+			}
+			if err = opts.writeBagPEM(w, bag, p, &o); err != nil {
+				return bag.wrapError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeBagPEM writes the private key or X.509 certificate of bag to w, if it
+// holds one.
+func (opts *DecodeOptions) writeBagPEM(w io.Writer, bag *safeBag, password []byte, o *pemFileOptions) error {
+	switch {
+	case bag.ID.Equal(oidCertBagType):
+		certType, err := certBagType(bag.Value.Bytes)
+		if err != nil {
+			return err
+		}
+		if !certType.Equal(oidCertTypeX509Certificate) {
+			return nil
+		}
+		der, err := decodeCertBag(bag.Value.Bytes)
+		if err != nil {
+			return err
+		}
+		return pem.Encode(w, &pem.Block{Type: CertificateType, Bytes: der})
+	case isKeyBag(bag.ID):
+		der, err := opts.privateKeyDER(bag, password)
+		defer wipe(der, nil) // clear out the unencrypted private key
+		if err != nil {
+			return err
+		}
+		block := &pem.Block{Type: PrivateKeyType, Bytes: der}
+		if o.keyAlgorithm != "" {
+			if block, err = encryptPEMPrivateKey(der, o.keyAlgorithm, o.keyPassphrase, o.iterations); err != nil {
+				return err
+			}
+		}
+		return pem.Encode(w, block)
+	}
+	return nil
+}
+
+// encryptPEMPrivateKey returns an ENCRYPTED PRIVATE KEY block holding the
+// PKCS#8 private key encrypted with algorithm and passphrase.
+func encryptPEMPrivateKey(pkcs8 []byte, algorithm EncryptionAlgorithm, passphrase string, iterations int) (*pem.Block, error) {
+	der, err := encryptPKCS8(pkcs8, algorithm, nil, iterations, passphrase, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+}
+
+// writePEMFile writes data to path with perm, which is also set on a file
+// that already exists, creating the parent directories first if createDirs
+// is set.
+func writePEMFile(path string, data []byte, perm os.FileMode, createDirs bool) error {
+	if createDirs {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err == nil {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePEMFiles(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	p12 := buildChainPFX(t, "files", leaf, root, intermediate)
+
+	dir := filepath.Join(t.TempDir(), "tls")
+	keyPath := filepath.Join(dir, "key.pem")
+	certPath := filepath.Join(dir, "cert.pem")
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := WritePEMFiles(p12, "files", keyPath, certPath, caPath); err == nil {
+		t.Error("expected an error for a missing directory without WithCreateDirs")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// an existing key file is tightened to 0600
+	if err := os.WriteFile(keyPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePEMFiles(p12, "files", keyPath, certPath, caPath, WithKeyEncryption(PBES2AES256CBC, "passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]os.FileMode{keyPath: 0600, certPath: 0644, caPath: 0644} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm&expected != perm {
+			t.Errorf("expected %s to have permissions within %v, found %v", filepath.Base(path), expected, perm)
+		}
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("expected an encrypted private key, found %q", keyPEM)
+	}
+	key, err := NewEncoder(WithPEMPassphrase("passphrase")).parsePEMPrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || !k.Equal(leaf.key) {
+		t.Error("expected the private key of the leaf")
+	}
+
+	for path, expected := range map[string][]*testCert{certPath: {leaf}, caPath: {intermediate, root}} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range expected {
+			var block *pem.Block
+			if block, data = pem.Decode(data); block == nil || string(block.Bytes) != string(c.cert.Raw) {
+				t.Errorf("expected %s to hold '%s' next", filepath.Base(path), c.cert.Subject.CommonName)
+				break
+			}
+		}
+		if len(data) != 0 {
+			t.Errorf("unexpected data at the end of %s", filepath.Base(path))
+		}
+	}
+
+	nested := filepath.Join(t.TempDir(), "a", "b", "key.pem")
+	if err = WritePEMFiles(p12, "files", nested, "", "", WithCreateDirs()); err != nil {
+		t.Fatal(err)
+	}
+	if keyPEM, err = os.ReadFile(nested); err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != PrivateKeyType {
+		t.Errorf("expected an unencrypted private key, found %q", keyPEM)
+	}
+}
+
+func TestToPEMWriter(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	leaf := newTestCert(t, "leaf", root)
+	p12 := buildChainPFX(t, "writer", leaf, root)
+
+	blockTypes := func(data []byte) (types []string) {
+		for rest := data; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				return types
+			}
+			types = append(types, block.Type)
+		}
+	}
+	for _, test := range []struct {
+		opts     []PEMFileOption
+		expected []string
+	}{
+		{nil, []string{PrivateKeyType, CertificateType, CertificateType}},
+		{[]PEMFileOption{WithKeyLast()}, []string{CertificateType, CertificateType, PrivateKeyType}},
+		{[]PEMFileOption{WithKeyEncryption(PBES2AES128CBC, "passphrase")}, []string{"ENCRYPTED PRIVATE KEY", CertificateType, CertificateType}},
+	} {
+		var out bytes.Buffer
+		if err := ToPEMWriter(&out, p12, "writer", test.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if types := blockTypes(out.Bytes()); len(types) != len(test.expected) {
+			t.Errorf("expected blocks %v, found %v", test.expected, types)
+		} else {
+			for i := range types {
+				if types[i] != test.expected[i] {
+					t.Errorf("expected blocks %v, found %v", test.expected, types)
+					break
+				}
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := ToPEMWriter(&out, p12, "writer", WithKeyEncryption(PBES2AES128CBC, "passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(out.Bytes())
+	key, err := DecryptPKCS8(block.Bytes, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || !k.Equal(leaf.key) {
+		t.Error("expected the encrypted private key of the leaf")
+	}
+}
+
+func TestToPEMWriterFailsMidstream(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+
+	// a shrouded key whose ciphertext is not the encryption of anything
+	params, err := asn1.Marshal(pbeParams{Salt: []byte("saltsalt"), Iterations: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		AlgorithmIdentifier: pkix.AlgorithmIdentifier{Algorithm: oidPbeWithSHAAnd3KeyTripleDESCBC, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       make([]byte, 16),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbled, err := asn1.Marshal(struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}{oidPkcs8ShroudedKeyBagType, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: info}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(root.cert.Raw)
+	keys := NewContentInfoBuilder()
+	keys.addRawBag(garbled)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("midstream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = ToPEMWriter(&out, p12, "midstream", WithKeyLast())
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.ContentInfoIndex != 1 || decodeErr.BagIndex != 0 {
+		t.Fatalf("expected the error to locate the garbled key, got: %v", err)
+	}
+	block, rest := pem.Decode(out.Bytes())
+	if block == nil || block.Type != CertificateType || len(bytes.TrimSpace(rest)) != 0 {
+		t.Fatalf("expected the certificate to be written before the error, found %q", out.Bytes())
+	}
+	if c, err := x509.ParseCertificate(block.Bytes); err != nil || !c.Equal(root.cert) {
+		t.Errorf("expected the written certificate to be the one stored, got err: %v", err)
+	}
+}
@@ -5,7 +5,6 @@
 package pkcs12
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
@@ -14,6 +13,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 )
 
 type pfxPdu struct {
@@ -29,6 +29,7 @@ type contentInfo struct {
 
 var (
 	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedDataContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
 	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
 )
 
@@ -48,12 +49,29 @@ func (i encryptedContentInfo) GetAlgorithm() pkix.AlgorithmIdentifier {
 }
 func (i encryptedContentInfo) GetData() []byte { return i.EncryptedContent }
 
-type safeBag struct {
+type rawSafeBag struct {
+	Raw        asn1.RawContent
 	ID         asn1.ObjectIdentifier
 	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
 	Attributes []pkcs12Attribute `asn1:"set,optional"`
 }
 
+// safeBag is a decoded bag along with where it was found in the
+// authenticated safe, to report with errors about it.
+type safeBag struct {
+	rawSafeBag
+	contentInfoIndex int
+	index            int
+}
+
+// wrapError returns err as a DecodeError locating bag, or nil if err is nil.
+func (bag *safeBag) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DecodeError{ContentInfoIndex: bag.contentInfoIndex, BagIndex: bag.index, Err: err}
+}
+
 type pkcs12Attribute struct {
 	ID    asn1.ObjectIdentifier
 	Value asn1.RawValue `ans1:"set"`
@@ -87,14 +105,15 @@ func ConvertToPEM(pfxData, utf8Password []byte) (blocks []*pem.Block, err error)
 		return nil, ErrIncorrectPassword
 	}
 
-	bags, p, err := getSafeContents(pfxData, p)
+	opts := new(DecodeOptions)
+	bags, p, err := opts.getSafeContentsWithPassword(pfxData, p)
 
 	blocks = make([]*pem.Block, 0, 2)
 	for _, bag := range bags {
 		var block *pem.Block
-		block, err = convertBag(&bag, p)
+		block, err = opts.convertBag(&bag, p)
 		if err != nil {
-			return
+			return nil, bag.wrapError(err)
 		}
 		blocks = append(blocks, block)
 	}
@@ -102,7 +121,7 @@ func ConvertToPEM(pfxData, utf8Password []byte) (blocks []*pem.Block, err error)
 	return
 }
 
-func convertBag(bag *safeBag, password []byte) (*pem.Block, error) {
+func (opts *DecodeOptions) convertBag(bag *safeBag, password []byte) (*pem.Block, error) {
 	b := new(pem.Block)
 
 	for _, attribute := range bag.Attributes {
@@ -124,10 +143,10 @@ func convertBag(bag *safeBag, password []byte) (*pem.Block, error) {
 			return nil, err
 		}
 		b.Bytes = certsData
-	case bag.ID.Equal(oidPkcs8ShroudedKeyBagType):
+	case isKeyBag(bag.ID):
 		b.Type = PrivateKeyType
 
-		key, err := decodePkcs8ShroudedKeyBag(bag.Value.Bytes, password)
+		key, err := opts.decodeKeyBag(bag, password)
 		if err != nil {
 			return nil, err
 		}
@@ -153,6 +172,9 @@ var (
 	oidFriendlyName     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
 	oidLocalKeyID       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
 	oidMicrosoftCSPName = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 17, 1}
+	oidTrustedKeyUsage  = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
 )
 
 var attributeNameByOID = map[string]string{
@@ -170,7 +192,7 @@ func convertAttribute(attribute *pkcs12Attribute) (key, value string, err error)
 		if _, err = asn1.Unmarshal(attribute.Value.Bytes, &attribute.Value); err != nil {
 			return
 		}
-		if value, err = decodeBMPString(attribute.Value.Bytes); err != nil {
+		if value, err = decodeBMPName(attribute.Value.Bytes); err != nil {
 			return
 		}
 	case attribute.ID.Equal(oidLocalKeyID):
@@ -200,11 +222,63 @@ func Decode(pfxData, utf8Password []byte) (privateKey interface{}, certificate *
 	if err != nil {
 		return nil, nil, err
 	}
-	bags, p, err := getSafeContents(pfxData, p)
+	opts := new(DecodeOptions)
+	bags, p, err := opts.getSafeContentsWithPassword(pfxData, p)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	return opts.decodeBags(bags, p)
+}
+
+// DecodeReader is like Decode, but reads the PFX data from r and takes the
+// password as a string, failing with ErrInputTooLarge on data larger than
+// DefaultMaxInputSize. See DecodeOptions.DecodeReader.
+func DecodeReader(r io.Reader, password string) (privateKey interface{}, certificate *x509.Certificate, err error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.DecodeReader(r)
+}
+
+// DecodeSeparatePasswords is like Decode, but for the rare PFX data whose MAC
+// was computed with another password than the one its contents are encrypted
+// with: the MAC is verified with macPassword, which fails with
+// ErrIncorrectPassword, and the contents decrypted with contentPassword.
+func DecodeSeparatePasswords(pfxData []byte, macPassword, contentPassword string) (privateKey interface{}, certificate *x509.Certificate, err error) {
+	macP, err := bmpString([]byte(macPassword))
+	defer wipe(macP, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	contentP, err := bmpString([]byte(contentPassword))
+	defer wipe(contentP, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := new(DecodeOptions)
+	if err = opts.checkInputSize(pfxData); err != nil {
+		return nil, nil, err
+	}
+	pfx, authSafe, err := parsePfx(pfxData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = verifyPassword(pfx, authSafe, macP); err != nil {
+		return nil, nil, err
+	}
+
+	bags, decrypted, err := opts.decryptAuthenticatedSafe(authSafe, contentP)
+	defer wipe(nil, decrypted)
+	if err != nil {
+		return nil, nil, err
+	}
+	return opts.decodeBags(bags, contentP)
+}
+
+func (opts *DecodeOptions) decodeBags(bags []safeBag, password []byte) (privateKey interface{}, certificate *x509.Certificate, err error) {
+	if len(bags) == 0 {
+		return nil, nil, ErrEmptyKeystore
+	}
 	if len(bags) != 2 {
 		err = errors.New("expected exactly two safe bags in the PFX PDU")
 		return
@@ -215,20 +289,23 @@ func Decode(pfxData, utf8Password []byte) (privateKey interface{}, certificate *
 		case bag.ID.Equal(oidCertBagType):
 			certsData, err := decodeCertBag(bag.Value.Bytes)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, bag.wrapError(err)
 			}
 			certs, err := x509.ParseCertificates(certsData)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, bag.wrapError(err)
 			}
 			if len(certs) != 1 {
 				err = errors.New("expected exactly one certificate in the certBag")
-				return nil, nil, err
+				return nil, nil, bag.wrapError(err)
 			}
 			certificate = certs[0]
-		case bag.ID.Equal(oidPkcs8ShroudedKeyBagType):
-			if privateKey, err = decodePkcs8ShroudedKeyBag(bag.Value.Bytes, p); err != nil {
-				return nil, nil, err
+			if err = opts.checkCertificate(certificate); err != nil {
+				return nil, nil, bag.wrapError(err)
+			}
+		case isKeyBag(bag.ID):
+			if privateKey, err = opts.decodeKeyBag(&bag, password); err != nil {
+				return nil, nil, bag.wrapError(err)
 			}
 		}
 	}
@@ -239,20 +316,100 @@ func Decode(pfxData, utf8Password []byte) (privateKey interface{}, certificate *
 	if privateKey == nil {
 		return nil, nil, errors.New("private key missing")
 	}
+	if err = opts.checkValidity(certificate); err != nil {
+		return nil, nil, err
+	}
 
 	return
 }
 
-func getSafeContents(p12Data, password []byte) (bags []safeBag, actualPassword []byte, err error) {
-	pfx := new(pfxPdu)
+// ExtractPrivateKeyDER returns the unencrypted PKCS#8 DER encoding of the
+// private key in pfxData, exactly as it is stored in the file.
+// It fails if pfxData holds more than one private key; use DecodeAll for those.
+func ExtractPrivateKeyDER(pfxData []byte, password string) (der []byte, err error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	bags, decrypted, p, err := opts.getSafeContents(pfxData)
+	defer func() { // clear out the password and decrypted safe contents before we return
+		for i := 0; i < len(p); i++ {
+			p[i] = 0
+		}
+		for _, d := range decrypted {
+			for i := 0; i < len(d); i++ {
+				d[i] = 0
+			}
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bag := range bags {
+		if !isKeyBag(bag.ID) {
+			continue
+		}
+		if der != nil {
+			for i := 0; i < len(der); i++ {
+				der[i] = 0
+			}
+			return nil, errors.New("pkcs12: found more than one private key, use DecodeAll instead")
+		}
+		if der, err = opts.privateKeyDER(&bag, p); err != nil {
+			return nil, bag.wrapError(err)
+		}
+		if _, err = parsePKCS8PrivateKey(der); err != nil {
+			return nil, bag.wrapError(fmt.Errorf("error parsing PKCS8 private key: %v", err))
+		}
+	}
+
+	if der == nil {
+		return nil, errors.New("private key missing")
+	}
+	return der, nil
+}
+
+func (opts *DecodeOptions) getSafeContentsWithPassword(p12Data, password []byte) (bags []safeBag, actualPassword []byte, err error) {
+	if err = opts.checkInputSize(p12Data); err != nil {
+		return nil, nil, err
+	}
+	pfx, authSafe, err := parsePfx(p12Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if actualPassword, err = verifyPassword(pfx, authSafe, password); err != nil {
+		return nil, nil, err
+	}
+	opts.setMetadata(actualPassword, false, false, pfx.MacData.present())
+
+	if bags, _, err = opts.decryptAuthenticatedSafe(authSafe, actualPassword); err != nil {
+		return nil, nil, err
+	}
+	return
+}
+
+// parsePfx unmarshals the PFX PDU in p12Data and returns it along with the
+// content octets of the authenticated safe, which are covered by the MAC.
+// They are returned as found in p12Data, never re-encoded, as the MAC was
+// computed over whatever encoding the producer chose.
+func parsePfx(p12Data []byte) (pfx *pfxPdu, authSafe []byte, err error) {
+	return parsePfxVersion(p12Data, false)
+}
+
+// parsePfxVersion is parsePfx, optionally accepting PFX PDUs that declare a
+// version other than 3.
+func parsePfxVersion(p12Data []byte, anyVersion bool) (pfx *pfxPdu, authSafe []byte, err error) {
+	pfx = new(pfxPdu)
 	if _, err = asn1.Unmarshal(p12Data, pfx); err != nil {
 		return nil, nil, fmt.Errorf("error reading P12 data: %v", err)
 	}
 
-	if pfx.Version != 3 {
-		return nil, nil, NotImplementedError("can only decode v3 PFX PDU's")
+	if pfx.Version != 3 && !anyVersion {
+		return nil, nil, NotImplementedError(fmt.Sprintf("PFX version %d is not supported, only version 3 is", pfx.Version))
 	}
 
+	if pfx.AuthSafe.ContentType.Equal(oidSignedDataContentType) {
+		return nil, nil, ErrPublicKeyIntegrity
+	}
 	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
 		return nil, nil, NotImplementedError("only password-protected PFX is implemented")
 	}
@@ -261,59 +418,189 @@ func getSafeContents(p12Data, password []byte) (bags []safeBag, actualPassword [
 	if _, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &pfx.AuthSafe.Content); err != nil {
 		return nil, nil, err
 	}
+	return pfx, pfx.AuthSafe.Content.Bytes, nil
+}
+
+// IsPKCS12 reports whether data starts like PFX data: a SEQUENCE spanning all
+// of data, of the version 3 and a ContentInfo of type data or signedData, for
+// telling PKCS#12 files from PEM, JKS and other formats. Nothing further is
+// parsed, and no password is needed, so data that IsPKCS12 accepts may still
+// fail to decode.
+func IsPKCS12(data []byte) bool {
+	var pfx asn1.RawValue
+	if rest, err := asn1.Unmarshal(data, &pfx); err != nil || len(rest) != 0 || pfx.Class != asn1.ClassUniversal || pfx.Tag != asn1.TagSequence {
+		return false
+	}
+	var version int
+	rest, err := asn1.Unmarshal(pfx.Bytes, &version)
+	if err != nil || version != 3 {
+		return false
+	}
+	var authSafe asn1.RawValue
+	if _, err = asn1.Unmarshal(rest, &authSafe); err != nil || authSafe.Class != asn1.ClassUniversal || authSafe.Tag != asn1.TagSequence {
+		return false
+	}
+	var contentType asn1.ObjectIdentifier
+	if _, err = asn1.Unmarshal(authSafe.Bytes, &contentType); err != nil {
+		return false
+	}
+	return contentType.Equal(oidDataContentType) || contentType.Equal(oidSignedDataContentType)
+}
 
+// parseBarePfx parses the PFX PDU of a malformed producer that places the
+// AuthenticatedSafe SEQUENCE itself where the data ContentInfo wrapping it
+// belongs, and returns it as if it were wrapped. The MAC of such a PDU is
+// taken to cover the encoding of the AuthenticatedSafe, as it would have if
+// it were wrapped.
+func parseBarePfx(p12Data []byte, anyVersion bool) (pfx *pfxPdu, authSafe []byte, err error) {
+	var bare struct {
+		Version  int
+		AuthSafe asn1.RawValue
+		MacData  macData `asn1:"optional"`
+	}
+	if _, err = asn1.Unmarshal(p12Data, &bare); err != nil {
+		return nil, nil, fmt.Errorf("error reading P12 data: %v", err)
+	}
+	if bare.Version != 3 && !anyVersion {
+		return nil, nil, NotImplementedError(fmt.Sprintf("PFX version %d is not supported, only version 3 is", bare.Version))
+	}
+	var authenticatedSafe []contentInfo
+	if _, err = asn1.Unmarshal(bare.AuthSafe.FullBytes, &authenticatedSafe); err != nil {
+		return nil, nil, err
+	}
+
+	pfx = &pfxPdu{
+		Version:  bare.Version,
+		AuthSafe: contentInfo{ContentType: oidDataContentType},
+		MacData:  bare.MacData,
+	}
+	return pfx, bare.AuthSafe.FullBytes, nil
+}
+
+// verifyPassword checks password against the MAC of pfx, if there is one, and
+// returns the password that should be used to decrypt the authenticated safe.
+func verifyPassword(pfx *pfxPdu, authSafe, password []byte) (actualPassword []byte, err error) {
 	actualPassword = password
 	password = nil
-	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
-		if err = verifyMac(&pfx.MacData, pfx.AuthSafe.Content.Bytes, actualPassword); err != nil {
-			if err == ErrIncorrectPassword && bytes.Compare(actualPassword, []byte{0, 0}) == 0 {
-				// some implementations use an empty byte array for the empty string password
-				// try one more time with empty-empty password
-				actualPassword = []byte{}
-				err = verifyMac(&pfx.MacData, pfx.AuthSafe.Content.Bytes, actualPassword)
+	if pfx.MacData.present() {
+		if err = verifyMac(&pfx.MacData, authSafe, actualPassword); err != nil {
+			if err == ErrIncorrectPassword && nullTerminated(actualPassword) {
+				// some implementations leave the NULL terminator out of the password,
+				// most commonly using an empty byte array for the empty string password
+				// try one more time without it
+				actualPassword = actualPassword[:len(actualPassword)-2]
+				err = verifyMac(&pfx.MacData, authSafe, actualPassword)
 			}
 		}
 		if err != nil {
-			return
+			return nil, err
 		}
 	}
+	return actualPassword, nil
+}
 
+// decryptAuthenticatedSafe returns the bags of the authenticated safe, along
+// with the decrypted buffers they were parsed from so that callers can wipe them.
+func (opts *DecodeOptions) decryptAuthenticatedSafe(authSafe, password []byte) (bags []safeBag, decrypted [][]byte, err error) {
+	if len(authSafe) == 0 {
+		// an absent authenticated safe holds nothing, like an empty one
+		return nil, nil, nil
+	}
 	var authenticatedSafe []contentInfo
-	if _, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authenticatedSafe); err != nil {
+	if _, err = asn1.Unmarshal(authSafe, &authenticatedSafe); err != nil {
 		return
 	}
 
-	if len(authenticatedSafe) != 2 {
-		return nil, nil, NotImplementedError("expected exactly two items in the authenticated safe")
-	}
-
-	for _, ci := range authenticatedSafe {
+	// any number of ContentInfos is read, as RFC 7292 allows, the bags
+	// they hold being bounded by opts.MaxBags
+	for i, ci := range authenticatedSafe {
+		var safeContents []safeBag
 		var data []byte
-		switch {
-		case ci.ContentType.Equal(oidDataContentType):
-			if _, err = asn1.Unmarshal(ci.Content.Bytes, &data); err != nil {
-				return
-			}
-		case ci.ContentType.Equal(oidEncryptedDataContentType):
-			var encryptedData encryptedData
-			if _, err = asn1.Unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
-				return
-			}
-			if encryptedData.Version != 0 {
-				return nil, nil, NotImplementedError("only version 0 of EncryptedData is supported")
-			}
-			if data, err = pbDecrypt(encryptedData.EncryptedContentInfo, actualPassword); err != nil {
-				return
-			}
-		default:
-			return nil, nil, NotImplementedError("only data and encryptedData content types are supported in authenticated safe")
+		if safeContents, data, err = opts.decryptContentInfo(ci, i, password, opts.maxBags()-len(bags)); data != nil {
+			decrypted = append(decrypted, data)
 		}
-
-		var safeContents []safeBag
-		if _, err = asn1.Unmarshal(data, &safeContents); err != nil {
-			return
+		if err != nil {
+			return nil, decrypted, err
 		}
 		bags = append(bags, safeContents...)
 	}
 	return
 }
+
+// decryptContentInfo returns the bags of the ContentInfo at index of the
+// authenticated safe, along with the buffer they were decrypted into, if it
+// was encrypted. It returns ErrTooManyBags if there are more than maxBags
+// bags, and other errors as a DecodeError.
+func (opts *DecodeOptions) decryptContentInfo(ci contentInfo, index int, password []byte, maxBags int) (bags []safeBag, decrypted []byte, err error) {
+	if bags, decrypted, err = opts.decryptContentInfoBags(ci, index, password, maxBags); err != nil && err != ErrTooManyBags {
+		err = &DecodeError{ContentInfoIndex: index, BagIndex: -1, Err: err}
+	}
+	return
+}
+
+func (opts *DecodeOptions) decryptContentInfoBags(ci contentInfo, index int, password []byte, maxBags int) (bags []safeBag, decrypted []byte, err error) {
+	var data []byte
+	switch {
+	case ci.ContentType.Equal(oidDataContentType):
+		if _, err = asn1.Unmarshal(ci.Content.Bytes, &data); err != nil {
+			return
+		}
+	case ci.ContentType.Equal(oidEncryptedDataContentType):
+		var encryptedData encryptedData
+		if _, err = asn1.Unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
+			return
+		}
+		if encryptedData.Version != 0 {
+			return nil, nil, NotImplementedError("only version 0 of EncryptedData is supported")
+		}
+		if data, err = opts.pbDecrypt(encryptedData.EncryptedContentInfo, password); err != nil {
+			return
+		}
+		decrypted = data
+	default:
+		return nil, nil, NotImplementedError("only data and encryptedData content types are supported in authenticated safe")
+	}
+
+	var rawBags []rawSafeBag
+	if err = checkBagCount(data, maxBags); err == nil {
+		_, err = asn1.Unmarshal(data, &rawBags)
+	}
+	if err != nil {
+		if decrypted != nil && err != ErrTooManyBags {
+			// garbled plaintext fails the same way as bad padding
+			err = ErrDecryption
+		}
+		return nil, decrypted, err
+	}
+
+	bags = make([]safeBag, len(rawBags))
+	for i, bag := range rawBags {
+		if opts.Lenient && bag.ID.Equal(oidSecretBagType) {
+			bag, _ = secretKeyBag(bag)
+		}
+		bags[i] = safeBag{rawSafeBag: bag, contentInfoIndex: index, index: i}
+	}
+	return bags, decrypted, nil
+}
+
+// checkBagCount returns ErrTooManyBags if the SafeContents in data holds more
+// than maxBags bags. The bags are only skipped over, so that a huge number of
+// them is refused before any is decoded.
+func checkBagCount(data []byte, maxBags int) error {
+	var safeContents asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &safeContents); err != nil {
+		return err
+	}
+	rest := safeContents.Bytes
+	for n := 0; len(rest) > 0; n++ {
+		if n == maxBags {
+			return ErrTooManyBags
+		}
+		var bag asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &bag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
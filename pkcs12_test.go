@@ -1,12 +1,20 @@
 package pkcs12
 
 import (
+	"bytes"
+	"crypto/dsa"
 	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPfx(t *testing.T) {
@@ -60,6 +68,442 @@ func TestPEM(t *testing.T) {
 	}
 }
 
+func TestDecodeOptionsPasswordFunc(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+
+	var returned [][]byte
+	candidates := []string{"wrong", ""}
+	opts := DecodeOptions{
+		Password: func() ([]byte, error) {
+			p := []byte(candidates[len(returned)])
+			returned = append(returned, p)
+			return p, nil
+		},
+		MaxAttempts: 2,
+	}
+
+	_, c, err := opts.Decode(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject.CommonName != "testing@example.com" {
+		t.Errorf("unexpected common name '%s'", c.Subject.CommonName)
+	}
+	if len(returned) != 2 {
+		t.Fatalf("expected the password function to be called twice, but was called %d times", len(returned))
+	}
+	for _, p := range returned {
+		if bytes.Count(p, []byte{0}) != len(p) {
+			t.Errorf("expected password buffer to be zeroed, but found: % x", p)
+		}
+	}
+
+	returned = nil
+	opts.MaxAttempts = 1
+	if _, _, err = opts.Decode(p12); err != ErrIncorrectPassword {
+		t.Errorf("expected incorrect password, got err: %v", err)
+	}
+	if len(returned) != 1 {
+		t.Errorf("expected the password function to be called once, but was called %d times", len(returned))
+	}
+}
+
+func TestDecodeRC4(t *testing.T) {
+	// created with: openssl pkcs12 -export -legacy -certpbe PBE-SHA1-RC4-40 -keypbe PBE-SHA1-RC4-128
+	p12, _ := base64.StdEncoding.DecodeString(rc4TestData)
+
+	_, c, err := Decode(p12, []byte("rc4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject.CommonName != "fixture" {
+		t.Errorf("unexpected common name '%s'", c.Subject.CommonName)
+	}
+
+	opts := DecodeOptions{
+		Password:          passwordString("rc4"),
+		AllowedAlgorithms: []asn1.ObjectIdentifier{oidPbeWithSHAAnd3KeyTripleDESCBC},
+	}
+	if _, _, err = opts.Decode(p12); err == nil {
+		t.Errorf("expected RC4 to be refused when not in the allowed algorithms")
+	}
+}
+
+func TestExtractPrivateKeyDER(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs := NewContentInfoBuilder()
+	certs.AddCertificate(cert.Raw)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(keys)
+	pfx.Add(certs)
+	p12, err := pfx.Build([]byte("extract"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := ExtractPrivateKeyDER(p12, "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, pkcs8) {
+		t.Errorf("expected the PKCS#8 encoding of the key to be preserved")
+	}
+
+	if _, err = ExtractPrivateKeyDER(buildTestPFX(t, "extract"), "extract"); err == nil {
+		t.Errorf("expected an error for a PFX with more than one private key")
+	}
+}
+
+func TestDecodePasswordWithoutNullTerminator(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd40BitRC2CBC, nil, 1000)
+	certs.AddCertificate(cert.Raw)
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(keys)
+	pfx.Add(certs)
+
+	for _, terminated := range []bool{true, false} {
+		password, _ := bmpString([]byte("null"))
+		if !terminated {
+			password = password[:len(password)-2]
+		}
+		p12, err := pfx.build(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var metadata Metadata
+		opts := DecodeOptions{Password: passwordString("null"), Metadata: &metadata}
+		if _, _, err = opts.Decode(p12); err != nil {
+			t.Errorf("terminated %v: %v", terminated, err)
+		}
+		if metadata.PasswordNullTerminated != terminated {
+			t.Errorf("terminated %v: metadata reports %v", terminated, metadata.PasswordNullTerminated)
+		}
+		if _, _, err = Decode(p12, []byte("null")); err != nil {
+			t.Errorf("terminated %v: %v", terminated, err)
+		}
+	}
+}
+
+func TestDecodeDSA(t *testing.T) {
+	// created with: openssl pkcs12 -export -legacy, with a 1024-bit DSA key
+	p12, _ := base64.StdEncoding.DecodeString(dsaTestData)
+
+	priv, cert, err := Decode(p12, []byte("dsa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok := priv.(*dsa.PrivateKey)
+	if !ok {
+		t.Fatalf("expected a DSA private key, got %T", priv)
+	}
+	pub, ok := cert.PublicKey.(*dsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected a DSA certificate, got %T", cert.PublicKey)
+	}
+	if key.Y.Cmp(pub.Y) != 0 || key.P.Cmp(pub.P) != 0 {
+		t.Errorf("expected the private key to match the certificate")
+	}
+
+	// DSA keys whose domain parameters are inherited from elsewhere cannot be used
+	der, err := asn1.Marshal(pkcs8{
+		Algo:       pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyDSA},
+		PrivateKey: []byte{2, 1, 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parsePKCS8PrivateKey(der); err == nil {
+		t.Errorf("expected an error for a DSA key without domain parameters")
+	} else if _, ok := err.(NotImplementedError); !ok {
+		t.Errorf("expected not implemented error, got: %T %s", err, err)
+	}
+}
+
+func TestDecodeIncorrectPassword(t *testing.T) {
+	p12 := buildTestPFX(t, "correct")
+
+	// no algorithm is allowed, so reaching decryption would fail differently
+	opts := DecodeOptions{Password: passwordString("incorrect"), AllowedAlgorithms: []asn1.ObjectIdentifier{}}
+	if _, err := opts.DecodeAll(p12); err != ErrIncorrectPassword {
+		t.Errorf("expected the MAC to fail before decryption, got err: %v", err)
+	}
+
+	// without a MAC, failures to decrypt are not told apart
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	noMac, err := asn1.Marshal(struct {
+		Version  int
+		AuthSafe contentInfo
+	}{pfx.Version, pfx.AuthSafe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DecodeAll(noMac, "incorrect")
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Err != ErrDecryption {
+		t.Errorf("expected decryption error, got err: %v", err)
+	} else if decodeErr.ContentInfoIndex != 0 || decodeErr.BagIndex != -1 {
+		t.Errorf("expected the first ContentInfo to fail, found %+v", decodeErr)
+	}
+}
+
+func TestDecodeErrorLocation(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second bag of the second ContentInfo has a localKeyId that is not
+	// an OCTET STRING
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(cert.Raw)
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, newAttribute(oidLocalKeyID, 42))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecodeAll(p12, "location")
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a DecodeError, got err: %v", err)
+	}
+	if decodeErr.ContentInfoIndex != 1 || decodeErr.BagIndex != 1 {
+		t.Errorf("expected the error to locate ContentInfo 1, bag 1, found %+v", decodeErr)
+	}
+	if !strings.Contains(err.Error(), "localKeyId") || !strings.HasSuffix(err.Error(), "(ContentInfo 1, bag 1)") {
+		t.Errorf("unexpected message %q", err)
+	}
+}
+
+// testForm converts between the composed and decomposed forms of é only,
+// standing in for the forms of golang.org/x/text/unicode/norm.
+type testForm bool
+
+func (decompose testForm) Bytes(b []byte) []byte {
+	if decompose {
+		return bytes.ReplaceAll(b, []byte("\u00e9"), []byte("e\u0301"))
+	}
+	return bytes.ReplaceAll(b, []byte("e\u0301"), []byte("\u00e9"))
+}
+
+func TestDecodeNormalizePassword(t *testing.T) {
+	nfc, nfd := testForm(false), testForm(true)
+	for _, encoded := range []string{"caf\u00e9", "cafe\u0301"} {
+		p12 := buildTestPFX(t, encoded)
+		for _, typed := range []string{"caf\u00e9", "cafe\u0301"} {
+			opts := DecodeOptions{Password: passwordString(typed)}
+			if _, err := opts.DecodeAll(p12); (err == nil) != (typed == encoded) {
+				t.Errorf("%+q typed as %+q without normalization: got err: %v", encoded, typed, err)
+			}
+
+			attempts := 0
+			opts.Password = func() ([]byte, error) {
+				attempts++
+				return []byte(typed), nil
+			}
+			opts.NormalizePassword = []PasswordNormalizer{nfc, nfd}
+			if _, err := opts.DecodeAll(p12); err != nil {
+				t.Errorf("%+q typed as %+q: %v", encoded, typed, err)
+			}
+			if attempts != 1 {
+				t.Errorf("%+q typed as %+q: password was obtained %d times", encoded, typed, attempts)
+			}
+		}
+	}
+
+	opts := DecodeOptions{Password: passwordString("caf\u00e9"), NormalizePassword: []PasswordNormalizer{nfd}}
+	if _, err := opts.DecodeAll(buildTestPFX(t, "caf\u00e9")); err != ErrIncorrectPassword {
+		t.Errorf("expected only the decomposed form to be tried, got err: %v", err)
+	}
+}
+
+func TestDecodeTryUTF8Password(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd40BitRC2CBC, nil, 1000)
+	certs.AddCertificate(cert.Raw, NewLocalKeyIDAttribute([]byte{1}))
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, NewLocalKeyIDAttribute([]byte{1}))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	// as a nonconforming producer would, with the UTF-8 bytes of the password
+	p12, err := pfx.build([]byte("caf\u00e9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DecodeOptions{Password: passwordString("caf\u00e9")}
+	if _, _, err = opts.Decode(p12); err != ErrIncorrectPassword {
+		t.Errorf("expected the BMP password not to verify, got err: %v", err)
+	}
+
+	var metadata Metadata
+	opts.TryUTF8Password = true
+	opts.Metadata = &metadata
+	privateKey, certificate, err := opts.Decode(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := privateKey.(*rsa.PrivateKey); !ok || !k.Equal(key) || !certificate.Equal(cert) {
+		t.Error("expected the encoded identity")
+	}
+	if !metadata.PasswordUTF8 || metadata.PasswordNullTerminated || !metadata.MACVerified {
+		t.Errorf("expected the UTF-8 password to be reported, got %+v", metadata)
+	}
+
+	if _, err = opts.DecodeAll(buildTestPFX(t, "caf\u00e9")); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.PasswordUTF8 || !metadata.PasswordNullTerminated {
+		t.Errorf("expected the BMP password to be reported, got %+v", metadata)
+	}
+
+	opts.Password = passwordString("cafe")
+	if _, _, err = opts.Decode(p12); err != ErrIncorrectPassword {
+		t.Errorf("expected an incorrect password to fail, got err: %v", err)
+	}
+}
+
+func TestDecodeTryNULTerminatedPassword(t *testing.T) {
+	key, cert := testIdentity(t)
+	// as a producer counting the terminating NUL of a C string would
+	p12, err := Encode(key, cert, nil, "secret\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DecodeOptions{Password: passwordString("secret")}
+	if _, _, err = opts.Decode(p12); err != ErrIncorrectPassword {
+		t.Errorf("expected the password without its NUL not to verify, got err: %v", err)
+	}
+
+	var metadata Metadata
+	opts.TryNULTerminatedPassword = true
+	opts.Metadata = &metadata
+	privateKey, certificate, err := opts.Decode(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := privateKey.(*rsa.PrivateKey); !ok || !k.Equal(key) || !certificate.Equal(cert) {
+		t.Error("expected the encoded identity")
+	}
+	if !metadata.PasswordTrailingNUL || !metadata.PasswordNullTerminated || metadata.PasswordUTF8 {
+		t.Errorf("expected the password with its NUL to be reported, got %+v", metadata)
+	}
+
+	if _, err = opts.DecodeAll(buildTestPFX(t, "secret")); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.PasswordTrailingNUL {
+		t.Errorf("expected the password as given to be reported, got %+v", metadata)
+	}
+}
+
+func TestDecodeRejectSignatureAlgorithms(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+	_, cert := testIdentity(t)
+
+	opts := DecodeOptions{RejectSignatureAlgorithms: []x509.SignatureAlgorithm{cert.SignatureAlgorithm}}
+	if _, _, err := opts.Decode(p12); err == nil {
+		t.Errorf("expected a certificate signed with %v to be rejected", cert.SignatureAlgorithm)
+	} else if !strings.Contains(err.Error(), "testing@example.com") || !strings.Contains(err.Error(), cert.SignatureAlgorithm.String()) {
+		t.Errorf("expected the error to name the certificate and algorithm, got: %v", err)
+	}
+	if _, err := opts.DecodeAll(p12); err == nil {
+		t.Errorf("expected DecodeAll to reject the certificate too")
+	}
+
+	opts.RejectSignatureAlgorithms = []x509.SignatureAlgorithm{x509.MD5WithRSA}
+	if _, _, err := opts.Decode(p12); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecodeRejectExpired(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+	_, cert := testIdentity(t)
+
+	var now time.Time
+	opts := DecodeOptions{RejectExpired: true, Now: func() time.Time { return now }}
+	for _, tt := range []struct {
+		name  string
+		now   time.Time
+		valid bool
+	}{
+		{"valid", cert.NotBefore.Add(time.Second), true},
+		{"expired", cert.NotAfter.Add(time.Second), false},
+		{"not yet valid", cert.NotBefore.Add(-time.Second), false},
+	} {
+		now = tt.now
+		_, _, err := opts.Decode(p12)
+		if tt.valid {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected the certificate to be rejected", tt.name)
+		} else if !strings.Contains(err.Error(), "testing@example.com") || !strings.Contains(err.Error(), cert.NotAfter.UTC().String()) {
+			t.Errorf("%s: expected the error to name the certificate and its validity, got: %v", tt.name, err)
+		}
+		if _, _, _, err = opts.DecodeChain(p12); err == nil {
+			t.Errorf("%s: expected DecodeChain to reject the certificate too", tt.name)
+		}
+	}
+
+	opts.RejectExpired = false
+	if _, _, err := opts.Decode(p12); err != nil {
+		t.Errorf("unexpected error without RejectExpired: %v", err)
+	}
+}
+
+func TestDecodeSignedData(t *testing.T) {
+	p12, err := asn1.Marshal(pfxPdu{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidSignedDataContentType,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x30, 0}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = Decode(p12, []byte("")); err != ErrPublicKeyIntegrity {
+		t.Errorf("expected public-key integrity error, got: %v", err)
+	}
+}
+
 func ExampleConvertToPEM() {
 	var p12, _ = base64.StdEncoding.DecodeString(`MIIJzgIBAzCCCZQGCS ... CA+gwggPk==`)
 	blocks, err := ConvertToPEM(p12, []byte("password"))
@@ -139,3 +583,657 @@ p8SsTugkit8wOwYJKoZIhvcNAQkUMS4eLABGAHIAaQBlAG4AZABsAHkAIABuAGEAbQBlACAAZgBv
 AHIAIABjAGUAcgB0MDEwITAJBgUrDgMCGgUABBRFsNz3Zd1O1GI8GTuFwCWuDOjEEwQIuBEfIcAy
 HQ8CAggA`,
 }
+
+var rc4TestData = `MIIF8AIBAzCCBbYGCSqGSIb3DQEHAaCCBacEggWjMIIFnzCCAqUGCSqGSIb3DQEHBqCCApYwggKS
+AgEAMIICiwYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQIwDgQItsIQvvb6rUACAggAgIICXifFz3LB
+Fv+4EtbAuEXs85fNZEyQtksekI4yAKqWaSgmDVU7Ror3J8YPLLO37ZJRsMcsr/GbDlktXzdwi8AS
+2hGkcxj43m4OSTauwFiQpKhQKf7JMNBxeutvsBoOso6kpfJrWb8dtXhQtQOyBHqM4mOqTwd+Rquj
+aEZimaekRCP196YAsynrw/Sw/l+619Uqv7koYmKuwnU4Zv6WNXc2HEfag+1KAmbsZ5jrWCj2184i
+zZh6V+5JiSW/w0/J4hUb21AjcgazAw8KLqLm0Z7dgjLYJLOPXXOXtZiZ+6gPxGA/6nPAcv29lU+D
+/068GAWs//xMisBF2At2SmyUWlkZKtaK2/WJqwvnJbWqOI5cNYcd7X5wzwEO+37HEOICh7HZiSJn
+PKKcL/SvPBosbfBcLSzyC4hXTiQf0xNgc1Yl2i17yl9yFRlU/cgQXnb58Om7fo01ivU3WX5ROHVJ
+XC80c2lJmnpE6UrC2ZbQII69Xctx6A3nmfskgnIE07A5qKBV7dpVziNw+MH7regsGGRKPcSUq4U0
+8pfjciz45N/4jWbrVSEaV5404aTavxZrwUZuJtTPliJtn3Jc6gtL11572hjd2QnoBrL6+qTo5EqO
+ZWVCeMIbzNlRwXUHmtSo8tPIraYBfezcpzMI0EO+2JpoPHQUbNj80YsTqj9pYitaRWdxklJH+30z
+sGUDnfYAs+NisV7qQiRGeyX+VuUbEYgSRBe+SKe0P0PUuviuv5pzZJVyLLBXqsPDeBpJ8OfYmi+k
+ptvhjt+Om6O7tZYqfmzXymexZn5nSwa1NSSrUUPItDCCAvIGCSqGSIb3DQEHAaCCAuMEggLfMIIC
+2zCCAtcGCyqGSIb3DQEMCgECoIICnzCCApswHAYKKoZIhvcNAQwBATAOBAgKmT/dPi/hAQICCAAE
+ggJ59zu3nudjXCdsOdx5svjmecC5rcMTm9GU80PNyc8h4uuNgm0fIwfhiMVzUY1glH9ucQ3+qBuj
+AZIFa3VuStW39KnD2AR/RkVJwesS6D5LuhNbRpWJy7ICZ9+LtLeTk8xa5ju4iWIBLPoi06ZTcIme
+Rp2QTYiS2j2FIlpCbGylxrHtwnEp3ylO8fn8yGqCgltPuiEOeYdn4aXrgtfzuUIOGnrPT0Btbdp5
+y65z4pRibsyov2jjVEjZqjIftl8VXRRKFAy8XA7+WExGEs44EmRkfG/Qgq+v8Q7t78SZb1HArLd5
+axuc3XNgs1BkVU6vDnMw9PYtW01weGjlr1tUq7cUi8fTR1zXeoQHceJNOKbukkfoiVBTor69IKpj
+2owwQfLDMe+oRnQJUQ0IuriXFb1WvgZ8OMK3LkGPyjdxW5l6G/XC8epTeD8QAjtSNPH4W7Ts2M0/
+voK53+N7J2IXkXx9TZjgqK0xOskruqC1nJ7DSAIyjzs43prNlYRXQ+2QH00UvZPNKAby0tRTdVo8
+EMAJoFHJghYgt3DU1zj/7zmxdml9dhQ4FXQoEgtp4S/nj2AH3CmHwF9TaEsm+/pMGmGZK4eTcv/f
+EuoyBQ74K69IRdw8TOPEe7aXuZNrdXa609Dw+JrsycJWz93us1epc1dvxR1B6pGxon9tNY8s4Dz2
+WaKvaK52YUJAG3sQkVbnn7SwTiwVyw7aFCn8Qh7W+xMv+zrx5kuk1OiI4gPx8rtadH+fs/wyAMrr
+G5NkLhBFYqARuFj4W/SifZs1AYEd9NDa/uPqWjyRfJ+lqNLBtEmVbJkOJCaYTAWlxsEw+vx0FGJn
+dwJgIv4THUqOMSUwIwYJKoZIhvcNAQkVMRYEFCNQPA1TbYFGgjMnMAfp9NvkKlILMDEwITAJBgUr
+DgMCGgUABBR43nK7Uoe9ycs1HnT9ZhKoJkUgpQQIK1pY2uCh3YMCAggA`
+
+var dsaTestData = `MIIF4AIBAzCCBaYGCSqGSIb3DQEHAaCCBZcEggWTMIIFjzCCA5cGCSqGSIb3DQEHBqCCA4gwggOE
+AgEAMIIDfQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQYwDgQIZiMB34ScW7ACAggAgIIDUA4GPrew
+fVuogc4najDUPwNa09kGm/DMZwHjjWz4TUoond4SmuqCiu74nW/OliB4ft6IyIfHD035gyC9Czna
+5481KUnF+4kHqzw7h7MQ/5u5ZsLqzztSSgdwtIy8j50bxxEgDW2Yk/DRCsH3viz2hBRnPkBppMz7
+D6g6B7gYAcSkehKfYnjrD6WGOHvExQGk1SrJQDY2fSsK5oh3U+9NeTgSnxL/z4v2XScz7hf++gU6
+zUSzj9HBI5aYPwSj+GwMs2RkUZnHCubHS9NU9WC7v5fAXagN9oIM14kQYReyT9N8/amJzRer2TXF
+jv/vJzEoTml95y936gN9ogZLQiuUv0zoxihRpx+Lkg9eMo9wGS6ETBRJtr5XpkGXCcS0re/j+a/q
+vVeCW9eRYshqrTr9Dql+keSR1HwSo3BHFC4Vis3HSXaZ9QC1OwhWAgIQT9tQWt2YNqYpbdJuAxQF
+zfgEHnZIlCExJDx5t7YRZMaRMEAy8LIIbjwiBek3BaVzko7EK2Qien7VqO8F00AaOptJ9sEHcFtF
+cdlTOGrFvt9YTMYTF0y6C4AbOIy9AZSPVx1Os33VFga5txpTSS7ACi0jGvovwvSz/IINdHjugzhW
+wJroxvaV2lbNGjrRHHIZe0yLBqrPsFUq/u6To2MwkleyA35Z8FgMdawyrcL2WjF7vh9xfO2NUync
+Erka8jgwrppvlfGyYZYfGFdx+bCEXsx5hsDH9WfhubUA0MePYiKj+uiSl0/TZrDAThM/5nBldZRL
+Eo6aJ7uiqp/oZks26/bruIe3HknZX4CPuAkgEypSn2ZaTeDGTqzUuZwHEk2GeQ1O66Sdgl6s//nh
+EXKcXJQ9dWUFiJnnzmsRy8+Qw+NMUKsBUzdQ3MBYC4ivcH5aEffhTzxhfZqi65Z2wSucLkFcEc4s
+sP5kPCih6bNrZv6mBSl3FygVmWxN4caQhOU7RuQT2dFW6sjQTxnx0TpUf9QomxgW1gLGnWSGVGqk
+ppLEtaLs8vgl75iYMSAq3nEI4uZ7aU2CF3zrr4DFu9R8gWxsnVDAskUCtRGrtPnuFurWHGezk8/Q
+Oq+bFyySyJGoEniOyfVM/PRY4Y1COcVd8tvVxR9y8Mr4whAMOWKK7k3YCH92MIIB8AYJKoZIhvcN
+AQcBoIIB4QSCAd0wggHZMIIB1QYLKoZIhvcNAQwKAQKgggGGMIIBgjAcBgoqhkiG9w0BDAEDMA4E
+CAOlSMwQX5XRAgIIAASCAWDyKMr1j3+RlBfNEKJEns0keAFp9VwW82z46LgGZ3INmvvdF7yzRX7v
+8MT2cFo/yLk+MDKDGFEMmzPf9yQRCZmO43U0CTWtPt2CkBDIuqYnjDav4lDYhh7lcb8Z+fExpPlT
+ymSeTUgjgW24hRSMRTuyE3buydKJrxrej+9SZhFIgllnRychkGcbJFuzdYjwLfAn+37suV161O6T
+DBTgYhmUM/+n47BhizPQ+Ew6B6dRTmoXoe8r1yoGQL6EE0uJyrs7cpCiQX0YmF8GDVtd38oydkvq
+nSsRwWBz6F5aOhGSzkvl75ggVZlTLqXuUfRxa0ceq5XvDEuF5AnZZzCCPQ8jzfZDxGTM/ln/wpZe
+By3RS3ktnJDdMnNGOcGnWgEAC8WlOVJ4Iz1eTcIR09XkVuf9l9A+bBk1bQbp3YQTEjCfDpw/P3MW
+7nQeTgkTK0E0uvWNMvk3jdGs584SnGk87fLeMTwwFQYJKoZIhvcNAQkUMQgeBgBkAHMAYTAjBgkq
+hkiG9w0BCRUxFgQUGmOoHRKkJgq1Iu8z/dPSF8OisdQwMTAhMAkGBSsOAwIaBQAEFG5Sy8fjj/zp
+7P80r3NKgYzNVa5gBAg3KW4b6ot4ugICCAA=`
+
+func TestDecodeMaxBags(t *testing.T) {
+	root := newTestCert(t, "root", nil)
+	intermediate := newTestCert(t, "intermediate", root)
+	leaf := newTestCert(t, "leaf", intermediate)
+	p12 := buildChainPFX(t, "bags", leaf, intermediate, root)
+
+	// three cert bags in the first ContentInfo, the key bag in the second
+	for _, test := range []struct {
+		maxBags int
+		err     error
+	}{{0, nil}, {4, nil}, {3, ErrTooManyBags}, {2, ErrTooManyBags}} {
+		opts := DecodeOptions{Password: passwordString("bags"), MaxBags: test.maxBags}
+		if _, err := opts.DecodeAll(p12); err != test.err {
+			t.Errorf("MaxBags %d: expected err %v, got: %v", test.maxBags, test.err, err)
+		}
+	}
+}
+
+func TestDecodeEmptyKeystore(t *testing.T) {
+	empty, _ := base64.StdEncoding.DecodeString(emptyKeystoreTestData)
+
+	// the same with no authenticated safe at all, the MAC computed over
+	// nothing
+	var pfx pfxPdu
+	if _, err := asn1.Unmarshal(empty, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	pfx.AuthSafe.Content.FullBytes = nil
+	pfx.AuthSafe.Content.Bytes = []byte{asn1.TagOctetString, 0}
+	password, _ := bmpString([]byte("empty"))
+	var err error
+	if pfx.MacData.Mac.Digest, err = generateMac(string(SHA1), nil, pfx.MacData.MacSalt, password, pfx.MacData.Iterations, nil); err != nil {
+		t.Fatal(err)
+	}
+	absent, err := asn1.Marshal(pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, p12 := range map[string][]byte{"empty": empty, "absent": absent} {
+		if _, _, err := Decode(p12, []byte("empty")); err != ErrEmptyKeystore {
+			t.Errorf("%s: expected an empty keystore, got err: %v", name, err)
+		}
+		if entries, err := DecodeAll(p12, "empty"); err != nil || len(entries) != 0 {
+			t.Errorf("%s: expected no entries, got %d, err: %v", name, len(entries), err)
+		}
+		if _, err := DecodeAll(p12, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected the MAC to be verified, got err: %v", name, err)
+		}
+	}
+}
+
+// emptyKeystoreTestData holds an authenticated safe of no ContentInfo, with
+// a SHA-1 MAC of 2048 iterations for the password "empty"; openssl pkcs12
+// -info reads it as holding nothing
+var emptyKeystoreTestData = `MEkCAQMwEQYJKoZIhvcNAQcBoAQEAjAAMDEwITAJBgUrDgMCGgUABBRoCp/zYppltDWtX0yd/Lwg6GCb9QQIZW1wdHlzYWwCAggA`
+
+func TestDecodeSecretBagShroudedKey(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(secretBagKeyTestData)
+	key, cert := testIdentity(t)
+
+	if _, _, err := Decode(p12, []byte("secret")); err == nil {
+		t.Error("expected the key nested in a secretBag to be ignored unless lenient")
+	}
+
+	opts := DecodeOptions{Password: passwordString("secret"), Lenient: true}
+	privateKey, certificate, err := opts.Decode(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := privateKey.(*rsa.PrivateKey); !ok || !k.Equal(key) || !certificate.Equal(cert) {
+		t.Error("expected the identity of the fixture")
+	}
+	entries, err := opts.DecodeAll(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].PrivateKey == nil || !bytes.Equal(entries[0].LocalKeyID, []byte{1}) {
+		t.Errorf("expected the key paired with its certificate by the localKeyId of the secretBag, got %+v", entries)
+	}
+}
+
+// secretBagKeyTestData holds the identity of testIdentity with its
+// PBES2-AES256-CBC shrouded key nested in a secretBag, whose secretTypeId is
+// pkcs8ShroudedKeyBag, rather than in a pkcs8ShroudedKeyBag, for the password
+// "secret"; openssl pkcs12 -info lists it as a secret bag
+var secretBagKeyTestData = `MIIJhgIBAzCCCUwGCSqGSIb3DQEHAaCCCT0Eggk5MIIJNTCCA6cGCSqGSIb3DQEHBqCCA5gwggOU
+AgEAMIIDjQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQI8alTtWfHVaQCAggAgIIDYG1GHTM0
+W8fI0PznrQiXrnWOJggJldl731qb1zDpVyxKowX5ri6kNGvXCOpGC0neTjSNWlReZeieo1Gh0SIm
+zcEd9U6ogQNzsyJsreisY/iQeBTmTsclAAT+wavaATxmgyA1YFvah9YZa2kEzlVxRCIcH+VAcnQr
+5Y1qXcbSF9dotIt+HIhtKQry38Oc2NmQiys+Za5rOdreYLlt+p8v1PIlEpaQdbpInqPU4WXCDdsU
+g6JhkCOtwXcSPGFI5I4RZRx9TLiWUhzpRTwykuPvXk6LCIL6Qy8nyRoHJoLqybAqjTnTWuK+X1oq
+5wjG1wu7tBvdkElhqA/ZFBMe9D2belsL1CiaeIs6dVERRT6WoxsuZR7GiHpLKCt80kco9IsndbW+
+ZnYkT1V7ajTJU/uGGWA+us6KfNO0SwhVfM5W/DfUOQU8wX2G8CUd2XbDFXalS9v5pLCO8W0pX0Nj
+16g+mVNkyq99HPDdJbMCZjt0uFfaSSGMR3Zy7aPBm34BV9B4g4XuGVqn5VPk5wylkV4BGqI7yTGJ
+seqCN7g1PhR0JqLYPkedQM0gW3PUBml2gj2YgMYQbNm9WfVaMKgVIt1AA/wMX8payKLQGbJmqehr
+Xql1wfBZMRk5gPJCMiXkn3miII/I127X4FmLgLwa5NawzkcqPZdflTRauJIUO7fJZk1RyePNYHC+
+U9wp7FKnwpGCQhebcy1A3TfO9PHMqSpy4vIV0R0NAs9grmdBrieS5TIcwrncN+IAcV2MLmekKpX7
+t8BsHZWt2ByuNbrj+IbjiP4althQVI5y9Tthx2YNx/dJ1+/rF2pMKrDtGUhvcgexxt6MIeKqHK3L
+ud6kBsysmJwKYRIpXWnlL7Ij57d7TIMW31DpqFfwVGtNR80xWDfTMLt4d97s//Yy6IVXi05SD3Y+
+A7RNqDyF4ejjUZigxn2LW6XWXDZm7ZUHQmh+QbONyIJghkLS2r2XgcXLu6Foc5HJGbfCNmMEF9m2
+K6pXFKuaQjp8NFnkarIb+vH2cX2kpazNV+JNor0mfVYRSepARcXEIgGlwknuEJs+dz8vOXJ5avvu
+l/JuaIIzc+3Gp+vxf9fb6eXbcD8UkMhd7rqZ/G2TpPXb3TRy+h2KABbGh7W15EkDJlsaPiH3w/41
+jlmHpzCCBYYGCSqGSIb3DQEHAaCCBXcEggVzMIIFbzCCBWsGCyqGSIb3DQEMCgEFoIIFRjCCBUIG
+CyqGSIb3DQEMCgECoIIFMTCCBS0wVwYJKoZIhvcNAQUNMEowKQYJKoZIhvcNAQUMMBwECGJCEg9G
+0xyrAgIIADAMBggqhkiG9w0CCQUAMB0GCWCGSAFlAwQBKgQQmVX/Pcilq4cPwKZS3UVYwgSCBNDy
+qFHUqsCjpWUWemjU2XaPNoNe4+VBx8k8+75j/WP47Si15JVUUhb0LO5GmveOPSTDSb/CM1zFtPzh
+RRFvabtFF0HqtcldzOqJ2D0k9fpFRtEuDzTamUOomzrjOvDbz3xYHitMD6Ey8hYA7EsuCSeFlbFh
+FdM0RxHZZqi1kej/pTpGglXhTXPD4koME0O86DdQ7ViagxTNOg8Git0R4HkrSnFKViGJJxfy3ng0
+0ROYLuphP2cYJ6XfmV3GNmi0Lxo6IOYSbsl8pVmEShVPeyd74epC8fDdyFFARFqpcQg2q763H7co
+5otIft5Po41FToUggD1VlVBtc7lp/G8bV/Yc+DmJ1GK/yl9NWaC6FR61iNH3jJP/NsyBh3tx0iJb
+DTjS/nY1SNDI9zQkzg/OXna2wU2RmNZfnaihJvpSTDWEnlCUKWRNAoG8mlxc8mYBzDpbTBerQMKy
+LAtICNTrxH6rS25KM6XVYzV8h75lSZPOimi/ciFQopyHXEnVL4kMnoYeJf+5Ag4Kot4C0FswTj29
+JJcOr4VutTieujt2KnBARKHx5EAzgJ927u/Jk+tO9+M13mpgsg5VyQ1bmkX2Xz2aZ6vX0oS7b0nd
+KrqGAhaZpZhE8i5pSny32DSDuMfSFViRJenBNWq7m/aECvC+AuzGzohY7DRe9qtxforIrFwCPd/u
+zjfjUXmjKlZ0t6Qjj9a6EeD8oXkSm63u/zYLq0xMYFKJ7mJ/92UQj12r35p0HugA6ZwkSxygC9aB
+3XOL1rp1bIeBqz3qeTexCnOkP9lUnGlE6UITggLC21/i+Y9JiAfIaRCjcTBrwOyWEVocS6KQT9GK
+DDO9UTzOjnJMDnmWU1xNYeEmWomgY75rwEOZkOqP+geuvDaCs1h+PnH1yv6O3Yr0BpW/8a8UbjtI
+eCtvifBAq7XZgVZGIIFekbNk678wCAJzsnbqCzoG58vDtAsrMDG6Of8p2e3hVOCoXL+FDRGiyu62
+IbAhPSiCQpqtEeMi56DC2gklQ9ANUpJl/DvdHiPRJxhhNbe6ObrGG3RwTtM8ce2wv+8yn4c4QH/+
+5y94SUwOACdV2+ymdrJgZnqFayIKt4UAwbd1dIYR4jC9ckjjCyLl5jSB5puy/fsNQOQGCj+JXRVL
+0ku0XKk1U6kS1svgIGCgbEQ8V6KL49IF5g6x+sQJF0imV9txXC1x4AbpKmLN+4zqOZ6SdMlQ7T6I
+KcM1pfDgY47Rvq/zddmQ2RbMVsYUDvlP/g6kqDLBdGM0oCV/PXG/3gV5EdYBBEFQe54o7MCasA6e
+7ywf4bnWQ39kiFqqkKDL8Kfx1BXLtc2FhgURJof5yPY36dTT/I9QCh8Eg4Apuvfgwx1J15SSzaxm
+6YuXLbd0EJPbyIaDST8vJU1Flo1R7pN74gPtX59OasK9KIPM2pPiuY2rUIz6+Hs5LB9M8zQ9MP4A
+ORMtu198rzE0AQiCZYfJJOF8Tk/IRoag50Is6Vk+eO5XmCqy2kby3cFij0v/qcDzPyFAIZbXMZ7v
+HDT5V8Qrw8lVT6SvW6ySTqHvVzianMeCQD4DiOnBaJDlp3srqG0tUzd6ArApHS1XHKx9sTXN+BGt
+GzVoMxeqolplxBpc3f1SGfjNwDKhs39LO3DIz4CE9TpP/TESMBAGCSqGSIb3DQEJFTEDBAEBMDEw
+ITAJBgUrDgMCGgUABBTAGi5wQ/fj8rJYShF7TJCXsxU4jAQIPGguK26/DFkCAggA`
+
+func TestDecodeBareAuthenticatedSafe(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(bareAuthSafeTestData)
+	key, cert := testIdentity(t)
+
+	if _, _, err := Decode(p12, []byte("bare")); err == nil {
+		t.Error("expected the missing ContentInfo to be rejected unless lenient")
+	}
+
+	opts := DecodeOptions{Password: passwordString("bare"), Lenient: true}
+	privateKey, certificate, err := opts.Decode(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := privateKey.(*rsa.PrivateKey); !ok || !k.Equal(key) || !certificate.Equal(cert) {
+		t.Error("expected the identity of the fixture")
+	}
+	opts.Password = passwordString("wrong")
+	if _, _, err = opts.Decode(p12); err != ErrIncorrectPassword {
+		t.Errorf("expected the MAC to be verified, got err: %v", err)
+	}
+}
+
+// bareAuthSafeTestData holds the identity of testIdentity as Encode writes
+// it for the password "bare", but with the AuthenticatedSafe SEQUENCE put
+// directly in the PFX PDU in place of the data ContentInfo wrapping it
+var bareAuthSafeTestData = `MIIJRgIBAzCCCQAwggO3BgkqhkiG9w0BBwagggOoMIIDpAIBADCCA50GCSqGSIb3DQEHATAcBgoq
+hkiG9w0BDAEGMA4ECHoEib2YoIqtAgIIAICCA3Do6/HBxxnkbVgeiXelrguScfZCf+5VWGyclGnt
+wjvgePLzpVH+JxFoG0yu9l6MjnAchq74Wwmd8CuLcMCi87tuZu8Qp9lh/yDMpjfyPGz8nvTb0uPu
+6n0y0APykdrhLufj66/JtSjbAZMqhb1E0wTHpn8At8McN5Qa7llpO9rwXSUXFRfCP7qFVDeZRDaj
+8MB+nIIpGKH+ZpMzBTMuGUgPqsiJmdE0Vh2OshO5TrZ3fvL8Cks5gtHJb7vZIw3GOV5QBM1Xx+bD
+tm8fcEsf5iAPQqjIK8cS5dOrbFC9o8FIMMv056pEuesxNfs/O4GIsmy9P+C83/Q3n+MOTLgxa7uq
+kZ5rpEoKIFHT/FoAUPRjmrBfmX7em4Itd8oFyO2+G/0X0ZQ2Tg1d60fMlCBc7gH0LCA94FJ3uf+y
+PHMkz+qtK9wU9YOvbJE79rwf6l4NF26tQZdws72x1PKmYxQybyT+mF4/MnqSqLOe0xzRlHDm0S/I
+nyEwvw7xnGotQiGdGMmHBwwd0cvCMXSuRaPZjzczg0RgqVmKV4nIS/4DRO1kO9+cTOLiEVyjar6b
+B401ZsMDJtV9g/DP97nAo0gY6XTwMl5qSchW+T68UlhoG6e4hGG+TGL5zZbt/Y0DTad2DA9GxVeP
+0fQDSAWsn0iN3WVxo9dGMDESrJVKd0cYWmywXvKOqfIvi3sMUs1IM/PdT7MjAS/oR9ydArBMZaT6
+MmNIkQ7XskMccPhM60RLMBw/9k9lqb5faXAWfbc7pKaF3Wp5nfXXJrRHlK6X6K3xfwPgJgs7y7lF
+4mge6rtjwDnezGcY00Owad5IsBR1TfkqUoIXRm8OB1dEua/ZXuV5iySilBhVwvG3i52O35ojn8Gc
+IYCM9kHGtvu9wztF5NiP2hkfregKNwnFz7OvO00s12jymJXXT+NgbZiyjhVtNCc8IHlL4U8KKigc
+eKK7TNKBaMEp1ylA7ooZzB4ii/xi5ZUaJOcoJhtBvfrDaAznTDEkjC399tFaUDrSarsAebh4oq0I
+AYqvYiIVAEB2TKebIPg5zTI16MlXj1RkgmkwIT8uP3rb8g6GH+3Fctu9pOwbNZO8IVfurvEtZyRE
+Uj1AW6Weow1lubr082mJX3zrDX8TNDp+t69RQqX/+M0LOA4fWDrBsRvidaIm4JHPIwmlkuaZMIIF
+QQYJKoZIhvcNAQcBoIIFMgSCBS4wggUqMIIFJgYLKoZIhvcNAQwKAQKgggTuMIIE6jAcBgoqhkiG
+9w0BDAEDMA4ECEldy1E/hOfHAgIIAASCBMjr5mNDosZgXqkhnKh7UFkRS360i8yxBX/gba+4u1P5
+XiCy3jkqoq6cpSOqVztZQTj5PEvb1Q/MPE3hSUMtpAgWUN4QPSWpFxImrRpYk3lMHWpVSjDgwqG0
+LIBQguAI5MLnLcYwPEvxhPq/5Fs41JwfzTC0DzXmhqpKlZiVE9mIGUCCD4Tisr7gJaCm9d63ofF8
+t4+5UguDJ3eOescjT/APQO/P6qKyC2Y4TZZdZ87sqSZgfJLQzQvZowVXAPJuOUVRw//GQu++Uh6E
+UKfR2CUEg1M2itTYvp8wSYQfOlLtZe73CmcRZxvGF1rz1mny7jqqcHjnY2fdmMXlSI/GbqCqILDY
+cv/GpLbkcZmorZDOMVCF20+YOZXMIkI8+SKk5PRWyKetO7mt4k5gmrXK6yANCzktigq5e1n/qYbL
+t17wL1cmNdwpOV37HWrARxeX5jtHU2etdgqLzp6hQyBM3vmecl25/75aTfEKYIrho9Mnp0vT47IS
+9qyhJbrWxm0H/Isr3rujnE3rTF9RvBwg1jPBqbbNHYRiID7fSa4EZJvXjw1a4R/YX3GTkeLCqGfj
+t9a9n2x4wV17tMzep0S96htxSHfvBWVksBLYtlf/14OxOf5gt2snyd4VbvrkBSG2PR15RIu2GAdX
+hX+Q1gJxXoFVUpbR7SyHMNEHv+hycMoQLopkwgWSdNll4uTDrh/6qbNTtu59BihrLwuOwMId7AfK
+4IKAiZ3swC94kOlK549vdbU/KMevb8s2O4p2naxBWMRJaELqxub5gsiKZqN/WVsfM2buErrQ6t0s
+4EqRui5TOTtSQA0y41K4VIkiRiwRxUXfGg9h/h4hw2RpJVPC2Bwyi/wTY/MwBHAa+NVscavvXISm
+3cDaAR+zohojxH5bHX46q18UydaZDXmaDNe+TeE7cq7WMZ5hN8F42cUZWoOV3+8fr5ikuz/BcfXS
+QokS6PPlFS9ljnPxPWMh1CtFTVpqu0kvlD9thjaSdoR5N2NhVNOyCQVa6WU7xSVJVmaze4QC1jPd
+tfv7Ln8ThXrokWway8U2Nhh0ot4z4u80DjyzUEmwwk3XKSJBcu68WvfWphWb40iRE1X1tmOAfUgy
+ckYJePhPjtGZFeRVpg8pr1WmUI9Th4GRtg/b4cpCXD9vx+CDOpW+G3Z765I9/e9h10btbhXD0EOT
+2z1/yMbbHTcs32DlrZdZG6hMXVX4BctCnuExvpgcUc3oXbp9bz0fBRfi7E1ICy4gvRE6zzVHFLgA
+rVDSyAv42Xfpx8vuve3l2v8wwmoyn2azh8/jHqsixeKTuGzfwhPTX4jTmMmqO+Pt3Zntyw8K4iRk
+JeYEV53ZlUwf0JYGyKMRxKKyDHG66o3lkdx3xljGbRhsY/5NtTyJB/sENKzfr+SbC6sJTIPPDhSm
+fBZnaNSiW9Y4K/fg0H13uUV2KRtqsPtzG7TZeJmHfkj70rkus0JEFtIgXXa9rM04n4RnrJ3jsUPD
+RkTYg7MHqYsYdJSdWxuk1c3cgZ6ytKnDZXXiq+seVDq4QSYLjNyi7H9XMBi8ljf2rF8LTc8ivBY8
+AUkpspth5rVIwoPzHBBD7ky62ZNkCbVIBuw3Gju3JOtLsmo4Xo5jWHWMfBFF+YL9d7+ovK4xJTAj
+BgkqhkiG9w0BCRUxFgQUdA9eVqvETX4an/c8p8SsTugkit8wPTAhMAkGBSsOAwIaBQAEFP0oqmqR
+Bjzuyxip673P1sRtzRSqBBQ8ym3PF/vRjWdeiDQKS9HolzdhdQICCAA=`
+
+func TestIsPKCS12(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(testdata["testing@example.com"])
+	_, cert := testIdentity(t)
+	signed, err := asn1.Marshal(pfxPdu{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidSignedDataContentType,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x30, 0}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version2 := append([]byte(nil), p12...)
+	version2[bytes.Index(version2, []byte{2, 1, 3})+2] = 2
+
+	for name, test := range map[string]struct {
+		data     []byte
+		expected bool
+	}{
+		"PFX":         {p12, true},
+		"signedData":  {signed, true},
+		"empty":       {nil, false},
+		"truncated":   {p12[:len(p12)-1], false},
+		"trailing":    {append(append([]byte(nil), p12...), 0), false},
+		"version 2":   {version2, false},
+		"certificate": {cert.Raw, false},
+		"PEM":         {pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), false},
+		"JKS":         {[]byte{0xfe, 0xed, 0xfe, 0xed, 0, 0, 0, 2, 0, 0, 0, 0}, false},
+	} {
+		if IsPKCS12(test.data) != test.expected {
+			t.Errorf("%s: expected IsPKCS12 to be %v", name, test.expected)
+		}
+	}
+}
+
+func TestDecodeSeparatePasswords(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// compute the MAC again with another password
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	_, authSafe, err := parsePfx(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	macPassword, _ := bmpString([]byte("mac"))
+	if pfx.MacData.Mac.Digest, err = generateMac(string(SHA1), authSafe, pfx.MacData.MacSalt, macPassword, pfx.MacData.Iterations, nil); err != nil {
+		t.Fatal(err)
+	}
+	if p12, err = asn1.Marshal(pfx); err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, certificate, err := DecodeSeparatePasswords(p12, "mac", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := privateKey.(*rsa.PrivateKey); !ok || !k.Equal(key) || !certificate.Equal(cert) {
+		t.Error("expected the encoded identity")
+	}
+
+	if _, _, err = DecodeSeparatePasswords(p12, "content", "content"); err != ErrIncorrectPassword {
+		t.Errorf("expected the MAC not to verify with the content password, got err: %v", err)
+	}
+	if _, _, err = DecodeSeparatePasswords(p12, "mac", "mac"); err == nil {
+		t.Error("expected the contents not to decrypt with the MAC password")
+	}
+	for _, password := range []string{"mac", "content"} {
+		if _, _, err = Decode(p12, []byte(password)); err == nil {
+			t.Errorf("expected Decode with the single password %q to fail", password)
+		}
+	}
+}
+
+func TestDecodeMaxInputSize(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "size")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		maxInputSize int
+		err          error
+	}{{0, nil}, {len(p12), nil}, {len(p12) - 1, ErrInputTooLarge}} {
+		opts := DecodeOptions{Password: passwordString("size"), MaxInputSize: test.maxInputSize}
+		if _, _, err := opts.Decode(p12); err != test.err {
+			t.Errorf("MaxInputSize %d: expected err %v, got: %v", test.maxInputSize, test.err, err)
+		}
+		if _, _, err := opts.DecodeReader(bytes.NewReader(p12)); err != test.err {
+			t.Errorf("MaxInputSize %d: expected err %v from the reader, got: %v", test.maxInputSize, test.err, err)
+		}
+	}
+
+	if _, _, err = DecodeReader(bytes.NewReader(p12), "size"); err != nil {
+		t.Error(err)
+	}
+	// the read is bounded, so an endless input fails rather than hangs
+	opts := DecodeOptions{Password: passwordString("size"), MaxInputSize: 1 << 10}
+	if _, _, err = opts.DecodeReader(endlessReader{}); err != ErrInputTooLarge {
+		t.Errorf("expected an endless input to be too large, got err: %v", err)
+	}
+}
+
+// endlessReader reads zeroes without end.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestDecodeSkipMACVerification(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	pfx.MacData.Mac.Digest[0] ^= 0xff
+	corrupted, err := asn1.Marshal(pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metadata Metadata
+	opts := DecodeOptions{Password: passwordString("skip"), Metadata: &metadata}
+	if _, _, err = opts.Decode(corrupted); err != ErrIncorrectPassword {
+		t.Errorf("expected incorrect password, got err: %v", err)
+	}
+	if _, _, err = opts.Decode(p12); err != nil || !metadata.MACVerified {
+		t.Errorf("expected the MAC to be verified, got err: %v", err)
+	}
+
+	opts.SkipMACVerification = true
+	k, c, err := opts.Decode(corrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.MACVerified {
+		t.Error("expected metadata to report the MAC as not verified")
+	}
+	if !key.Equal(k) || !c.Equal(cert) {
+		t.Error("expected the test identity to be decoded")
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	key, cert := testIdentity(t)
+	p12, err := Encode(key, cert, nil, "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(p12, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	pfx.Version = 4
+	v4, err := asn1.Marshal(pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metadata Metadata
+	opts := DecodeOptions{Password: passwordString("version"), Metadata: &metadata}
+	if _, _, err = opts.Decode(p12); err != nil || metadata.Version != 3 {
+		t.Errorf("expected version 3, got %d, err: %v", metadata.Version, err)
+	}
+	_, _, err = opts.Decode(v4)
+	if _, ok := err.(NotImplementedError); !ok || !strings.Contains(err.Error(), "version 4") {
+		t.Errorf("expected version 4 to be unsupported, got err: %v", err)
+	}
+
+	opts.AnyVersion = true
+	if _, _, err = opts.Decode(v4); err != nil || metadata.Version != 4 {
+		t.Errorf("expected version 4, got %d, err: %v", metadata.Version, err)
+	}
+}
+
+func TestDecodeMixedAlgorithms(t *testing.T) {
+	// each bag is decrypted with the algorithm of its own AlgorithmIdentifier
+	for name, data := range map[string]string{
+		"AES-256 key, RC2 certificates":  mixedAlgorithmsTestData,
+		"3DES key, AES-128 certificates": mixedAlgorithmsReversedTestData,
+	} {
+		p12, _ := base64.StdEncoding.DecodeString(data)
+		key, cert, err := Decode(p12, []byte("mixed"))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, ok := key.(*rsa.PrivateKey); !ok {
+			t.Errorf("%s: expected an RSA key, found %T", name, key)
+		}
+		if cert.Subject.CommonName != "fixture" {
+			t.Errorf("%s: expected the fixture certificate, found '%s'", name, cert.Subject.CommonName)
+		}
+	}
+}
+
+// mixedAlgorithmsTestData was created with
+// openssl pkcs12 -export -keypbe AES-256-CBC -certpbe PBE-SHA1-RC2-40
+var mixedAlgorithmsTestData = `MIIGRAIBAzCCBfoGCSqGSIb3DQEHAaCCBesEggXnMIIF4zCCAqcGCSqGSIb3DQEHBqCCApgwggKU
+AgEAMIICjQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQYwDgQIQxtGCb+iXC4CAggAgIICYMU5JeEG
+CXGm8HwIp7rwABnOzYoGV8cSjJsjXlQNYAzowL8yMjZbvNI5mHmrBowuFSjDEAU58nNFv1hVKBSX
+pCUZM9aFvNg6RCuo6m6J5/pOwHqSeFDanHdB0lY//7BeTdkqknDn7U1JJGwsKf/G9C7vLpyJ4rwR
+7FTthPLYmrZC0EADqSFFdKQMpd1tC+8WMBS+VLwEdEaXIIKh9wwrXvYcAyYNG6uK1G/aS3Moc0f6
+MCVxDFOEtdbD27ugsKddNA9QUHCOjVhlTzIoVTJXA5UIEUYgWm4U4tOn7rH80JsRakGltirdx9KR
+iYMC36FbMSukO+I/0746bRcbL2RYAfVGDHLQV4xutCK6XHy4YLqIlIrYXBJK0mclkPKNd1+f6ua5
+U5EL5rzpde8Ot3jUm1YwKrHxPWN0VVcoKqZI//w2vWVvQHvvvjxiIX3SAG2xfhQXc7fGysdPBO7J
+nFsgL25CbSQBDYsP6B4wb1jjvkRUVEdv4hYtVZFOWuIyZUFOwOXopJD9TJQQ4sBf5qb6gMljUfZH
+eOOuKx9KDVaN9ll9vsg8QZtWxSV6aJwzzJa83EC6ZzX0lo55wMy7VwaHtwhf2+lK/LXhY3I1QSkd
+uFUAyg69i4svmkCQAh+3Ae228iaHAuJwaVNNmBHSlwPvaZ9jgiD8g6iKSMuU5FUJymrAH6v2ATuu
+t2WdAytne1EXVhQhfKVkx75g9mygljH9HiwB0/cZ4jvgZj7hsrsj4f4/pIDkOZyPd8OoRytxCOhs
+QefWcBZagcVeus6+zUHa9i3JuX+GpFsSNK8PLUZD+wGeMIIDNAYJKoZIhvcNAQcBoIIDJQSCAyEw
+ggMdMIIDGQYLKoZIhvcNAQwKAQKgggLhMIIC3TBXBgkqhkiG9w0BBQ0wSjApBgkqhkiG9w0BBQww
+HAQImecW+PIDqfICAggAMAwGCCqGSIb3DQIJBQAwHQYJYIZIAWUDBAEqBBB+uXZ8inc/NUFoIxah
+AAnvBIICgN8lj3dciydVe9v1BMHNxgyqedE1W9JqdXpdHF0ijvOU0zr2c5opYSFlyY+nC/Hch4vz
+0YaZR+faW5s0ybrJSQaYTr5/QgamhjJCdw6bU17C3xOwOzTaH0oi71asAd5YARhZhc4Zp89gBS30
+cfuy7xC0lIJvHcBj65/AKowcvEI6LYb1mvfSAOjHRoJ9LXtCFdudEw+TZeWn9aJDV2/eSPhO0cY2
+FllT27rscR6kMvUnhAlMn4ug7vpRvcwS39d27/+ay2w+arLltVZ6EuB0INupEnXOHJ1al5uu6g8s
+4sl6vFgEZ5mqdn/0Avgk4F5+K9MuiMJg5YcwlWBJi5Qwvg+Cp3CboZfrSrZDpOhOLIXzoTqcrDqi
+7VtiXITYK8N16jfER0Yo6MyRbvtKK1M0D/Qj8YTMkVch7biXQ6hawNsChZkfq7uozA7X/Y0AI2+a
+P1mzXs9jvybLNhsgoM13yq3lCoscdiwIBcAaPjKRpJNSav+fbtkgArVz/h0X4HMS3SbGQQnrs0X/
+t9FtW/lkVuS4KMxhIa91tzCwwBkB7A17BOJtn0DEBIUew6IBffkX7S7CKLd86GBZ03+v46xocF8A
+epOUE7TZqXbQefoYsQlWH7OIkwqfA8g2C6GPDoa6U3PzfvxDK3t5l0iPgSRWiPxi+Zdw5CBTRNmv
+vR+cq/a8mLkZVZniq3GfC2nh8j7DYSXvffigfBbF8btp80P9GmbP/tWlSVEu4UL5SeQaAwvWK8NZ
+xLKx+iGEKlP3fD3mRs8sKfjHJzuaGJ/jzXfSGjtb8BFDM2Xa9mKl09c8H2d9VSCq3oOa7/OzlAnf
+qxGZJYqf/uHMWoEnsoRK5RBLdnYxJTAjBgkqhkiG9w0BCRUxFgQUI1A8DVNtgUaCMycwB+n02+Qq
+UgswQTAxMA0GCWCGSAFlAwQCAQUABCC475yVlXueuVemugrp0R2ihfh7ExLF4atBKRIoNqqyMAQI
+l08YyR3KTTYCAggA`
+
+// mixedAlgorithmsReversedTestData was created with
+// openssl pkcs12 -export -keypbe PBE-SHA1-3DES -certpbe AES-128-CBC
+var mixedAlgorithmsReversedTestData = `MIIGRAIBAzCCBfoGCSqGSIb3DQEHAaCCBesEggXnMIIF4zCCAuIGCSqGSIb3DQEHBqCCAtMwggLP
+AgEAMIICyAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAjhV9JsJaMr
+vAICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEAQIEEBSIzfQgISB0FLBLuudpOwCAggJgrcHP
+1yV56dIORUjnLkF6q+tKormI78mNC61JME5P4jfdoxMdMUnMI7g2TikXX97H10AI0UYC5h6ubWR7
++9c1ZVMM7UrLyVwGNScikoXSmWLUsA5wHZPcUmGpqZIyIV7J7bv6q7wyOfGjh3ou3/km/l0MITHh
+msG8rcAbTIG14IQag5peTItg8BMB5d8gJAljwVar4zCLY8XKFzupt03cpp4+0hRglJdA+6EkYBIo
+sdxdOMZFUiDYQwjw6sD3fKpYKHSe0yyDWfglfL7D3zXwb9TLy5Dqt5YJAim7d/kPs4Nwcr93DGUA
+GpfalClQoKIFH31Y6v8sAGMN85lCF60uclR/vByM1tFBeZEiUw8FGgFvBs+8lPdK8cWrU2iw8V/A
+PboqC08JCb25iSMN7xDMGSISJPDEu+Y7zhYn6NMnKz38flDeiV8rOZEfGhORklNBmzYMSR4TEOll
+ERJhOxdiDNorB9TNvDl0QiaG0rKSGRSKsNY6mpnxYr7RXu6zYNAmGTktufjVV9kLR2budZsO+8xU
+tFU/eZyWzxkgIGpQoVYIN81zZvA5HVgornsQi4FvcCz/ZgtQXICPdtt01dG9QPpivYN4r0u0QBd9
+PdRCraPpaJpL9+aetzfQgS4Y20z5NG7TDXzkYdYSUQnntjUnfiPokRQFGTq+8ZwBP/UWPuj0Ta8s
+sNmPqxoVsBp6nYhK5YB50HahgmYwmeUW4LTvt09VGnZcjQAERAzrG7lGi5r1v36txClAseH0WP5I
+Xw90hz3OG6s76njhRWIYDLpp7tHwX6pMAIV28qyRuz8If28wggL5BgkqhkiG9w0BBwGgggLqBIIC
+5jCCAuIwggLeBgsqhkiG9w0BDAoBAqCCAqYwggKiMBwGCiqGSIb3DQEMAQMwDgQIP695ETWJltsC
+AggABIICgHHNEWYEswoJxy7xDmABdv7rf6RtmlVhWodGkf/lLihIgbS0aPWMJbFNXcNsS+oCzN6R
+UYgcnepzacbjZZ+FIPMKJ7O+UlUlwTRss+edxgeEPeLsl7zMwkI+cKIl3fK5TddSIlcl/6OXktqI
+yJLN3y3zK78N29YTSecSbiiHdC369nD3bH7kXafUZbUCnjj2GPyQEYJwUKM++WE9PyhQW+0XRB7X
+NOezqYUACW7a0bgeLIPOhIpbyYkuT64yRToXZ2Hq4YZQuiswaULGlT1KVeR84uftzMgRJKyt0UuU
+CL5jj+iYC69jRz18zeqa8jtLjZeFSWCM2x0nkamzae5HrLfvVRLVHc0Pb/0+jronO2Wtdd6zgzal
+PBOnI4nrBeYQfzRsAovsZlMqBtRjiJAVQeQX5dQwunbNPsm8Yf31DqMyP+96EVgw7xgFW8X4AKF3
+FBSNK61SHTQ6xOwwKCFHP3IQWffczNokN5lR/KKkZJF16YuZTaToIc+3lSLfa/3R949EWExcPp69
+mguP2HtnNdiS18DVdiq2zlRdWiR36saHa76n1DKoAP5xqX3Hn9ykMBuScNK4kXtEXI/xpxuFuP1a
+Dsgs8sjhsV2kPyRVzqjBHhmMClicbVo9mC5quemOKE7dk3lH2MRtnMpyJKCCwUcX0Pk9FSMiTl8a
+WNanpDvJkusvZQpnb+E2LFrh/7t0fcSUhaUQzXwQwn2CcepY4AO91raVhS/V5YUVDxzSXxi/azII
+EL4Za/LWE5bc51aN9Q1jyiSqUtIpiLG+njVkW1O+WL40UlG/rRAoYtP5Ha/Vj1gDttQZ60dWqTMO
+Wkfta2Eb4R6rTF6m3XgqCT+dmFsxJTAjBgkqhkiG9w0BCRUxFgQUI1A8DVNtgUaCMycwB+n02+Qq
+UgswQTAxMA0GCWCGSAFlAwQCAQUABCDTT71r0aXi9TW2umf6BzgsnpUSvxFxFEYlhOosz6BZbAQI
+ff0WpGP1u/oCAggA`
+
+func TestDecodeDESCert(t *testing.T) {
+	for name, data := range map[string]string{
+		"OpenSSL 1.1": desCertTestData,
+		"OpenSSL 3":   desCertOpenSSL3TestData,
+	} {
+		p12, _ := base64.StdEncoding.DecodeString(data)
+		entries, err := DecodeAll(p12, "descert")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(entries) != 1 || entries[0].PrivateKey == nil || entries[0].Certificate.Subject.CommonName != "fixture" {
+			t.Errorf("%s: expected the fixture identity, found %d entries", name, len(entries))
+		}
+	}
+}
+
+func TestDecodeDoublyWrappedCertificate(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the certificate DER is in an OCTET STRING inside the certValue OCTET
+	// STRING, as some producers write it
+	wrapped, err := asn1.Marshal(cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := NewEncryptedContentInfoBuilder(PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000)
+	certs.AddCertificate(wrapped, NewLocalKeyIDAttribute([]byte{1}))
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, NewLocalKeyIDAttribute([]byte{1}))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(certs)
+	pfx.Add(keys)
+	p12, err := pfx.Build([]byte("wrapped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, decoded, err := Decode(p12, []byte("wrapped")); err != nil || !decoded.Equal(cert) {
+		t.Errorf("Decode: expected the certificate, got err: %v", err)
+	}
+	entries, err := DecodeAll(p12, "wrapped")
+	if err != nil || len(entries) != 1 || !entries[0].Certificate.Equal(cert) {
+		t.Errorf("DecodeAll: expected the certificate, got err: %v", err)
+	}
+	blocks, err := ConvertToPEM(p12, []byte("wrapped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		if block.Type == CertificateType && !bytes.Equal(block.Bytes, cert.Raw) {
+			t.Errorf("ConvertToPEM: expected the certificate DER, found %x", block.Bytes)
+		}
+	}
+}
+
+// desCertTestData was created with
+// openssl pkcs12 -export -descert -keypbe PBE-SHA1-3DES -macalg sha1
+// which encrypts the certificates with 3DES rather than 40-bit RC2, and is
+// the shape of openssl pkcs12 -export -descert output before OpenSSL 3
+var desCertTestData = `MIIF+QIBAzCCBb8GCSqGSIb3DQEHAaCCBbAEggWsMIIFqDCCAqcGCSqGSIb3DQEHBqCCApgwggKU
+AgEAMIICjQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQIFUdq73rVESUCAggAgIICYC7FQqGu
+vT2MIizsT/mpUKCYjaMZQJG/rCdRIpS7CGFwNwLKSI06cNEDhNuQxl0xHtgemT6D5SP1Dr4hkJo6
+Q21D+C/OkbS8GYfvTvS3laETUaesV+vNwWQ65M8LhJ+aXULb0sf9DxR7jYO/Lut6k1kOHW2UIz9y
+pr1oMslHee/HHOdwQkKcxU+ykKcEE1/wbfV5pruaKAWzYUNpbIwjr6GuIh5jXdjKaM1+W1/fCt1R
+fBMnVz5RUcuABeHu6H0RzTecbeJiZd0+Hb9zFKTb4mGg8YugI8Iw9voWVsx7ULsc4STTkYgoDWf1
+SnYVhusa8nmXWCSwIr805IJfKjfJMshmV0+s5iB+TUnuKvaJNQHIH5NB/U4mhR+lSvcDVLl2CIR7
+FTdtxyhHTU0/1slzXZMECkRhU/4+1v2QRWsdTh4FGTcJy/83gc3b4YWJLn2adgr8PECSJob7n2Hs
+uZbaZTcBipBKSzg9JLJHS912InvNpSvmtq9esNuegWdYXutUzh4X4RAKr2t5OlPMaIwg1U9nJxXv
+YHKpODZC7Jc9K//nSEkDJBh2Vhn6QjtT4RCxPDOT2g2DPem/gnA2bU9ihjhji+iFK+QCtJx7fuLk
+hriscLd1g1W+8aKEmSbGb3x5z3gIbAzsgZeL1qtf7LrTqjCueK12r+hLl881U3u9fubmKkZsYS5y
++Gwh0+UcqPicLVwamGvrSJjmQEzgApPwnGGj6kDsBkv6pOu8Q/4zrf0AH+x9KESc4PLh3KV9P11+
+C6WBeOw2c5bY4ycswJpRLyGfpJ81b0tKqDLp+YNyzgr/MIIC+QYJKoZIhvcNAQcBoIIC6gSCAuYw
+ggLiMIIC3gYLKoZIhvcNAQwKAQKgggKmMIICojAcBgoqhkiG9w0BDAEDMA4ECE4e29GxjcqYAgII
+AASCAoA5jDh9w+bnjui0h+GSZ8kl1E4AnekcEOjtmEDo8sZobt+T/xH2BcvSSHP/Cb2vF2tjXNzf
+wxwq2TU0OT4P66t2OaqJlAnz79NMt5nC1PrfW6GNGIv/dLhjWH+PYabdCabQLBa60jyqv6xnNogD
+keLq0V8JLq6oK3RXseMe7Rko+8J/zZurqp+cqHlSsOPBjzEjP/iE3wdqhyyYuSDusOMTHJHyD9sl
+REGLN+aaThMeEBR1+Z++7kA3SHL+r8HKWQCKJq+LoEOQ6wKfQokf12ShLIWz3c0RY0DqcHyQ/W1+
+HU0ijvIaBB53p7/FVpI4lDOuOdW2aVXY+IRcaZ3azf5CYAJXi91kx1aZlqZ0YRhfQq6z1Grcb13T
+fETMm61sDiHf56aEJ2/tGF9AX/U1u3USYJsm26PIleqS/fsQOMwnTDM4/don+ED3RTJsSu/S0Ncq
+h9QT2+IeICceZAx/6zQGVzJ6dHB+V88FW05Zite5SlWevOdY+A1F9GAOT74lqQ9JhQcMMcgbPH8R
+rfMAcSdYSXKXL1urxbSd67TlsmJDP0vPQS4lH7nGDe2huFnzJBnDO9V9VkPLo8xp1TmwcvRQPc+x
+hBlfTP6HI0YvSB5xhutRHLw6cAJDy/yxo1leCCfHIFjmarMiId3I+qnLrD4tRx692eFZcXz/bC7r
+bZVaWXiap/CkTg2h8fAZe8pyazMSoXe5OkCE6w62tZlaAOHuUMoGln4Hum1UhubF+EYihkYzysCo
+RNSB1xUBZnuPWRRARrOkC6DfqLG+3hlpb08IOm8zgXT8ajW9goROyOJwLdglLeYA3TqFb5uco8Sk
+ZNLBuP0soRODuRJMbEYiR+vYMSUwIwYJKoZIhvcNAQkVMRYEFCNQPA1TbYFGgjMnMAfp9NvkKlIL
+MDEwITAJBgUrDgMCGgUABBTMF2IVbQq3OZ7X5DQYLieEwdHF0gQIgV7Eu5ZiK2wCAggA`
+
+// desCertOpenSSL3TestData was created with
+// openssl pkcs12 -export -descert
+// using OpenSSL 3, which shrouds the key with PBES2 and AES-256
+var desCertOpenSSL3TestData = `MIIGRAIBAzCCBfoGCSqGSIb3DQEHAaCCBesEggXnMIIF4zCCAqcGCSqGSIb3DQEHBqCCApgwggKU
+AgEAMIICjQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQIek0pVS8n8IkCAggAgIICYPJv/KaU
+SsrlY01a5oufJEvvgfxQXJ5Z33YfL+KwH/t99dFAvnE3malDIZQA/2prxpeP1+EgumKwPSi62t44
+7EbOn39nV9SLoMI7hckOI4l6aJRschQCg24AD0deduxYAERZRvSc7/v6fT6COyClgL1vpIBuPZG7
+LIcaPO6XnE5e5sEkV0QczQhi7u0juEa2hqGvN1wqWPGtdfo9ov2rgytKiUijuppoQVvUR7XIFgRd
+nLilohEChSQC4lPfleyFrplQB78WtowoM7D6/gV7Y5kx+/yOlMZsoKv2PzB/Zmzjut4+cgecx7A8
+54euWxPhqm4eM9IwDLNp8obaKADXyQ2AwST7UvEHwQxKV1udIv81BLGQMZtGLDKEHRYO2QZGYQH6
+eKVlk6Iw31B14l9UPZMnl/96d9PtdPGKcVbFNwLhfCsoVaCPMEOmnsPpP5HL/xupAVJ5bVNUv/kE
+UhjcG28RN0MNMKQBPyh7OwMZGM1SzpD5fkFXILfcB/KnX0XiiqzsjJB4TbgNU650n5sUU2kyaBjR
+Nzgdv2dKlsOuWf/HJVxy6wlp2ERE9JjXaXPS0u1uUQkQ0yQyKVUwvHbeV60sam6IY4+ZaIRLGo5i
+DKc6rFdqz4Z6HJwOpAqwdwW9KBumKT9+nWO4wEoUnXOU9djGKcS66+3q48yc6dL84pdSYquJyxTR
+RexixZGmz5/zOI1gjfjiwf0fmR/4TTM0ASjJClvHlbgSqwysh6Y9Ze8iYPVVyhWiRCbhtLRqin++
+rd1aSlSS26xoizxMWIi7LXfgNziAeS0uZm5ltgiwDc4EMIIDNAYJKoZIhvcNAQcBoIIDJQSCAyEw
+ggMdMIIDGQYLKoZIhvcNAQwKAQKgggLhMIIC3TBXBgkqhkiG9w0BBQ0wSjApBgkqhkiG9w0BBQww
+HAQIJmwiCQ0/G5oCAggAMAwGCCqGSIb3DQIJBQAwHQYJYIZIAWUDBAEqBBC93uPqI5K/IKj2M6tn
+qggCBIICgKqPhyIePKqiYpbrRUJLCik4R7l0IHMmQuvxREDb6/92oMnKir8wrnln0Hdxv+x/QjN9
+RUpeK34yTiaJ37w9Q5p/yAmbDQz+4oYF5ChibT83JbL/YORvfafDhteDYfHWc6WFqanrvo+1yJFG
+ey4fiukZAStcMWhVsge7b7iU7TiWkcjHtYKXfKE9X8tdttcOXMBnmhgWbP/++B6Lf/L9B3kZAV9V
+rx9/85+VHYpF2hSfrGpulec+ZuW1miSiXM4qidi4WJFeZK37sybiJdiSry9hE37Wv64LhmFZVpZz
+NNIiT4QgNNUQm2xMhRJJnom0QRVRRz6Hlza+h43C6XzingYUaOWiCo8FMbLskkdEzbIjXNFUg1Ov
+IqdUPR3cyGIivzogvU/SpuN/c0AA0lpTz1m0llRoQ3P+od1w+S7MrV3URmbzkRabheIBcIIRvApE
+xH9WW33To6KvUsoYzUKVoV9iOLoDHfp2Xs7BIjjGdob7vWjfAJkmfN7v186Q0xRuAw+i+6URzHK0
+tI68AIKjx7Zw81sh1UMXqwltEfgugmrWjF6sZLWLTfQpYNzkwXkTAhCnIHMUNMksbg+0HkMoM4IB
+YZuNPrD36WWsSuk99fTUWFUrTWPBo/v32TSonFRD7TFjbs/H+kktgY9/5X6Ms5YQnUbylGKaUZbF
+XMQfJarXawoIWdT9foxHS2uQ48uYqdCufZzcPBNd9SdNWIjJW1F+xhunS+QkQ+CUHKOpE6ANDx7p
+R6Pt/TnmeGjaAfygvAgjU7it/W/17ZNeWlXLGCFpD4u3TMq/lKR92BjOPF//W1pT/lEIoeDWqtpz
+C2fap/yZQ4oMAYtv8k9B/xyIH9oxJTAjBgkqhkiG9w0BCRUxFgQUI1A8DVNtgUaCMycwB+n02+Qq
+UgswQTAxMA0GCWCGSAFlAwQCAQUABCCG/ZaXl1skZlSDArMqvO1q4HbZz3WWx08HltOE53HV9gQI
+YrN9UZaFmqwCAggA`
@@ -0,0 +1,114 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"io"
+)
+
+// EncryptedPKCS8Key is the DER encoding of a PKCS#8 EncryptedPrivateKeyInfo,
+// such as EncryptPKCS8 returns, that Encode accepts as the private key to
+// store it without decrypting it.
+type EncryptedPKCS8Key []byte
+
+// DecryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo, as held by an
+// ENCRYPTED PRIVATE KEY PEM block, with password. See
+// DecodeOptions.DecryptPKCS8.
+func DecryptPKCS8(encrypted []byte, password string) (crypto.PrivateKey, error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.DecryptPKCS8(encrypted)
+}
+
+// DecryptPKCS8 decrypts the DER encoded PKCS#8 EncryptedPrivateKeyInfo
+// encrypted and returns the private key it holds. Any of the algorithms of a
+// pkcs8ShroudedKeyBag may be used, subject to opts.AllowedAlgorithms, and the
+// password, obtained from opts.Password, is used as a BMPString as in PFX
+// data. ErrDecryption is returned if the password is incorrect.
+func (opts *DecodeOptions) DecryptPKCS8(encrypted []byte) (crypto.PrivateKey, error) {
+	info := new(encryptedPrivateKeyInfo)
+	if rest, err := asn1.Unmarshal(encrypted, info); err != nil {
+		return nil, errors.New("pkcs12: error decoding encrypted private key: " + err.Error())
+	} else if len(rest) != 0 {
+		return nil, errors.New("pkcs12: trailing data after encrypted private key")
+	}
+
+	passwords, _, _, err := opts.passwords()
+	defer func() { // clear out BMP versions of the password before we return
+		wipe(nil, passwords)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, password := range passwords {
+		var der []byte
+		if der, err = opts.pbDecrypt(info, password); err != nil {
+			continue
+		}
+		var privateKey interface{}
+		privateKey, err = parsePKCS8PrivateKey(der)
+		wipe(der, nil)
+		if err == nil {
+			return privateKey, nil
+		}
+		if _, ok := err.(NotImplementedError); ok {
+			return nil, err
+		}
+		// an incorrect password may still leave valid padding
+		err = ErrDecryption
+	}
+	return nil, err
+}
+
+// EncryptPKCS8 encrypts privateKey as a PKCS#8 EncryptedPrivateKeyInfo with
+// password. See Encoder.EncryptPKCS8.
+func EncryptPKCS8(privateKey crypto.PrivateKey, password string, opts ...EncodeOption) ([]byte, error) {
+	return NewEncoder(opts...).EncryptPKCS8(privateKey, password)
+}
+
+// EncryptPKCS8 returns the DER encoding of a PKCS#8 EncryptedPrivateKeyInfo
+// holding privateKey encrypted with password, as the private key of a PFX
+// would be shrouded by enc: with the key algorithm, iterations and salt
+// generator of enc. The password is used as a BMPString, so the result is
+// read by DecryptPKCS8 and by OpenSSL with the same password.
+func (enc *Encoder) EncryptPKCS8(privateKey crypto.PrivateKey, password string) ([]byte, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { // clear out the unencrypted private key before we return
+		wipe(pkcs8, nil)
+	}()
+
+	salt, err := enc.salt(defaultSaltLength)
+	if err != nil {
+		return nil, err
+	}
+	return encryptPKCS8(pkcs8, enc.keyAlgorithm, salt, enc.iterationsFor(enc.keyAlgorithm), password, enc.rand)
+}
+
+// encryptPKCS8 returns the DER encoding of an EncryptedPrivateKeyInfo holding
+// the PKCS#8 private key encrypted with algorithm and password. If salt is
+// nil, a random salt is generated.
+func encryptPKCS8(pkcs8 []byte, algorithm EncryptionAlgorithm, salt []byte, iterations int, password string, random io.Reader) ([]byte, error) {
+	bmpPassword, err := bmpString([]byte(password))
+	defer func() { // clear out BMP version of the password before we return
+		wipe(bmpPassword, nil)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	algorithmItem, encrypted, err := encryptWith(algorithm, salt, iterations, pkcs8, bmpPassword, random, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := AsnSequence()
+	info.append(algorithmItem)
+	info.append(AsnOctetString(encrypted))
+	der := make([]byte, info.size())
+	info.write(der)
+	return der, nil
+}
@@ -0,0 +1,75 @@
+package pkcs12
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncryptPKCS8(t *testing.T) {
+	privateKey, _ := testIdentity(t)
+	for _, algorithm := range []EncryptionAlgorithm{
+		PBEWithSHAAnd3KeyTripleDESCBC,
+		PBEWithSHAAnd40BitRC2CBC,
+		PBES2AES128CBC,
+		PBES2AES256CBC,
+	} {
+		encrypted, err := EncryptPKCS8(privateKey, "pkcs8", WithKeyAlgorithm(algorithm))
+		if err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+			continue
+		}
+		key, err := DecryptPKCS8(encrypted, "pkcs8")
+		if err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+			continue
+		}
+		if k, ok := key.(*rsa.PrivateKey); !ok || !k.Equal(privateKey) {
+			t.Errorf("%s: expected the encrypted private key back", algorithm)
+		}
+		if _, err = DecryptPKCS8(encrypted, "wrong"); err == nil {
+			t.Errorf("%s: expected an incorrect password to fail", algorithm)
+		}
+	}
+
+	if _, err := EncryptPKCS8(privateKey, "pkcs8", WithKeyAlgorithm("unknown")); err == nil {
+		t.Error("expected an unknown algorithm to fail")
+	}
+}
+
+func TestDecryptPKCS8OpenSSL(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	for _, data := range []string{pkcs8PBES2TestData, pkcs8PBES1TestData} {
+		der, _ := base64.StdEncoding.DecodeString(data)
+		key, err := DecryptPKCS8(der, "pkcs8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			t.Fatalf("expected an ECDSA private key, got %T", key)
+		}
+		keys = append(keys, k)
+	}
+	if !keys[0].Equal(keys[1]) {
+		t.Error("expected both encryptions to hold the same private key")
+	}
+}
+
+// pkcs8PBES2TestData and pkcs8PBES1TestData hold the same P-256 key, and were
+// created with
+// openssl pkcs8 -topk8 -v2 aes-256-cbc -passout pass:pkcs8 -outform DER
+// openssl pkcs8 -topk8 -v1 PBE-SHA1-3DES -passout pass:pkcs8 -outform DER
+var (
+	pkcs8PBES2TestData = `MIHsMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAgraajcBS/ZdQICCAAwDAYIKoZIhvcN
+AgkFADAdBglghkgBZQMEASoEEEos3tqfkA7GCoPTfPmC+PMEgZDBAKHSBqYr3sVXZGipT86Hu0GC
+oVFqq3ueIjGReMRCg+ficmkaxbgayul2ocSLOO8YSbQEw2CkME8cA8O3jkHUYbpWJMJcJNSaaopA
+ftfsz3fBlRN1ChgC+8Xmpx4hKJSI97Bh7yIkZm9Gi99l5zDeXgRkiOlpQiICJuJfEvGXthdcQoTL
++VLFy6Tk86WcFZ0=`
+
+	pkcs8PBES1TestData = `MIGxMBwGCiqGSIb3DQEMAQMwDgQIy/a0CI0UdtACAggABIGQ06EwAnyqlDtF0Kr5zXo/Ls+f5keq
+FnN7yqYGxWC9QA+PuZOjUqTXHCb1ca+saFqLeWmbkR+a8oGxYeLKQrIMIvXYDRB61DhXkH/0aIpq
+8rqHQfV+80EY2JMt53mML93hYfOT8G/LVn0JWFrIIM5yXIRpj31G7KxXKpo6mKNMwWUND3Z9FDVb
+d5bShJBxVEwd`
+)
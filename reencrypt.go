@@ -0,0 +1,93 @@
+package pkcs12
+
+import "encoding/asn1"
+
+// ReEncrypt decrypts pfxData with password and encrypts it again with the
+// algorithms set by opts, keeping the password. See Encoder.ReEncrypt.
+func ReEncrypt(pfxData []byte, password string, opts ...EncodeOption) ([]byte, error) {
+	return NewEncoder(opts...).ReEncrypt(pfxData, password)
+}
+
+// ReEncrypt decrypts pfxData with password and encrypts it again with the
+// algorithms of enc, keeping the password. All bags and their attributes are
+// preserved in the ContentInfos they were found in: those that were encrypted
+// are encrypted again with the certificate algorithm, or with the key
+// algorithm if they hold private keys, and shrouded private keys with the key
+// algorithm. All other bags are copied byte for byte. Salts
+// are regenerated and the MAC is computed anew. The number, order and kind of
+// the ContentInfos are kept as they were, whatever they are, rather than
+// rearranged as by Canonicalize.
+func (enc *Encoder) ReEncrypt(pfxData []byte, password string) ([]byte, error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	authSafe, p, err := opts.authenticate(pfxData)
+	var secrets [][]byte
+	defer func() { // clear out the password and everything decrypted with it
+		wipe(p, secrets)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	var authenticatedSafe []contentInfo
+	if _, err = asn1.Unmarshal(authSafe, &authenticatedSafe); err != nil {
+		return nil, err
+	}
+
+	pfx, err := enc.newPFXBuilder()
+	if err != nil {
+		return nil, err
+	}
+	maxBags := opts.maxBags()
+	for i, ci := range authenticatedSafe {
+		bags, decrypted, err := opts.decryptContentInfo(ci, i, p, maxBags)
+		if decrypted != nil {
+			secrets = append(secrets, decrypted)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		maxBags -= len(bags)
+
+		contentInfo := enc.newContentInfoBuilder()
+		if decrypted != nil {
+			algorithm := enc.certAlgorithm
+			for _, bag := range bags {
+				if isKeyBag(bag.ID) {
+					// such as the keyBags of EncryptedKeyBag, which are
+					// only protected by this encryption
+					algorithm = enc.keyAlgorithm
+					break
+				}
+			}
+			if contentInfo, err = enc.newEncryptedContentInfoBuilder(algorithm); err != nil {
+				return nil, err
+			}
+		}
+		for _, bag := range bags {
+			if !bag.ID.Equal(oidPkcs8ShroudedKeyBagType) {
+				contentInfo.addRawBag(bag.Raw)
+				continue
+			}
+
+			attributes := make([]Attribute, len(bag.Attributes))
+			for i, attribute := range bag.Attributes {
+				attributes[i] = Attribute{ID: attribute.ID, Value: attribute.Value}
+			}
+
+			pkcs8, err := opts.decryptPkcs8ShroudedKeyBag(bag.Value.Bytes, p)
+			if err != nil {
+				return nil, bag.wrapError(err)
+			}
+			secrets = append(secrets, pkcs8)
+			if err = enc.addShroudedKey(contentInfo, pkcs8, attributes); err != nil {
+				return nil, err
+			}
+		}
+		pfx.Add(contentInfo)
+	}
+
+	// build with the BMP password that verified the MAC, so that how it was
+	// NULL terminated is kept too
+	return pfx.build(p)
+}
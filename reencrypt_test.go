@@ -0,0 +1,214 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"testing"
+)
+
+func TestReEncrypt(t *testing.T) {
+	legacy := buildTestPFX(t, "migrate")
+
+	p12, err := ReEncrypt(legacy, "migrate", WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES256CBC), WithMacAlgorithm(SHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfx, authSafe, err := parsePfx(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pfx.MacData.Mac.Algorithm.Algorithm.Equal(oidSha256Algorithm) {
+		t.Errorf("expected a SHA-256 MAC, found %s", pfx.MacData.Mac.Algorithm.Algorithm)
+	}
+	p, _ := bmpString([]byte("migrate"))
+	bags, _, err := new(DecodeOptions).decryptAuthenticatedSafe(authSafe, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bag := range bags {
+		if !bag.ID.Equal(oidPkcs8ShroudedKeyBagType) {
+			continue
+		}
+		var info encryptedPrivateKeyInfo
+		if _, err = asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+			t.Fatal(err)
+		}
+		if !info.GetAlgorithm().Algorithm.Equal(oidPBES2) {
+			t.Errorf("expected the private key to be encrypted with PBES2, found %s", info.GetAlgorithm().Algorithm)
+		}
+	}
+
+	before, err := DecodeAll(legacy, "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := DecodeAll(p12, "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != len(after) {
+		t.Fatalf("expected %d entries, found %d", len(before), len(after))
+	}
+	for i := range before {
+		if before[i].FriendlyName != after[i].FriendlyName || !bytes.Equal(before[i].LocalKeyID, after[i].LocalKeyID) {
+			t.Errorf("expected the attributes of entry %d to be preserved", i)
+		}
+		if !before[i].Certificate.Equal(after[i].Certificate) {
+			t.Errorf("expected the certificate of entry %d to be preserved", i)
+		}
+		if (before[i].PrivateKey == nil) != (after[i].PrivateKey == nil) {
+			t.Errorf("expected the private key of entry %d to be preserved", i)
+		}
+	}
+
+	if _, err = ReEncrypt(legacy, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected incorrect password, got err: %v", err)
+	}
+}
+
+func TestReEncryptKeepsBagsVerbatim(t *testing.T) {
+	legacy, _ := base64.StdEncoding.DecodeString(unsortedAttributesTestData)
+	p12, err := ReEncrypt(legacy, "unsorted", WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES128CBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certBags := func(p12 []byte) (raw [][]byte) {
+		bags, _, _, err := (&DecodeOptions{Password: passwordString("unsorted")}).getSafeContents(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bag := range bags {
+			if bag.ID.Equal(oidCertBagType) {
+				raw = append(raw, bag.Raw)
+			}
+		}
+		return raw
+	}
+	before, after := certBags(legacy), certBags(p12)
+	if len(before) != 1 || len(after) != 1 {
+		t.Fatalf("expected a single cert bag, found %d and %d", len(before), len(after))
+	}
+	if !bytes.Equal(before[0], after[0]) {
+		t.Error("expected the cert bag to be copied byte for byte")
+	}
+}
+
+func TestReEncryptEncryptedKeyBag(t *testing.T) {
+	key, cert := testIdentity(t)
+	legacy, err := Encode(key, cert, nil, "keybag", WithKeyProtection(EncryptedKeyBag))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p12, err := ReEncrypt(legacy, "keybag", WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBEWithSHAAnd40BitRC2CBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := EncryptedParts(p12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 encrypted ContentInfos, found %d", len(parts))
+	}
+	keyParts := 0
+	for i, part := range parts {
+		plaintext, err := part.Decrypt("keybag")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bags []rawSafeBag
+		if _, err = asn1.Unmarshal(plaintext, &bags); err != nil {
+			t.Fatal(err)
+		}
+		expected := oidPbewithSHAAnd40BitRC2CBC
+		if len(bags) == 1 && bags[0].ID.Equal(oidKeyBagType) {
+			expected = oidPBES2
+			keyParts++
+		}
+		if !part.Algorithm.Algorithm.Equal(expected) {
+			t.Errorf("ContentInfo %d: expected %s, found %s", i, expected, part.Algorithm.Algorithm)
+		}
+	}
+	if keyParts != 1 {
+		t.Errorf("expected the keyBag in an encrypted ContentInfo of its own, found %d", keyParts)
+	}
+	if _, err = DecodeAll(p12, "keybag"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReEncryptKeepsContentInfos(t *testing.T) {
+	key, cert := testIdentity(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCert(t, "other", nil)
+
+	// a layout Encode never produces: the certificates split between an
+	// encrypted and an unencrypted ContentInfo, and the key in a third
+	encrypted := NewEncryptedContentInfoBuilder(PBEWithSHAAnd40BitRC2CBC, nil, 1000)
+	encrypted.AddCertificate(cert.Raw, NewLocalKeyIDAttribute([]byte{1}))
+	plain := NewContentInfoBuilder()
+	plain.AddCertificate(other.cert.Raw)
+	plain.AddCertificate(other.cert.Raw)
+	keys := NewContentInfoBuilder()
+	keys.AddShroudedKey(pkcs8, PBEWithSHAAnd3KeyTripleDESCBC, nil, 1000, NewLocalKeyIDAttribute([]byte{1}))
+	pfx := NewPFXBuilder(nil, 1000)
+	pfx.Add(keys)
+	pfx.Add(encrypted)
+	pfx.Add(plain)
+	legacy, err := pfx.Build([]byte("layout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p12, err := ReEncrypt(legacy, "layout", WithKeyAlgorithm(PBES2AES256CBC), WithCertAlgorithm(PBES2AES256CBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout := func(p12 []byte) (contentTypes []string, bagCounts []int) {
+		_, authSafe, err := parsePfx(p12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var authenticatedSafe []contentInfo
+		if _, err = asn1.Unmarshal(authSafe, &authenticatedSafe); err != nil {
+			t.Fatal(err)
+		}
+		p, _ := bmpString([]byte("layout"))
+		for i, ci := range authenticatedSafe {
+			bags, _, err := new(DecodeOptions).decryptContentInfo(ci, i, p, DefaultMaxBags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentTypes = append(contentTypes, ci.ContentType.String())
+			bagCounts = append(bagCounts, len(bags))
+		}
+		return contentTypes, bagCounts
+	}
+	beforeTypes, beforeCounts := layout(legacy)
+	afterTypes, afterCounts := layout(p12)
+	if len(afterTypes) != 3 {
+		t.Fatalf("expected the 3 ContentInfos to be kept, found %d", len(afterTypes))
+	}
+	for i := range beforeTypes {
+		if beforeTypes[i] != afterTypes[i] || beforeCounts[i] != afterCounts[i] {
+			t.Errorf("ContentInfo %d: expected %s with %d bags, found %s with %d", i, beforeTypes[i], beforeCounts[i], afterTypes[i], afterCounts[i])
+		}
+	}
+
+	entries, err := DecodeAll(p12, "layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].PrivateKey == nil || !entries[0].Certificate.Equal(cert) {
+		t.Errorf("expected the identity and the 2 other certificates, found %d entries", len(entries))
+	}
+}
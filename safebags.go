@@ -1,7 +1,6 @@
 package pkcs12
 
 import (
-	"crypto/x509"
 	"encoding/asn1"
 	"fmt"
 )
@@ -26,29 +25,89 @@ type certBag struct {
 	Data []byte `asn1:"tag:0,explicit"`
 }
 
-func decodePkcs8ShroudedKeyBag(asn1Data, password []byte) (privateKey interface{}, err error) {
+// isKeyBag reports whether a safe bag of type id holds a private key.
+func isKeyBag(id asn1.ObjectIdentifier) bool {
+	return id.Equal(oidPkcs8ShroudedKeyBagType) || id.Equal(oidKeyBagType)
+}
+
+// decodeKeyBag returns the private key of a pkcs8ShroudedKeyBag or keyBag.
+func (opts *DecodeOptions) decodeKeyBag(bag *safeBag, password []byte) (privateKey interface{}, err error) {
+	pkData, err := opts.privateKeyDER(bag, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if privateKey, err = parsePKCS8PrivateKey(pkData); err != nil {
+		if _, ok := err.(NotImplementedError); !ok {
+			err = fmt.Errorf("error parsing PKCS8 private key: %v", err)
+		}
+		return nil, err
+	}
+	return
+}
+
+// privateKeyDER returns the PKCS#8 DER encoding of the private key in a
+// pkcs8ShroudedKeyBag, decrypting it, or in a keyBag, copying it so that it
+// outlives the decrypted SafeContents.
+func (opts *DecodeOptions) privateKeyDER(bag *safeBag, password []byte) ([]byte, error) {
+	if bag.ID.Equal(oidKeyBagType) {
+		return append([]byte(nil), bag.Value.Bytes...), nil
+	}
+	return opts.decryptPkcs8ShroudedKeyBag(bag.Value.Bytes, password)
+}
+
+// decryptPkcs8ShroudedKeyBag returns the PKCS#8 DER encoding of the private key in the bag.
+func (opts *DecodeOptions) decryptPkcs8ShroudedKeyBag(asn1Data, password []byte) (pkData []byte, err error) {
 	pkinfo := new(encryptedPrivateKeyInfo)
 	if _, err = asn1.Unmarshal(asn1Data, pkinfo); err != nil {
 		err = fmt.Errorf("error decoding PKCS8 shrouded key bag: %v", err)
 		return nil, err
 	}
 
-	pkData, err := pbDecrypt(pkinfo, password)
+	pkData, err = opts.pbDecrypt(pkinfo, password)
 	if err != nil {
 		err = fmt.Errorf("error decrypting PKCS8 shrouded key bag: %v", err)
-		return
+		return nil, err
 	}
 
 	rv := new(asn1.RawValue)
 	if _, err = asn1.Unmarshal(pkData, rv); err != nil {
-		err = fmt.Errorf("could not decode decrypted private key data")
+		return nil, fmt.Errorf("could not decode decrypted private key data")
 	}
+	return pkData, nil
+}
 
-	if privateKey, err = x509.ParsePKCS8PrivateKey(pkData); err != nil {
-		err = fmt.Errorf("error parsing PKCS8 private key: %v", err)
-		return nil, err
+// secretKeyBag returns the pkcs8ShroudedKeyBag that a secretBag stands for
+// when its secret is a shrouded key, with a secretTypeId of
+// pkcs8ShroudedKeyBag, as a few nonconforming producers nest private keys.
+// It keeps the attributes of the secretBag.
+func secretKeyBag(bag rawSafeBag) (rawSafeBag, bool) {
+	var secret struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue `asn1:"tag:0,explicit"`
 	}
-	return
+	if rest, err := asn1.Unmarshal(bag.Value.Bytes, &secret); err != nil || len(rest) != 0 {
+		return bag, false
+	}
+	if !secret.ID.Equal(oidPkcs8ShroudedKeyBagType) {
+		return bag, false
+	}
+	bag.ID = oidPkcs8ShroudedKeyBagType
+	bag.Value = secret.Value
+	return bag, true
+}
+
+// certBagType returns the certId of a cert bag, without decoding the
+// certificate it holds.
+func certBagType(asn1Data []byte) (asn1.ObjectIdentifier, error) {
+	var bag struct {
+		ID   asn1.ObjectIdentifier
+		Data asn1.RawValue `asn1:"tag:0,explicit"`
+	}
+	if _, err := asn1.Unmarshal(asn1Data, &bag); err != nil {
+		return nil, fmt.Errorf("error decoding cert bag: %v", err)
+	}
+	return bag.ID, nil
 }
 
 func decodeCertBag(asn1Data []byte) (x509Certificates []byte, err error) {
@@ -60,5 +119,26 @@ func decodeCertBag(asn1Data []byte) (x509Certificates []byte, err error) {
 	if !bag.ID.Equal(oidCertTypeX509Certificate) {
 		return nil, NotImplementedError("only X509 certificates are supported")
 	}
+	if len(bag.Data) > 0 && bag.Data[0] == asn1.TagOctetString {
+		// some producers wrap the certificate in a second OCTET STRING; a
+		// certificate itself is a SEQUENCE, so this is unambiguous
+		var wrapped []byte
+		if rest, err := asn1.Unmarshal(bag.Data, &wrapped); err == nil && len(rest) == 0 {
+			return wrapped, nil
+		}
+	}
 	return bag.Data, nil
 }
+
+// splitCertificates splits the concatenated DER encodings in data, without
+// parsing them as certificates.
+func splitCertificates(data []byte) (ders [][]byte, err error) {
+	for rest := data; len(rest) > 0; {
+		var der asn1.RawValue
+		if rest, err = asn1.Unmarshal(rest, &der); err != nil {
+			return nil, err
+		}
+		ders = append(ders, der.FullBytes)
+	}
+	return ders, nil
+}
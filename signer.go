@@ -0,0 +1,35 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ToSigner extracts the private key of pfxData as a crypto.Signer, along with
+// its certificate, for code that only needs to sign, such as TLS or JWS. The
+// RSA, ECDSA and Ed25519 keys of the standard library all implement
+// crypto.Signer; DSA keys do not, and fail. See DecodeOptions.ToSigner.
+func ToSigner(pfxData []byte, password string) (crypto.Signer, *x509.Certificate, error) {
+	opts := &DecodeOptions{Password: passwordString(password)}
+	return opts.ToSigner(pfxData)
+}
+
+// ToSigner is like the package-level ToSigner, but obtains the password from
+// opts.Password. The private key and certificate are those DecodeChain
+// returns, and an error is returned if pfxData holds no private key.
+func (opts *DecodeOptions) ToSigner(pfxData []byte) (crypto.Signer, *x509.Certificate, error) {
+	privateKey, certificate, _, err := opts.DecodeChain(pfxData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if privateKey == nil {
+		return nil, nil, errors.New("pkcs12: no private key to sign with")
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("pkcs12: private key of type %T is not a crypto.Signer", privateKey)
+	}
+	return signer, certificate, nil
+}
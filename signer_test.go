@@ -0,0 +1,46 @@
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestToSigner(t *testing.T) {
+	leaf := newTestCert(t, "leaf", nil)
+	ca := newTestCert(t, "ca", nil)
+	p12 := buildChainPFX(t, "signer", leaf, ca)
+
+	signer, cert, err := ToSigner(p12, "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(leaf.cert) {
+		t.Errorf("expected the leaf certificate, got %q", cert.Subject.CommonName)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Errorf("expected the signature to verify with the certificate")
+	}
+
+	certOnly, err := EncodeCertificate(leaf.cert, "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ToSigner(certOnly, "signer"); err == nil || !strings.Contains(err.Error(), "no private key") {
+		t.Errorf("expected PFX data without a private key to fail, got: %v", err)
+	}
+
+	dsaP12, _ := base64.StdEncoding.DecodeString(dsaTestData)
+	if _, _, err = ToSigner(dsaP12, "dsa"); err == nil || !strings.Contains(err.Error(), "*dsa.PrivateKey") {
+		t.Errorf("expected a DSA key to fail, got: %v", err)
+	}
+}